@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An override replaces the Go type and type tags generated for a column, for
+// example to read a binary column into a proto message.
+type override struct {
+	Type string
+	Tags []string
+}
+
+// parseOverride parses an override of the form table.column=Type[,tag...].
+func parseOverride(s string) (string, *override, error) {
+	eq := strings.Index(s, "=")
+	if eq < 0 || !strings.Contains(s[:eq], ".") {
+		return "", nil, fmt.Errorf("bad override %q: expected table.column=Type[,tag...]", s)
+	}
+	parts := strings.Split(s[eq+1:], ",")
+	if parts[0] == "" {
+		return "", nil, fmt.Errorf("bad override %q: missing type", s)
+	}
+	return s[:eq], &override{Type: parts[0], Tags: parts[1:]}, nil
+}
+
+// generateOptions configures generate.
+type generateOptions struct {
+	Package string
+	// Func is the name of the generated registration function.
+	Func string
+	// ImplicitNull reads nullable scalar columns into values tagged
+	// `implicitnull` instead of pointers.
+	ImplicitNull bool
+	// Overrides maps table.column to the type to generate for it.
+	Overrides map[string]*override
+	// Imports are added to the generated file for the overridden types.
+	Imports []string
+}
+
+// A field is a generated struct field.
+type field struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+// A model is a generated struct and its registration.
+type model struct {
+	Table         string
+	Type          string
+	AutoIncrement bool
+	Fields        []*field
+}
+
+// generate returns the formatted Go source of the structs for tables and of a
+// function registering them with a sqlgen.Schema.
+func generate(tables []*table, options generateOptions) ([]byte, error) {
+	imports := map[string]bool{"github.com/samson-crypto/thunder/sqlgen": true}
+	for _, path := range options.Imports {
+		imports[path] = true
+	}
+
+	var models []*model
+	types := make(map[string]string)
+	for _, t := range tables {
+		m, err := makeModel(t, options, imports)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := types[m.Type]; ok {
+			return nil, fmt.Errorf("tables %s and %s both generate type %s", other, t.Name, m.Type)
+		}
+		types[m.Type] = t.Name
+		models = append(models, m)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by sqlgen-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", options.Package)
+
+	// Group the standard library imports before the others, as goimports does.
+	var standard, others []string
+	for path := range imports {
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			others = append(others, path)
+		} else {
+			standard = append(standard, path)
+		}
+	}
+	sort.Strings(standard)
+	sort.Strings(others)
+	b.WriteString("import (\n")
+	for _, path := range standard {
+		fmt.Fprintf(&b, "%q\n", path)
+	}
+	if len(standard) > 0 {
+		b.WriteString("\n")
+	}
+	for _, path := range others {
+		fmt.Fprintf(&b, "%q\n", path)
+	}
+	b.WriteString(")\n\n")
+
+	for _, m := range models {
+		fmt.Fprintf(&b, "// %s is a row of the %s table.\n", m.Type, m.Table)
+		fmt.Fprintf(&b, "type %s struct {\n", m.Type)
+		for _, f := range m.Fields {
+			fmt.Fprintf(&b, "%s %s", f.Name, f.Type)
+			if f.Tag != "" {
+				fmt.Fprintf(&b, " `sql:%s`", strconv.Quote(f.Tag))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("}\n\n")
+	}
+
+	fmt.Fprintf(&b, "// %s registers the generated types with schema.\n", options.Func)
+	fmt.Fprintf(&b, "func %s(schema *sqlgen.Schema) error {\n", options.Func)
+	for _, m := range models {
+		primaryKeyType := "sqlgen.UniqueId"
+		if m.AutoIncrement {
+			primaryKeyType = "sqlgen.AutoIncrement"
+		}
+		fmt.Fprintf(&b, "if err := schema.RegisterType(%q, %s, %s{}); err != nil {\nreturn err\n}\n", m.Table, primaryKeyType, m.Type)
+	}
+	b.WriteString("return nil\n}\n")
+
+	return format.Source(b.Bytes())
+}
+
+// makeModel builds the struct for t, adding the packages it uses to imports.
+func makeModel(t *table, options generateOptions, imports map[string]bool) (*model, error) {
+	m := &model{Table: t.Name, Type: typeName(t.Name)}
+
+	names := make(map[string]string)
+	hasPrimary := false
+	for _, c := range t.Columns {
+		name := fieldName(c.Name)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("table %s: columns %s and %s both generate field %s", t.Name, other, c.Name, name)
+		}
+		names[name] = c.Name
+
+		if c.AutoIncrement {
+			if hasPrimary || !c.Primary {
+				return nil, fmt.Errorf("table %s: auto-increment column %s must be the first primary key column", t.Name, c.Name)
+			}
+			m.AutoIncrement = true
+		}
+
+		typ, tags, err := fieldType(c, options.ImplicitNull)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %v", t.Name, err)
+		}
+		if o, ok := options.Overrides[t.Name+"."+c.Name]; ok {
+			typ, tags = o.Type, o.Tags
+		}
+		if strings.Contains(typ, "time.") {
+			imports["time"] = true
+		}
+		if strings.Contains(typ, "json.") {
+			imports["encoding/json"] = true
+		}
+
+		// Primary key columns must be written, so they never get readonly or
+		// default tags.
+		switch {
+		case c.Primary:
+			tags = append([]string{"primary"}, tags...)
+			hasPrimary = true
+		case c.Generated:
+			tags = append(tags, "readonly")
+		case c.DefaultExpression:
+			tags = append(tags, "default")
+		}
+
+		tag := ""
+		if makeSnake(name) != c.Name {
+			tag = c.Name
+		}
+		if len(tags) > 0 {
+			tag += "," + strings.Join(tags, ",")
+		}
+		m.Fields = append(m.Fields, &field{Name: name, Type: typ, Tag: tag})
+	}
+	if !hasPrimary {
+		return nil, fmt.Errorf("table %s has no primary key", t.Name)
+	}
+	return m, nil
+}
+
+// fieldType returns the Go type and type tags for c.
+func fieldType(c *column, implicitNull bool) (string, []string, error) {
+	var typ string
+	var tags []string
+	slice := false
+
+	switch c.DataType {
+	case "bool", "boolean":
+		typ = "bool"
+	case "tinyint":
+		typ = "int64"
+		if strings.HasPrefix(c.ColumnType, "tinyint(1)") {
+			typ = "bool"
+		}
+	case "smallint", "mediumint", "int", "integer", "bigint", "year":
+		typ = "int64"
+		if c.DataType == "bigint" && strings.Contains(c.ColumnType, "unsigned") {
+			typ = "uint64"
+		}
+	case "bit":
+		typ = "uint64"
+	case "float", "double", "real":
+		typ = "float64"
+	case "decimal", "numeric":
+		// Decimals are kept as strings so no precision is lost.
+		typ = "string"
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set", "time":
+		typ = "string"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		typ = "[]byte"
+		slice = true
+	case "json":
+		typ = "json.RawMessage"
+		tags = append(tags, "json")
+		slice = true
+	case "date", "datetime", "timestamp":
+		typ = "time.Time"
+	default:
+		return "", nil, fmt.Errorf("column %s has unsupported type %s", c.Name, c.ColumnType)
+	}
+
+	// Nil slices are written as NULL, so only scalars need pointers.
+	if c.Nullable && !slice {
+		if implicitNull {
+			tags = append(tags, "implicitnull")
+		} else {
+			typ = "*" + typ
+		}
+	}
+	return typ, tags, nil
+}
+
+// fieldName returns the exported Go name of a column, such as OrgId for
+// org_id.
+func fieldName(column string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range column {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteString("Column")
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// typeName returns the exported Go type of a table, the singular of its name,
+// such as LineItem for line_items.
+func typeName(table string) string {
+	name := fieldName(table)
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "sses"), strings.HasSuffix(name, "xes"),
+		strings.HasSuffix(name, "ches"), strings.HasSuffix(name, "shes"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") &&
+		!strings.HasSuffix(name, "us") && !strings.HasSuffix(name, "is"):
+		return name[:len(name)-1]
+	}
+	return name
+}
+
+// makeSnake returns the column sqlgen uses for a field without a column name
+// in its tag.
+func makeSnake(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && unicode.IsUpper(c) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	ddl, err := ioutil.ReadFile("testdata/schema.sql")
+	require.NoError(t, err)
+	tables, err := parseDDL(string(ddl))
+	require.NoError(t, err)
+
+	key, avatar, err := parseOverride("users.avatar=*pb.Avatar,binary")
+	require.NoError(t, err)
+	source, err := generate(tables, generateOptions{
+		Package:   "models",
+		Func:      "RegisterTables",
+		Overrides: map[string]*override{key: avatar},
+		Imports:   []string{"github.com/app/pb"},
+	})
+	require.NoError(t, err)
+
+	// Regenerate with
+	//   go run . -ddl testdata/schema.sql -type users.avatar=*pb.Avatar,binary -import github.com/app/pb > testdata/tables.go.golden
+	expected, err := ioutil.ReadFile("testdata/tables.go.golden")
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(source))
+}
+
+func TestGenerateImplicitNull(t *testing.T) {
+	tables, err := parseDDL(`CREATE TABLE notes (
+		id bigint NOT NULL PRIMARY KEY,
+		body text,
+		data blob,
+		created_at datetime DEFAULT NULL
+	)`)
+	require.NoError(t, err)
+
+	source, err := generate(tables, generateOptions{Package: "models", Func: "Register", ImplicitNull: true})
+	require.NoError(t, err)
+	// Slices stay nil for NULL, so they need no tag.
+	assert.Contains(t, string(source), `type Note struct {
+	Id        int64  `+"`sql:\",primary\"`"+`
+	Body      string `+"`sql:\",implicitnull\"`"+`
+	Data      []byte
+	CreatedAt time.Time `+"`sql:\",implicitnull\"`"+`
+}`)
+	assert.Contains(t, string(source), `schema.RegisterType("notes", sqlgen.UniqueId, Note{})`)
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, ddl := range []string{
+		"CREATE TABLE logs (message text)",
+		"CREATE TABLE points (shape geometry PRIMARY KEY)",
+		"CREATE TABLE events (org_id bigint, id bigint AUTO_INCREMENT, PRIMARY KEY (org_id, id))",
+		"CREATE TABLE users (org_id bigint PRIMARY KEY, orgId bigint)",
+		"CREATE TABLE users (id bigint PRIMARY KEY); CREATE TABLE user (id bigint PRIMARY KEY)",
+	} {
+		tables, err := parseDDL(ddl)
+		require.NoError(t, err)
+		_, err = generate(tables, generateOptions{Package: "models", Func: "Register"})
+		assert.Error(t, err, ddl)
+	}
+
+	_, _, err := parseOverride("avatar=[]byte")
+	assert.Error(t, err)
+	_, _, err = parseOverride("users.avatar=")
+	assert.Error(t, err)
+}
+
+func TestNames(t *testing.T) {
+	for column, field := range map[string]string{
+		"id":          "Id",
+		"org_id":      "OrgId",
+		"createdAt":   "CreatedAt",
+		"address2":    "Address2",
+		"2fa_enabled": "Column2faEnabled",
+	} {
+		assert.Equal(t, field, fieldName(column))
+	}
+
+	for table, typ := range map[string]string{
+		"users":      "User",
+		"line_items": "LineItem",
+		"companies":  "Company",
+		"addresses":  "Address",
+		"boxes":      "Box",
+		"batches":    "Batch",
+		"status":     "Status",
+		"analysis":   "Analysis",
+		"user_data":  "UserData",
+	} {
+		assert.Equal(t, typ, typeName(table))
+	}
+}
@@ -0,0 +1,126 @@
+// Command sqlgen-gen generates sqlgen model structs and their registration
+// from an existing MySQL database or from a DDL file, so that the models can
+// be regenerated after every migration. For example,
+//   sqlgen-gen -dsn 'user:pass@tcp(localhost:3306)/app' -package models -out models/tables.go
+//   sqlgen-gen -ddl schema.sql -package models -out models/tables.go
+// writes a struct for every table, with pointers for nullable columns, and a
+// RegisterTables function that registers them with a *sqlgen.Schema.
+//
+// Columns can be given a custom type with -type, for example to read a binary
+// column into a proto message:
+//   sqlgen-gen -ddl schema.sql -import github.com/app/pb -type 'users.settings=pb.Settings,binary'
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	dsn := flag.String("dsn", "", "MySQL data source name of the database to read from information_schema")
+	ddl := flag.String("ddl", "", "file of CREATE TABLE statements to read instead of a database")
+	tableNames := flag.String("tables", "", "comma-separated tables to generate (default all)")
+	out := flag.String("out", "", "output file (default stdout)")
+	options := generateOptions{Overrides: make(map[string]*override)}
+	flag.StringVar(&options.Package, "package", "models", "package of the generated file")
+	flag.StringVar(&options.Func, "func", "RegisterTables", "name of the generated registration function")
+	flag.BoolVar(&options.ImplicitNull, "implicitnull", false, "read nullable columns into values tagged implicitnull instead of pointers")
+	var overrides, imports stringList
+	flag.Var(&overrides, "type", "type of a column, as table.column=Type[,tag...] (repeatable)")
+	flag.Var(&imports, "import", "package to import for -type (repeatable)")
+	flag.Parse()
+
+	if (*dsn == "") == (*ddl == "") {
+		log.Fatal("exactly one of -dsn and -ddl is required")
+	}
+	for _, value := range overrides {
+		key, o, err := parseOverride(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.Overrides[key] = o
+	}
+	options.Imports = imports
+
+	tables, err := loadTables(*dsn, *ddl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *tableNames != "" {
+		tables, err = filterTables(tables, strings.Split(*tableNames, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	source, err := generate(tables, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(source)
+	} else {
+		err = ioutil.WriteFile(*out, source, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadTables reads the tables from the database at dsn, or else from the DDL
+// file at path.
+func loadTables(dsn string, path string) ([]*table, error) {
+	if dsn == "" {
+		ddl, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseDDL(string(ddl))
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return loadInformationSchema(context.Background(), db)
+}
+
+// filterTables returns the tables named in names, in the order of tables.
+func filterTables(tables []*table, names []string) ([]*table, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	var filtered []*table
+	for _, t := range tables {
+		if wanted[t.Name] {
+			filtered = append(filtered, t)
+			delete(wanted, t.Name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown table %s", name)
+	}
+	return filtered, nil
+}
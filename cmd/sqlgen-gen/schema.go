@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A table is a table read from the database or a DDL file.
+type table struct {
+	Name    string
+	Columns []*column
+}
+
+// A column is a column of a table.
+type column struct {
+	Name string
+	// DataType is the lowercase type name, such as "bigint".
+	DataType string
+	// ColumnType is the lowercase full type, such as "tinyint(1) unsigned".
+	ColumnType string
+
+	Nullable      bool
+	Primary       bool
+	AutoIncrement bool
+	// Generated marks generated columns and columns set ON UPDATE, which
+	// sqlgen never writes.
+	Generated bool
+	// DefaultExpression marks columns whose default is an expression, such as
+	// CURRENT_TIMESTAMP, which sqlgen does not insert.
+	DefaultExpression bool
+}
+
+// loadInformationSchema reads the tables of the current database from
+// information_schema. Views are skipped.
+func loadInformationSchema(ctx context.Context, db *sql.DB) ([]*table, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.table_name, c.column_name, c.data_type, c.column_type, c.is_nullable = 'YES',
+			c.column_key = 'PRI', c.extra, c.column_default
+		FROM information_schema.columns c
+		JOIN information_schema.tables t
+			ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = DATABASE() AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name, c.ordinal_position
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []*table
+	for rows.Next() {
+		var tableName, extra string
+		var columnDefault sql.NullString
+		c := &column{}
+		if err := rows.Scan(&tableName, &c.Name, &c.DataType, &c.ColumnType, &c.Nullable, &c.Primary, &extra, &columnDefault); err != nil {
+			return nil, err
+		}
+		c.DataType = strings.ToLower(c.DataType)
+		c.ColumnType = strings.ToLower(c.ColumnType)
+		extra = strings.ToLower(extra)
+		c.AutoIncrement = strings.Contains(extra, "auto_increment")
+		c.Generated = strings.Contains(extra, "generated") && !strings.Contains(extra, "default_generated") ||
+			strings.Contains(extra, "on update")
+		c.DefaultExpression = strings.Contains(extra, "default_generated") ||
+			(columnDefault.Valid && strings.HasPrefix(strings.ToLower(columnDefault.String), "current_timestamp"))
+
+		if len(tables) == 0 || tables[len(tables)-1].Name != tableName {
+			tables = append(tables, &table{Name: tableName})
+		}
+		t := tables[len(tables)-1]
+		t.Columns = append(t.Columns, c)
+	}
+	return tables, rows.Err()
+}
+
+// parseDDL parses the CREATE TABLE statements in ddl, for example the output
+// of mysqldump --no-data. Other statements are ignored.
+func parseDDL(ddl string) ([]*table, error) {
+	var tables []*table
+	for _, statement := range splitTopLevel(stripComments(ddl), ';') {
+		tokens := tokenize(statement)
+		if len(tokens) < 3 || !strings.EqualFold(tokens[0], "create") {
+			continue
+		}
+		i := 1
+		if strings.EqualFold(tokens[i], "temporary") {
+			i++
+		}
+		if !strings.EqualFold(tokens[i], "table") {
+			continue
+		}
+		i++
+		if i+2 < len(tokens) && strings.EqualFold(tokens[i], "if") && strings.EqualFold(tokens[i+1], "not") && strings.EqualFold(tokens[i+2], "exists") {
+			i += 3
+		}
+		if i+1 >= len(tokens) || !strings.HasPrefix(tokens[i+1], "(") {
+			return nil, fmt.Errorf("cannot parse statement: %s", strings.TrimSpace(statement))
+		}
+
+		// Drop the database of a qualified name, as in `db`.`table`.
+		name := tokens[i]
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			name = name[dot+1:]
+		}
+		t, err := parseCreateTable(unquote(name), tokens[i+1])
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// parseCreateTable parses the parenthesized body of a CREATE TABLE statement.
+func parseCreateTable(name string, body string) (*table, error) {
+	t := &table{Name: name}
+	byName := make(map[string]*column)
+
+	for _, definition := range splitTopLevel(body[1:len(body)-1], ',') {
+		tokens := tokenize(definition)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(tokens[0]) {
+		case "primary":
+			// PRIMARY KEY (a, b)
+			for _, token := range tokens[1:] {
+				if !strings.HasPrefix(token, "(") {
+					continue
+				}
+				for _, key := range splitTopLevel(token[1:len(token)-1], ',') {
+					// Drop prefix lengths, as in `name`(10).
+					keyName := unquote(tokenize(key)[0])
+					c, ok := byName[strings.ToLower(keyName)]
+					if !ok {
+						return nil, fmt.Errorf("table %s: unknown primary key column %s", name, keyName)
+					}
+					c.Primary = true
+				}
+				break
+			}
+			continue
+		case "key", "index", "unique", "constraint", "foreign", "fulltext", "spatial", "check":
+			continue
+		}
+
+		c, err := parseColumn(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %v", name, err)
+		}
+		t.Columns = append(t.Columns, c)
+		byName[strings.ToLower(c.Name)] = c
+	}
+	return t, nil
+}
+
+// parseColumn parses the tokens of a column definition.
+func parseColumn(tokens []string) (*column, error) {
+	if len(tokens) < 2 {
+		return nil, fmt.Errorf("cannot parse column definition %s", strings.Join(tokens, " "))
+	}
+	c := &column{
+		Name:     unquote(tokens[0]),
+		DataType: strings.ToLower(tokens[1]),
+		Nullable: true,
+	}
+	columnType := []string{strings.ToLower(tokens[1])}
+
+	i := 2
+	if i < len(tokens) && strings.HasPrefix(tokens[i], "(") {
+		columnType[0] += strings.ToLower(tokens[i])
+		i++
+	}
+	for ; i < len(tokens); i++ {
+		token := strings.ToLower(tokens[i])
+		switch token {
+		case "unsigned", "zerofill":
+			columnType = append(columnType, token)
+		case "not":
+			if i+1 < len(tokens) && strings.EqualFold(tokens[i+1], "null") {
+				c.Nullable = false
+				i++
+			}
+		case "primary":
+			c.Primary = true
+			c.Nullable = false
+		case "auto_increment", "autoincrement":
+			c.AutoIncrement = true
+		case "generated", "as":
+			c.Generated = true
+		case "on":
+			if i+1 < len(tokens) && strings.EqualFold(tokens[i+1], "update") {
+				c.Generated = true
+			}
+		case "default":
+			if i+1 < len(tokens) {
+				next := strings.ToLower(tokens[i+1])
+				if strings.HasPrefix(next, "(") || strings.HasPrefix(next, "current_timestamp") || strings.HasPrefix(next, "now") {
+					c.DefaultExpression = true
+				}
+				i++
+			}
+		case "comment":
+			i++
+		}
+	}
+	c.ColumnType = strings.Join(columnType, " ")
+
+	switch c.DataType {
+	case "serial", "bigserial":
+		c.DataType = "bigint"
+		c.ColumnType = "bigint"
+		c.AutoIncrement = true
+		c.Nullable = false
+	}
+	if c.Primary {
+		c.Nullable = false
+	}
+	return c, nil
+}
+
+// stripComments removes the comments outside of quotes from s.
+func stripComments(s string) string {
+	var buffer strings.Builder
+	runes := []rune(s)
+	var quote rune
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-', r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+			r = ' '
+		}
+		if i < len(runes) {
+			buffer.WriteRune(r)
+		}
+	}
+	return buffer.String()
+}
+
+// splitTopLevel splits s at sep outside of parentheses and quotes.
+func splitTopLevel(s string, sep rune) []string {
+	var parts []string
+	depth := 0
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if rest := s[start:]; strings.TrimSpace(rest) != "" {
+		parts = append(parts, rest)
+	}
+	return parts
+}
+
+// tokenize splits s into words, quoted strings and parenthesized groups.
+func tokenize(s string) []string {
+	var tokens []string
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"' || r == '`':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:min(j+1, len(runes))]))
+			i = j + 1
+		case r == '(':
+			depth := 0
+			var quote rune
+			j := i
+			for ; j < len(runes); j++ {
+				c := runes[j]
+				if quote != 0 {
+					if c == quote {
+						quote = 0
+					}
+					continue
+				}
+				if c == '\'' || c == '"' || c == '`' {
+					quote = c
+				} else if c == '(' {
+					depth++
+				} else if c == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			tokens = append(tokens, string(runes[i:min(j+1, len(runes))]))
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && runes[j] != '(' && runes[j] != ',' {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
+
+// unquote removes the quotes around an identifier.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '`' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDDL(t *testing.T) {
+	ddl, err := ioutil.ReadFile("testdata/schema.sql")
+	require.NoError(t, err)
+	tables, err := parseDDL(string(ddl))
+	require.NoError(t, err)
+	require.Len(t, tables, 2)
+
+	users := tables[0]
+	assert.Equal(t, "users", users.Name)
+	assert.Equal(t, []*column{
+		{Name: "id", DataType: "bigint", ColumnType: "bigint(20)", Primary: true, AutoIncrement: true},
+		{Name: "org_id", DataType: "bigint", ColumnType: "bigint unsigned"},
+		{Name: "name", DataType: "varchar", ColumnType: "varchar(255)"},
+		{Name: "nickname", DataType: "varchar", ColumnType: "varchar(64)", Nullable: true},
+		{Name: "active", DataType: "tinyint", ColumnType: "tinyint(1)"},
+		{Name: "score", DataType: "double", ColumnType: "double", Nullable: true},
+		{Name: "avatar", DataType: "blob", ColumnType: "blob", Nullable: true},
+		{Name: "settings", DataType: "json", ColumnType: "json", Nullable: true},
+		{Name: "full_name", DataType: "varchar", ColumnType: "varchar(300)", Nullable: true, Generated: true},
+		{Name: "createdAt", DataType: "timestamp", ColumnType: "timestamp", DefaultExpression: true},
+		{Name: "updated_at", DataType: "datetime", ColumnType: "datetime", Generated: true, DefaultExpression: true},
+	}, users.Columns)
+
+	memberships := tables[1]
+	assert.Equal(t, "memberships", memberships.Name)
+	assert.Equal(t, []*column{
+		{Name: "org_id", DataType: "bigint", ColumnType: "bigint", Primary: true},
+		{Name: "user_id", DataType: "bigint", ColumnType: "bigint", Primary: true},
+		{Name: "role", DataType: "enum", ColumnType: "enum('admin', 'member')", Nullable: true},
+	}, memberships.Columns)
+}
+
+func TestParseDDLErrors(t *testing.T) {
+	_, err := parseDDL("CREATE TABLE users")
+	assert.Error(t, err)
+	_, err = parseDDL("CREATE TABLE users (id bigint, PRIMARY KEY (user_id))")
+	assert.Error(t, err)
+
+	tables, err := parseDDL("CREATE INDEX name ON users (name); INSERT INTO users VALUES (1)")
+	require.NoError(t, err)
+	assert.Empty(t, tables)
+}
@@ -0,0 +1,27 @@
+-- Table structure for table `users`; don't edit
+DROP TABLE IF EXISTS `users`;
+/*!40101 SET @saved_cs_client = @@character_set_client */;
+CREATE TABLE `users` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `org_id` bigint unsigned NOT NULL,
+  `name` varchar(255) NOT NULL DEFAULT '',
+  `nickname` varchar(64) DEFAULT NULL COMMENT 'shown; maybe',
+  `active` tinyint(1) NOT NULL DEFAULT '1',
+  `score` double,
+  `avatar` blob,
+  `settings` json DEFAULT NULL,
+  `full_name` varchar(300) GENERATED ALWAYS AS (concat(`name`, ' ', `nickname`)) VIRTUAL,
+  `createdAt` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `name` (`name`(10)),
+  KEY `org` (`org_id`),
+  CONSTRAINT `fk` FOREIGN KEY (`org_id`) REFERENCES `orgs` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS app.memberships (
+  org_id bigint NOT NULL,
+  user_id bigint NOT NULL,
+  role enum('admin', 'member'),
+  PRIMARY KEY (org_id, user_id)
+);
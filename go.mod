@@ -10,6 +10,7 @@ require (
 	github.com/graphql-go/graphql v0.4.19-0.20160928141709-8c317402d1b7
 	github.com/juju/errors v0.0.0-20160809030848-6f54ff631840 // indirect
 	github.com/kylelemons/godebug v1.1.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/ngaut/log v0.0.0-20160810023011-cec23d3e10b0 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/rakyll/statik v0.1.5
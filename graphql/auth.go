@@ -0,0 +1,142 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// AuthenticateFunc authenticates a connection using the credentials sent in an
+// "init" or "auth" message. It returns a context derived from ctx that carries
+// the authenticated identity, and the time at which the credentials expire. A
+// zero expiry means the credentials do not expire.
+type AuthenticateFunc func(ctx context.Context, credentials json.RawMessage) (context.Context, time.Time, error)
+
+// identityCtx is a context that looks up values in identity before falling
+// back to the embedded context. It lets a running computation pick up the
+// connection's current identity. withIdentity cancels the embedded context
+// when identity is done, and Deadline reports the earlier of their deadlines.
+type identityCtx struct {
+	context.Context
+	identity context.Context
+}
+
+func (c identityCtx) Deadline() (time.Time, bool) {
+	deadline, ok := c.Context.Deadline()
+	if identityDeadline, identityOk := c.identity.Deadline(); identityOk && (!ok || identityDeadline.Before(deadline)) {
+		return identityDeadline, true
+	}
+	return deadline, ok
+}
+
+func (c identityCtx) Value(key interface{}) interface{} {
+	if v := c.identity.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// withIdentity returns ctx with the values of the connection's current
+// identity, if the connection has been authenticated. The returned context is
+// also done when the identity's context is, for example because the
+// authenticator gave it a deadline.
+func (c *conn) withIdentity(ctx context.Context) context.Context {
+	c.authMu.Lock()
+	identity := c.identity
+	c.authMu.Unlock()
+
+	if identity == nil {
+		return ctx
+	}
+	if identity.Done() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-identity.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return identityCtx{Context: ctx, identity: identity}
+}
+
+// checkAuthenticated returns an error if the connection requires
+// authentication and has not yet authenticated.
+func (c *conn) checkAuthenticated() error {
+	if c.authenticate == nil {
+		return nil
+	}
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.identity == nil {
+		return NewSafeError("not authenticated")
+	}
+	return nil
+}
+
+// handleAuth authenticates the connection, replaces its identity, and reruns
+// all subscriptions so they reflect the new identity.
+func (c *conn) handleAuth(in *inEnvelope) error {
+	if c.authenticate == nil {
+		return NewSafeError("authentication not supported")
+	}
+
+	identity, expiry, err := c.authenticate(c.ctx, in.Message)
+	if err != nil {
+		return err
+	}
+
+	// A session stays bound to the identity that started it.
+	c.mu.Lock()
+	sess := c.session
+	c.mu.Unlock()
+	if sess != nil && c.sessions.identify(identity) != sess.identity {
+		return NewSafeError("session belongs to another identity")
+	}
+
+	c.authMu.Lock()
+	c.identity = identity
+	c.expiresAt = expiry
+	if c.authExpiry != nil {
+		c.authExpiry.Stop()
+		c.authExpiry = nil
+	}
+	if !expiry.IsZero() {
+		c.authExpiry = time.AfterFunc(time.Until(expiry), c.expireAuth)
+	}
+	c.authMu.Unlock()
+
+	c.mu.Lock()
+	for _, runner := range c.subscriptions {
+		runner.RerunImmediately()
+		runner.Invalidate()
+	}
+	c.mu.Unlock()
+
+	c.writeOrClose(outEnvelope{
+		ID:   in.ID,
+		Type: in.Type,
+	})
+	return nil
+}
+
+// expireAuth closes the connection once its credentials have expired without
+// being refreshed.
+func (c *conn) expireAuth() {
+	c.authMu.Lock()
+	// The credentials might have been refreshed after the timer fired.
+	refreshed := c.expiresAt.IsZero() || time.Now().Before(c.expiresAt)
+	c.authMu.Unlock()
+	if refreshed {
+		return
+	}
+
+	c.writeAndClose(outEnvelope{
+		Type:    "error",
+		Message: "credentials expired",
+	})
+}
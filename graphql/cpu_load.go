@@ -0,0 +1,47 @@
+package graphql
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultCPULoadInterval is the interval over which the default load of a
+// RerunLimiter averages CPU utilization.
+const DefaultCPULoadInterval = time.Second
+
+// CPULoad returns a load function for RerunLimiterConfig that reports the
+// process's CPU utilization across all CPUs, between 0 and 1. Utilization is
+// averaged over at least interval, and is 0 on platforms where the process's
+// CPU time is unknown.
+func CPULoad(interval time.Duration) func() float64 {
+	var mu sync.Mutex
+	var load float64
+	lastWall := time.Now()
+	lastCPU, err := processCPUTime()
+	if err != nil {
+		return func() float64 { return 0 }
+	}
+
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		elapsed := now.Sub(lastWall)
+		if elapsed < interval {
+			return load
+		}
+		cpu, err := processCPUTime()
+		if err != nil {
+			return load
+		}
+
+		load = float64(cpu-lastCPU) / float64(elapsed) / float64(runtime.NumCPU())
+		if load > 1 {
+			load = 1
+		}
+		lastWall, lastCPU = now, cpu
+		return load
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package graphql
+
+import (
+	"errors"
+	"time"
+)
+
+// processCPUTime is not supported on this platform.
+func processCPUTime() (time.Duration, error) {
+	return 0, errors.New("process cpu time not supported")
+}
@@ -0,0 +1,241 @@
+package graphql
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/samson-crypto/thunder/diff"
+)
+
+// OutboundQueueMetrics observes a connection's outbound queue.
+type OutboundQueueMetrics interface {
+	// QueueDepth is called with the number of queued messages whenever a
+	// message is queued or flushed.
+	QueueDepth(ctx context.Context, depth int)
+
+	// UpdateCoalesced is called when an update is merged into an update for
+	// the same subscription that has not yet been flushed.
+	UpdateCoalesced(ctx context.Context, id string)
+
+	// UpdatesDropped is called with the number of messages discarded when a
+	// slow consumer is disconnected.
+	UpdatesDropped(ctx context.Context, count int)
+}
+
+type nopOutboundQueueMetrics struct{}
+
+func (m *nopOutboundQueueMetrics) QueueDepth(ctx context.Context, depth int)      {}
+func (m *nopOutboundQueueMetrics) UpdateCoalesced(ctx context.Context, id string) {}
+func (m *nopOutboundQueueMetrics) UpdatesDropped(ctx context.Context, count int)  {}
+
+// outboundQueue buffers messages for a connection so computations never wait
+// on a slow socket. Updates for a subscription that has an update waiting to be
+// flushed are coalesced into a single update.
+//
+// The queue holds size messages, and up to size more while it overflows. The
+// connection gives an overflowing queue a grace period to drain below size.
+type outboundQueue struct {
+	size int
+
+	mu      sync.Mutex
+	items   []*outEnvelope
+	updates map[string]*outEnvelope
+	closed  bool
+
+	// overflows counts the times the queue started overflowing, and
+	// overflowing is set until it drains below size again.
+	overflows   int
+	overflowing bool
+
+	// notify has a buffered value whenever items might be non-empty.
+	notify chan struct{}
+}
+
+func newOutboundQueue(size int) *outboundQueue {
+	return &outboundQueue{
+		size:    size,
+		updates: make(map[string]*outEnvelope),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	pushCoalesced
+	pushOverflow
+	pushFull
+	pushClosed
+)
+
+// push queues out and returns the resulting queue depth. It returns
+// pushOverflow when the queue starts overflowing, and pushFull when there is
+// no room left at all.
+func (q *outboundQueue) push(out outEnvelope) (int, pushResult) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, pushClosed
+	}
+
+	if out.Type == "update" {
+		// Updates from an earlier subscription with the same id were computed
+		// against results the client has discarded, so are never merged.
+		if pending, ok := q.updates[out.ID]; ok && pending.instance == out.instance {
+			// The client still has the result the pending update was computed
+			// against, so diff from that result to the newest one.
+			pending.Message = diff.Diff(pending.previous, out.current)
+			if pending.Message == nil {
+				pending.Message = struct{}{}
+			}
+			pending.Metadata = out.Metadata
+			pending.current = out.current
+			return len(q.items), pushCoalesced
+		}
+	}
+
+	if len(q.items) >= 2*q.size {
+		return len(q.items), pushFull
+	}
+
+	result := pushQueued
+	if len(q.items) >= q.size && !q.overflowing {
+		q.overflowing = true
+		q.overflows++
+		result = pushOverflow
+	}
+
+	item := &out
+	q.items = append(q.items, item)
+	if out.Type == "update" {
+		q.updates[out.ID] = item
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return len(q.items), result
+}
+
+// overflowCount returns the number of times the queue started overflowing.
+func (q *outboundQueue) overflowCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.overflows
+}
+
+// stillOverflowing returns true if the queue has not drained below its size
+// since it started its overflow'th overflow.
+func (q *outboundQueue) stillOverflowing(overflow int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return !q.closed && q.overflowing && q.overflows == overflow
+}
+
+// pop removes the oldest message from the queue, waiting until there is one.
+// It returns false once the queue is closed or ctx is done.
+func (q *outboundQueue) pop(ctx context.Context) (*outEnvelope, int, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return nil, 0, false
+		}
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			if q.updates[item.ID] == item {
+				delete(q.updates, item.ID)
+			}
+			if len(q.items) < q.size {
+				q.overflowing = false
+			}
+			depth := len(q.items)
+			q.mu.Unlock()
+			return item, depth, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, 0, false
+		}
+	}
+}
+
+// close closes the queue and returns the number of discarded messages.
+func (q *outboundQueue) close() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	discarded := len(q.items)
+	q.items = nil
+	q.updates = nil
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return discarded
+}
+
+// enqueue queues out for the connection's writer, disconnecting the client if
+// it has fallen too far behind.
+func (c *conn) enqueue(out outEnvelope) {
+	depth, result := c.queue.push(out)
+	switch result {
+	case pushClosed:
+		return
+	case pushFull:
+		c.disconnectSlowConsumer(1)
+		return
+	case pushOverflow:
+		if c.slowConsumerGrace <= 0 {
+			c.disconnectSlowConsumer(0)
+			return
+		}
+		overflow := c.queue.overflowCount()
+		time.AfterFunc(c.slowConsumerGrace, func() {
+			if c.queue.stillOverflowing(overflow) {
+				c.disconnectSlowConsumer(0)
+			}
+		})
+	case pushCoalesced:
+		c.queueMetrics.UpdateCoalesced(c.ctx, out.ID)
+	}
+	c.queueMetrics.QueueDepth(c.ctx, depth)
+}
+
+// disconnectSlowConsumer closes the queue and the socket. unqueued is the
+// number of messages that were dropped without being queued.
+func (c *conn) disconnectSlowConsumer(unqueued int) {
+	dropped := c.queue.close() + unqueued
+	c.queueMetrics.UpdatesDropped(c.ctx, dropped)
+	log.Printf("disconnecting slow consumer: dropped %d messages\n", dropped)
+	c.socket.Close()
+}
+
+// flushOutbound writes queued messages to the socket until the queue is
+// closed.
+func (c *conn) flushOutbound() {
+	for {
+		out, depth, ok := c.queue.pop(c.ctx)
+		if !ok {
+			return
+		}
+		c.queueMetrics.QueueDepth(c.ctx, depth)
+		c.write(*out)
+		if out.closeSocket {
+			c.socket.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package graphql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboundQueueCoalesce(t *testing.T) {
+	q := newOutboundQueue(2)
+
+	depth, result := q.push(outEnvelope{ID: "a", Type: "update", previous: nil, current: map[string]interface{}{"value": 1}})
+	require.Equal(t, pushQueued, result)
+	require.Equal(t, 1, depth)
+
+	depth, result = q.push(outEnvelope{ID: "b", Type: "echo"})
+	require.Equal(t, pushQueued, result)
+	require.Equal(t, 2, depth)
+
+	// A second update for "a" replaces the queued one with a diff from the
+	// result the client last received.
+	depth, result = q.push(outEnvelope{
+		ID:       "a",
+		Type:     "update",
+		previous: map[string]interface{}{"value": 1},
+		current:  map[string]interface{}{"value": 2},
+	})
+	require.Equal(t, pushCoalesced, result)
+	require.Equal(t, 2, depth)
+
+	// The queue overflows by up to size messages before it is full.
+	depth, result = q.push(outEnvelope{ID: "c", Type: "echo"})
+	require.Equal(t, pushOverflow, result)
+	require.Equal(t, 3, depth)
+	_, result = q.push(outEnvelope{ID: "d", Type: "echo"})
+	require.Equal(t, pushQueued, result)
+	_, result = q.push(outEnvelope{ID: "e", Type: "echo"})
+	require.Equal(t, pushFull, result)
+	require.True(t, q.stillOverflowing(1))
+
+	out, depth, ok := q.pop(context.Background())
+	require.True(t, ok)
+	require.Equal(t, 3, depth)
+	require.Equal(t, "a", out.ID)
+	require.Equal(t, []interface{}{map[string]interface{}{"value": 2}}, out.Message)
+
+	// Once flushed, a new update for "a" is queued separately.
+	_, result = q.push(outEnvelope{ID: "a", Type: "update", previous: out.current, current: map[string]interface{}{"value": 3}})
+	require.Equal(t, pushQueued, result)
+
+	// Draining below size ends the overflow.
+	for i := 0; i < 3; i++ {
+		_, _, ok = q.pop(context.Background())
+		require.True(t, ok)
+	}
+	require.False(t, q.stillOverflowing(1))
+
+	require.Equal(t, 1, q.close())
+	_, _, ok = q.pop(context.Background())
+	require.False(t, ok)
+
+	_, result = q.push(outEnvelope{ID: "f", Type: "echo"})
+	require.Equal(t, pushClosed, result)
+}
+
+func TestOutboundQueueResubscribe(t *testing.T) {
+	q := newOutboundQueue(2)
+
+	_, result := q.push(outEnvelope{ID: "a", Type: "update", instance: 1, current: map[string]interface{}{"value": 1}})
+	require.Equal(t, pushQueued, result)
+
+	// The initial result of a new subscription with the same id is not a diff
+	// against the earlier subscription's result.
+	_, result = q.push(outEnvelope{
+		ID:       "a",
+		Type:     "update",
+		Message:  map[string]interface{}{"value": 2},
+		instance: 2,
+		current:  map[string]interface{}{"value": 2},
+	})
+	require.Equal(t, pushQueued, result)
+
+	out, _, _ := q.pop(context.Background())
+	require.Equal(t, uint64(1), out.instance)
+	out, _, _ = q.pop(context.Background())
+	require.Equal(t, uint64(2), out.instance)
+	require.Equal(t, map[string]interface{}{"value": 2}, out.Message)
+}
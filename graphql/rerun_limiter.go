@@ -0,0 +1,246 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RerunPriority orders subscription reruns waiting on a RerunLimiter. Clients
+// choose a subscription's priority with the "priority" extension of its
+// subscribe message, set to "high", "normal" or "low".
+type RerunPriority int
+
+const (
+	PriorityHigh RerunPriority = iota
+	PriorityNormal
+	PriorityLow
+
+	numRerunPriorities = 3
+)
+
+// parseRerunPriority reads the priority extension of a subscribe message.
+func parseRerunPriority(extensions map[string]interface{}) (RerunPriority, error) {
+	value, ok := extensions["priority"]
+	if !ok {
+		return PriorityNormal, nil
+	}
+	switch value {
+	case "high":
+		return PriorityHigh, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	default:
+		return 0, NewClientError("unknown priority %v", value)
+	}
+}
+
+// RerunLimiterConfig configures a RerunLimiter.
+type RerunLimiterConfig struct {
+	// Rate is the number of reruns allowed per second.
+	Rate float64
+	// Burst is the number of reruns allowed at once.
+	Burst int
+
+	// Load reports the process's load, between 0 and 1, and defaults to
+	// CPULoad(DefaultCPULoadInterval). Once Load exceeds LoadThreshold, which
+	// defaults to DefaultLoadThreshold, Rate is scaled down linearly, reaching
+	// MinRateFactor of Rate at full load.
+	Load          func() float64
+	LoadThreshold float64
+	// MinRateFactor must be in (0, 1], and defaults to DefaultMinRateFactor.
+	MinRateFactor float64
+}
+
+// DefaultLoadThreshold is the load above which a RerunLimiter slows down, if
+// its config does not set one.
+const DefaultLoadThreshold = 0.8
+
+// DefaultMinRateFactor is the fraction of its rate a RerunLimiter keeps at
+// full load, if its config does not set one.
+const DefaultMinRateFactor = 0.1
+
+// RerunLimiter is a token bucket that schedules subscription reruns. Reruns
+// waiting for a token are admitted in priority order, and in arrival order
+// within a priority.
+//
+// A RerunLimiter shared by all connections (see WithRerunLimiter) bounds the
+// process's rerun rate, spreading out the reruns of many subscriptions
+// invalidated at once. A RerunLimiter can also be created for every connection
+// (see WithConnectionRerunLimit).
+type RerunLimiter struct {
+	config RerunLimiterConfig
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	waiters [numRerunPriorities][]chan struct{}
+	timer   *time.Timer
+}
+
+// NewRerunLimiter creates a RerunLimiter with a full bucket.
+func NewRerunLimiter(config RerunLimiterConfig) (*RerunLimiter, error) {
+	config, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return newRerunLimiter(config), nil
+}
+
+// withDefaults validates config, and returns it with defaults for unset
+// fields.
+func (config RerunLimiterConfig) withDefaults() (RerunLimiterConfig, error) {
+	if config.Rate <= 0 {
+		return config, fmt.Errorf("rerun limiter rate must be positive, got %v", config.Rate)
+	}
+	if config.Burst < 1 {
+		return config, fmt.Errorf("rerun limiter burst must be at least 1, got %d", config.Burst)
+	}
+	if config.LoadThreshold < 0 || config.LoadThreshold >= 1 {
+		return config, fmt.Errorf("rerun limiter load threshold must be in [0, 1), got %v", config.LoadThreshold)
+	}
+	if config.MinRateFactor < 0 || config.MinRateFactor > 1 {
+		return config, fmt.Errorf("rerun limiter min rate factor must be in (0, 1], got %v", config.MinRateFactor)
+	}
+	if config.Load == nil {
+		config.Load = CPULoad(DefaultCPULoadInterval)
+	}
+	if config.LoadThreshold == 0 {
+		config.LoadThreshold = DefaultLoadThreshold
+	}
+	if config.MinRateFactor == 0 {
+		config.MinRateFactor = DefaultMinRateFactor
+	}
+	return config, nil
+}
+
+// newRerunLimiter creates a RerunLimiter for a config returned by
+// withDefaults.
+func newRerunLimiter(config RerunLimiterConfig) *RerunLimiter {
+	return &RerunLimiter{
+		config: config,
+		tokens: float64(config.Burst),
+		last:   time.Now(),
+	}
+}
+
+// rate returns the current rate, scaled down under load.
+func (l *RerunLimiter) rate() float64 {
+	load := l.config.Load()
+	if load <= l.config.LoadThreshold {
+		return l.config.Rate
+	}
+	if load > 1 {
+		load = 1
+	}
+	factor := 1 - (load-l.config.LoadThreshold)/(1-l.config.LoadThreshold)
+	if factor < l.config.MinRateFactor {
+		factor = l.config.MinRateFactor
+	}
+	return l.config.Rate * factor
+}
+
+// Wait blocks until a rerun with the given priority may run, or until ctx is
+// done.
+func (l *RerunLimiter) Wait(ctx context.Context, priority RerunPriority) error {
+	ready := make(chan struct{})
+
+	l.mu.Lock()
+	l.waiters[priority] = append(l.waiters[priority], ready)
+	l.dispatchLocked()
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		waiters := l.waiters[priority]
+		for i, w := range waiters {
+			if w == ready {
+				l.waiters[priority] = append(waiters[:i:i], waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The rerun was admitted while ctx finished; return its token.
+		l.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+// release returns the token of an admitted rerun that did not run.
+func (l *RerunLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *RerunLimiter) releaseLocked() {
+	l.tokens++
+	l.dispatchLocked()
+}
+
+// dispatchLocked admits waiters while tokens are available, and schedules
+// another dispatch for when the next token becomes available.
+func (l *RerunLimiter) dispatchLocked() {
+	now := time.Now()
+	rate := l.rate()
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > float64(l.config.Burst) {
+		l.tokens = float64(l.config.Burst)
+	}
+	l.last = now
+
+	for priority := range l.waiters {
+		for len(l.waiters[priority]) > 0 && l.tokens >= 1 {
+			close(l.waiters[priority][0])
+			l.waiters[priority] = l.waiters[priority][1:]
+			l.tokens--
+		}
+	}
+
+	if l.timer != nil || !l.hasWaitersLocked() {
+		return
+	}
+	delay := time.Duration((1 - l.tokens) / rate * float64(time.Second))
+	l.timer = time.AfterFunc(delay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.timer = nil
+		l.dispatchLocked()
+	})
+}
+
+func (l *RerunLimiter) hasWaitersLocked() bool {
+	for _, waiters := range l.waiters {
+		if len(waiters) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForRerun waits for the connection's and the process's rerun limiters,
+// if any, to admit a rerun.
+func (c *conn) waitForRerun(ctx context.Context, priority RerunPriority) error {
+	if c.connectionRerunLimiter != nil {
+		if err := c.connectionRerunLimiter.Wait(ctx, priority); err != nil {
+			return err
+		}
+	}
+	if c.rerunLimiter != nil {
+		if err := c.rerunLimiter.Wait(ctx, priority); err != nil {
+			if c.connectionRerunLimiter != nil {
+				c.connectionRerunLimiter.release()
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRerunLimiterPriority(t *testing.T) {
+	limiter, err := NewRerunLimiter(RerunLimiterConfig{Rate: 20, Burst: 1})
+	require.NoError(t, err)
+
+	// Use up the burst so the next reruns have to wait.
+	require.NoError(t, limiter.Wait(context.Background(), PriorityNormal))
+
+	var mu sync.Mutex
+	var order []RerunPriority
+	var wg sync.WaitGroup
+	for _, priority := range []RerunPriority{PriorityLow, PriorityNormal, PriorityHigh} {
+		wg.Add(1)
+		go func(priority RerunPriority) {
+			defer wg.Done()
+			require.NoError(t, limiter.Wait(context.Background(), priority))
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+		}(priority)
+		// Make sure the waiters queue up in order.
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	wg.Wait()
+	require.Equal(t, []RerunPriority{PriorityHigh, PriorityNormal, PriorityLow}, order)
+	require.True(t, time.Since(start) >= 80*time.Millisecond, "expected reruns to be spread out")
+}
+
+func TestRerunLimiterCanceled(t *testing.T) {
+	limiter, err := NewRerunLimiter(RerunLimiterConfig{Rate: 1, Burst: 1})
+	require.NoError(t, err)
+	require.NoError(t, limiter.Wait(context.Background(), PriorityNormal))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx, PriorityNormal))
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	require.False(t, limiter.hasWaitersLocked())
+}
+
+func TestRerunLimiterReleaseOnCancel(t *testing.T) {
+	connLimiter, err := NewRerunLimiter(RerunLimiterConfig{Rate: 1, Burst: 1})
+	require.NoError(t, err)
+	limiter, err := NewRerunLimiter(RerunLimiterConfig{Rate: 1, Burst: 1})
+	require.NoError(t, err)
+	require.NoError(t, limiter.Wait(context.Background(), PriorityNormal))
+	c := &conn{connectionRerunLimiter: connLimiter, rerunLimiter: limiter}
+
+	// The connection's token is returned when the process's limiter does not
+	// admit the rerun.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, c.waitForRerun(ctx, PriorityNormal))
+
+	connLimiter.mu.Lock()
+	defer connLimiter.mu.Unlock()
+	require.True(t, connLimiter.tokens >= 1)
+}
+
+func TestCPULoad(t *testing.T) {
+	load := CPULoad(0)
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+	value := load()
+	require.True(t, value > 0 && value <= 1, "expected load in (0, 1], got %v", value)
+}
+
+func TestRerunLimiterLoad(t *testing.T) {
+	load := 0.0
+	limiter, err := NewRerunLimiter(RerunLimiterConfig{
+		Rate:          100,
+		Burst:         1,
+		Load:          func() float64 { return load },
+		LoadThreshold: 0.5,
+		MinRateFactor: 0.2,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 100.0, limiter.rate())
+	load = 0.75
+	require.InDelta(t, 50.0, limiter.rate(), 1e-9)
+	load = 1
+	require.InDelta(t, 20.0, limiter.rate(), 1e-9)
+
+	limiter, err = NewRerunLimiter(RerunLimiterConfig{Rate: 100, Burst: 1, Load: func() float64 { return load }})
+	require.NoError(t, err)
+	require.InDelta(t, 100*DefaultMinRateFactor, limiter.rate(), 1e-9)
+
+	for _, factor := range []float64{-0.5, 1.5} {
+		_, err = NewRerunLimiter(RerunLimiterConfig{Rate: 100, Burst: 1, MinRateFactor: factor})
+		require.Error(t, err)
+	}
+}
+
+func TestParseRerunPriority(t *testing.T) {
+	priority, err := parseRerunPriority(nil)
+	require.NoError(t, err)
+	require.Equal(t, PriorityNormal, priority)
+
+	priority, err = parseRerunPriority(map[string]interface{}{"priority": "low"})
+	require.NoError(t, err)
+	require.Equal(t, PriorityLow, priority)
+
+	_, err = parseRerunPriority(map[string]interface{}{"priority": "urgent"})
+	require.Error(t, err)
+}
@@ -19,6 +19,14 @@ import (
 const (
 	DefaultMaxSubscriptions = 200
 	DefaultMinRerunInterval = 5 * time.Second
+
+	// DefaultMutationSettleWindow is how long an acknowledged mutation waits
+	// for invalidations that arrive asynchronously, such as from a binlog.
+	DefaultMutationSettleWindow = 100 * time.Millisecond
+
+	// DefaultSlowConsumerGrace is how long a client may keep its outbound
+	// queue full before it is disconnected.
+	DefaultSlowConsumerGrace = 5 * time.Second
 )
 
 type JSONSocket interface {
@@ -53,6 +61,21 @@ type conn struct {
 	writeMu sync.Mutex
 	socket  JSONSocket
 
+	// version is incremented for every update written to the socket. It is
+	// protected by writeMu so versions are monotonic on the wire.
+	version uint64
+
+	// queue, if set, buffers outgoing messages for a writer goroutine so
+	// computations do not block on a slow socket.
+	queue             *outboundQueue
+	queueMetrics      OutboundQueueMetrics
+	slowConsumerGrace time.Duration
+
+	// session is the resumable session the connection is attached to, if any.
+	// It is set under both writeMu and mu.
+	sessions *SessionStore
+	session  *session
+
 	schema         *Schema
 	mutationSchema *Schema
 	ctx            context.Context
@@ -66,14 +89,38 @@ type conn struct {
 
 	url string
 
+	// authenticate, if set, requires clients to authenticate with an "init"
+	// or "auth" message before subscribing. authMu protects identity, the
+	// context of the currently authenticated identity, and its expiry.
+	authenticate AuthenticateFunc
+	authMu       sync.Mutex
+	identity     context.Context
+	expiresAt    time.Time
+	authExpiry   *time.Timer
+
 	mutateMu sync.Mutex
 
 	mu            sync.Mutex
 	subscriptions map[string]*reactive.Rerunner
+	// instances counts subscriptions started, so an update can be told apart
+	// from one for an earlier subscription with the same id.
+	instances uint64
 
 	alwaysSpawnGoroutineFunc AlwaysSpawnGoroutineFunc
 	minRerunIntervalFunc     RerunIntervalFunc
 	maxSubscriptions         int
+	mutationAckTimeout       time.Duration
+	mutationSettleWindow     time.Duration
+
+	// settleMu protects invalidated, the subscriptions invalidated since they
+	// last finished computing, and settled, which is closed and replaced
+	// whenever one of them finishes.
+	settleMu    sync.Mutex
+	invalidated map[string]bool
+	settled     chan struct{}
+
+	rerunLimiter           *RerunLimiter
+	connectionRerunLimiter *RerunLimiter
 }
 
 type inEnvelope struct {
@@ -88,6 +135,17 @@ type outEnvelope struct {
 	Type     string                 `json:"type"`
 	Message  interface{}            `json:"message,omitempty"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Version  uint64                 `json:"version,omitempty"`
+
+	// previous and current are the results an update was computed from and
+	// to, used to coalesce queued updates and to record resumable sessions.
+	// instance identifies the subscription that computed the update.
+	previous interface{}
+	current  interface{}
+	instance uint64
+
+	// closeSocket closes the socket once the message is written.
+	closeSocket bool
 }
 
 type subscribeMessage struct {
@@ -95,15 +153,57 @@ type subscribeMessage struct {
 	Variables map[string]interface{} `json:"variables"`
 }
 
+type sessionMessage struct {
+	Token   string `json:"token"`
+	Version uint64 `json:"version"`
+}
+
 type mutateMessage struct {
 	Query     string                 `json:"query"`
 	Variables map[string]interface{} `json:"variables"`
 }
 
 func (c *conn) writeOrClose(out outEnvelope) {
+	if c.queue != nil {
+		c.enqueue(out)
+		return
+	}
+	c.write(out)
+}
+
+// writeAndClose writes out after any queued messages and then closes the
+// socket.
+func (c *conn) writeAndClose(out outEnvelope) {
+	if c.queue != nil {
+		out.closeSocket = true
+		c.enqueue(out)
+		return
+	}
+	c.write(out)
+	c.socket.Close()
+}
+
+func (c *conn) write(out outEnvelope) {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
+	switch out.Type {
+	case "update":
+		c.version++
+		out.Version = c.version
+		if c.session != nil {
+			c.session.record(out.ID, out.Version, out.current)
+		}
+	case "result":
+		// A mutation result reports the version of the last update written,
+		// which is the version at which the client has seen every update the
+		// mutation waited for. Without acknowledgement the result does not
+		// wait for updates, so that version would mean nothing.
+		if c.mutationAckTimeout > 0 {
+			out.Version = c.version
+		}
+	}
+
 	if err := c.socket.WriteJSON(out); err != nil {
 		if !isCloseError(err) {
 			c.socket.Close()
@@ -127,6 +227,10 @@ func (c *conn) handleSubscribe(in *inEnvelope) error {
 		return oops.Wrapf(err, "failed to parse subscribe message: %s", in.Message)
 	}
 
+	if err := c.checkAuthenticated(); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -154,14 +258,32 @@ func (c *conn) handleSubscribe(in *inEnvelope) error {
 		return err
 	}
 
+	priority, err := parseRerunPriority(in.Extensions)
+	if err != nil {
+		return err
+	}
+
 	var previous interface{}
+	if c.session != nil {
+		previous = c.session.subscribe(id, mustMarshalJson(subscribe))
+	}
 
 	e := c.executor
 
+	c.instances++
+	instance := c.instances
 	initial := true
 	c.subscriptionLogger.Subscribe(c.ctx, id, tags)
 	c.subscriptions[id] = reactive.NewRerunner(c.ctx, func(ctx context.Context) (interface{}, error) {
-		ctx = c.makeCtx(ctx)
+		defer c.settle(id)
+
+		if !initial {
+			if err := c.waitForRerun(ctx, priority); err != nil {
+				return nil, err
+			}
+		}
+
+		ctx = c.makeCtx(c.withIdentity(ctx))
 		ctx = batch.WithBatching(ctx)
 
 		start := time.Now()
@@ -194,7 +316,11 @@ func (c *conn) handleSubscribe(in *inEnvelope) error {
 
 		if err != nil {
 			if ErrorCause(err) == context.Canceled {
-				go c.closeSubscription(id)
+				// A subscription canceled because the connection is closing keeps
+				// its session state for the grace period.
+				if c.ctx.Err() == nil {
+					go c.closeSubscription(id)
+				}
 				return nil, err
 			}
 
@@ -237,6 +363,9 @@ func (c *conn) handleSubscribe(in *inEnvelope) error {
 				Type:     "update",
 				Message:  d,
 				Metadata: output.Metadata,
+				previous: computationInput.Previous,
+				current:  current,
+				instance: instance,
 			})
 		} else if initial {
 			// When a client first subscribes, they expect a response with the new diff (even if the diff is unchanged).
@@ -245,12 +374,18 @@ func (c *conn) handleSubscribe(in *inEnvelope) error {
 				Type:     "update",
 				Message:  struct{}{}, // This is an empty diff for any message, rather than nil which means the new message is empty.
 				Metadata: output.Metadata,
+				previous: computationInput.Previous,
+				current:  current,
+				instance: instance,
 			})
 		}
 
 		initial = false
 		return nil, nil
 	}, c.minRerunIntervalFunc(c.ctx, query), c.alwaysSpawnGoroutineFunc(c.ctx, query))
+	c.subscriptions[id].OnInvalidate(func() {
+		c.invalidate(id)
+	})
 
 	return nil
 }
@@ -263,6 +398,10 @@ func (c *conn) handleMutate(in *inEnvelope) error {
 		return oops.Wrapf(err, "failed to parse mutate message: %s", in.Message)
 	}
 
+	if err := c.checkAuthenticated(); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -289,7 +428,7 @@ func (c *conn) handleMutate(in *inEnvelope) error {
 		c.mutateMu.Lock()
 		defer c.mutateMu.Unlock()
 
-		ctx = c.makeCtx(ctx)
+		ctx = c.makeCtx(c.withIdentity(ctx))
 		ctx = batch.WithBatching(ctx)
 
 		start := time.Now()
@@ -339,14 +478,25 @@ func (c *conn) handleMutate(in *inEnvelope) error {
 			return nil, err
 		}
 
-		c.writeOrClose(outEnvelope{
+		result := outEnvelope{
 			ID:       id,
 			Type:     "result",
 			Message:  diff.Diff(nil, current),
 			Metadata: output.Metadata,
-		})
+		}
 
-		go c.rerunSubscriptionsImmediately()
+		if c.mutationAckTimeout > 0 {
+			// Hold back the result until subscriptions invalidated by the
+			// mutation have rerun, so its version covers their updates.
+			go func() {
+				c.rerunSubscriptionsImmediately()
+				c.awaitSubscriptionsSettled()
+				c.writeOrClose(result)
+			}()
+		} else {
+			c.writeOrClose(result)
+			go c.rerunSubscriptionsImmediately()
+		}
 
 		initial = false
 		go c.closeSubscription(id)
@@ -365,6 +515,90 @@ func (c *conn) rerunSubscriptionsImmediately() {
 	}
 }
 
+// invalidate records that subscription id was invalidated and has an update
+// to compute.
+func (c *conn) invalidate(id string) {
+	c.settleMu.Lock()
+	defer c.settleMu.Unlock()
+
+	if c.invalidated == nil {
+		c.invalidated = make(map[string]bool)
+	}
+	c.invalidated[id] = true
+}
+
+// settle records that subscription id finished computing, and wakes up
+// mutations waiting for subscriptions to settle.
+func (c *conn) settle(id string) {
+	c.settleMu.Lock()
+	defer c.settleMu.Unlock()
+
+	delete(c.invalidated, id)
+	if c.settled != nil {
+		close(c.settled)
+		c.settled = nil
+	}
+}
+
+// settledChan returns a channel that is closed when the next subscription
+// finishes computing.
+func (c *conn) settledChan() <-chan struct{} {
+	c.settleMu.Lock()
+	defer c.settleMu.Unlock()
+
+	if c.settled == nil {
+		c.settled = make(chan struct{})
+	}
+	return c.settled
+}
+
+// hasInvalidatedSubscriptions returns true if a subscription was invalidated
+// and has not yet finished computing its update.
+func (c *conn) hasInvalidatedSubscriptions() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settleMu.Lock()
+	defer c.settleMu.Unlock()
+
+	for id := range c.invalidated {
+		if _, ok := c.subscriptions[id]; ok {
+			return true
+		}
+		// The subscription was closed before it reran.
+		delete(c.invalidated, id)
+	}
+	return false
+}
+
+// awaitSubscriptionsSettled waits until every invalidated subscription has
+// written its update. Invalidations caused by a mutation can arrive after it
+// returns, for example from a binlog, so it keeps waiting for them for the
+// settle window. It never waits longer than the acknowledgement timeout.
+func (c *conn) awaitSubscriptionsSettled() {
+	timeout := time.NewTimer(c.mutationAckTimeout)
+	defer timeout.Stop()
+	window := time.NewTimer(c.mutationSettleWindow)
+	defer window.Stop()
+
+	windowElapsed := false
+	for {
+		settled := c.settledChan()
+		if windowElapsed && !c.hasInvalidatedSubscriptions() {
+			return
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		case <-window.C:
+			windowElapsed = true
+		case <-settled:
+		}
+	}
+}
+
 func (c *conn) closeSubscription(id string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -373,10 +607,26 @@ func (c *conn) closeSubscription(id string) {
 		runner.Stop()
 		delete(c.subscriptions, id)
 		c.subscriptionLogger.Unsubscribe(c.ctx, id)
+
+		// Subscriptions stopped by closeSubscriptions are no longer registered,
+		// so their session state is kept for the grace period.
+		if c.session != nil {
+			c.session.unsubscribe(id)
+		}
 	}
 }
 
 func (c *conn) closeSubscriptions() {
+	if c.queue != nil {
+		c.queue.close()
+	}
+
+	c.authMu.Lock()
+	if c.authExpiry != nil {
+		c.authExpiry.Stop()
+	}
+	c.authMu.Unlock()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -384,6 +634,96 @@ func (c *conn) closeSubscriptions() {
 		runner.Stop()
 		delete(c.subscriptions, id)
 	}
+
+	// Keep the session's results around so a reconnecting client can resume.
+	if c.session != nil {
+		c.sessions.detach(c.session)
+	}
+}
+
+// handleSession attaches the connection to a resumable session. The message
+// holds the token of the session to resume, if any, and the version of the
+// last update the client applied.
+func (c *conn) handleSession(in *inEnvelope) error {
+	if c.sessions == nil {
+		return NewSafeError("sessions not supported")
+	}
+
+	var message sessionMessage
+	if err := json.Unmarshal(in.Message, &message); err != nil {
+		return oops.Wrapf(err, "failed to parse session message: %s", in.Message)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil {
+		return NewSafeError("session already started")
+	}
+	if len(c.subscriptions) > 0 {
+		return NewSafeError("session must start before subscribing")
+	}
+
+	identity, err := c.sessionIdentity()
+	if err != nil {
+		return err
+	}
+	sess, err := c.sessions.attach(message.Token, identity)
+	if err != nil {
+		return err
+	}
+	if sess.token == message.Token {
+		sess.acknowledge(message.Version)
+	}
+
+	c.writeMu.Lock()
+	c.session = sess
+	// Continue the session's versions so acknowledgements stay unambiguous.
+	c.version = sess.lastVersion()
+	c.writeMu.Unlock()
+
+	c.writeOrClose(outEnvelope{
+		ID:      in.ID,
+		Type:    "session",
+		Message: sess.token,
+	})
+	return nil
+}
+
+// sessionIdentity returns the key of the connection's identity that binds
+// its session.
+func (c *conn) sessionIdentity() (string, error) {
+	if c.authenticate == nil {
+		return "", nil
+	}
+	if c.sessions.identify == nil {
+		return "", errors.New("sessions with authentication need a SessionIdentityFunc")
+	}
+	if err := c.checkAuthenticated(); err != nil {
+		return "", err
+	}
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.sessions.identify(c.identity), nil
+}
+
+// handleAck acknowledges all updates up to the version in the message.
+func (c *conn) handleAck(in *inEnvelope) error {
+	var version uint64
+	if err := json.Unmarshal(in.Message, &version); err != nil {
+		return oops.Wrapf(err, "failed to parse ack message: %s", in.Message)
+	}
+
+	c.mu.Lock()
+	sess := c.session
+	c.mu.Unlock()
+
+	if sess == nil {
+		return NewSafeError("no session")
+	}
+	sess.acknowledge(version)
+	return nil
 }
 
 func (c *conn) handle(e *inEnvelope) error {
@@ -398,6 +738,15 @@ func (c *conn) handle(e *inEnvelope) error {
 	case "mutate":
 		return c.handleMutate(e)
 
+	case "init", "auth":
+		return c.handleAuth(e)
+
+	case "session":
+		return c.handleSession(e)
+
+	case "ack":
+		return c.handleAck(e)
+
 	case "echo":
 		c.writeOrClose(outEnvelope{
 			ID:       e.ID,
@@ -501,10 +850,13 @@ func CreateConnection(ctx context.Context, socket JSONSocket, schema *Schema, op
 		subscriptions:      make(map[string]*reactive.Rerunner),
 		subscriptionLogger: &nopSubscriptionLogger{},
 		logger:             &nopGraphqlLogger{},
+		queueMetrics:       &nopOutboundQueueMetrics{},
+		slowConsumerGrace:  DefaultSlowConsumerGrace,
 		makeCtx: func(ctx context.Context) context.Context {
 			return ctx
 		},
 		maxSubscriptions:         DefaultMaxSubscriptions,
+		mutationSettleWindow:     DefaultMutationSettleWindow,
 		minRerunIntervalFunc:     func(context.Context, *Query) time.Duration { return DefaultMinRerunInterval },
 		alwaysSpawnGoroutineFunc: func(context.Context, *Query) bool { return false },
 	}
@@ -512,6 +864,10 @@ func CreateConnection(ctx context.Context, socket JSONSocket, schema *Schema, op
 		opt(c)
 	}
 
+	if c.queue != nil {
+		go c.flushOutbound()
+	}
+
 	return c
 }
 
@@ -563,6 +919,99 @@ func WithSubscriptionLogger(logger SubscriptionLogger) ConnectionOption {
 	}
 }
 
+// WithMutationAcknowledgement delays mutation results until all subscriptions
+// invalidated by the mutation have sent their updates, waiting at most timeout.
+// Every update carries a monotonically increasing version, and the result
+// carries the version at which the subscriptions caught up, so clients can
+// drop optimistic state once they have applied that version. Invalidations
+// that arrive after the mutation returns are awaited for the settle window,
+// see WithMutationSettleWindow.
+func WithMutationAcknowledgement(timeout time.Duration) ConnectionOption {
+	return func(c *conn) {
+		c.mutationAckTimeout = timeout
+	}
+}
+
+// WithMutationSettleWindow sets how long an acknowledged mutation waits for
+// invalidations that arrive after it returns, such as from a binlog. Updates
+// for subscriptions invalidated within the window are written before the
+// mutation's result. It defaults to DefaultMutationSettleWindow.
+func WithMutationSettleWindow(window time.Duration) ConnectionOption {
+	return func(c *conn) {
+		c.mutationSettleWindow = window
+	}
+}
+
+// WithAuthenticator requires clients to authenticate with an "init" message
+// before subscribing or mutating. Clients can send "auth" messages later to
+// rotate credentials; every subscription then reruns under the new identity.
+// The connection is closed when credentials expire without being refreshed.
+func WithAuthenticator(authenticate AuthenticateFunc) ConnectionOption {
+	return func(c *conn) {
+		c.authenticate = authenticate
+	}
+}
+
+// WithSessionStore enables resumable sessions. A client starts or resumes a
+// session with a "session" message and acknowledges updates it has applied
+// with "ack" messages. When a client resumes a session and resubscribes with
+// the same query and variables, its first update is the diff from the last
+// result it acknowledged instead of the full result.
+func WithSessionStore(store *SessionStore) ConnectionOption {
+	return func(c *conn) {
+		c.sessions = store
+	}
+}
+
+// WithOutboundQueue writes messages to the socket from a queue holding up to
+// size messages, so a slow client does not block computations. Updates for a
+// subscription that are superseded before being written are coalesced. A
+// client that lets the queue fill up may fall behind by up to size more
+// messages for the slow consumer grace period, and is disconnected if it has
+// not caught up by then.
+func WithOutboundQueue(size int) ConnectionOption {
+	return func(c *conn) {
+		c.queue = newOutboundQueue(size)
+	}
+}
+
+// WithSlowConsumerGrace sets how long a client may keep its outbound queue
+// full before it is disconnected. A zero grace disconnects the client as soon
+// as the queue overflows.
+func WithSlowConsumerGrace(grace time.Duration) ConnectionOption {
+	return func(c *conn) {
+		c.slowConsumerGrace = grace
+	}
+}
+
+// WithOutboundQueueMetrics reports the outbound queue's depth and coalesced
+// and dropped messages to metrics.
+func WithOutboundQueueMetrics(metrics OutboundQueueMetrics) ConnectionOption {
+	return func(c *conn) {
+		c.queueMetrics = metrics
+	}
+}
+
+// WithRerunLimiter schedules subscription reruns through limiter, which
+// should be shared by all connections to bound the process's rerun rate.
+func WithRerunLimiter(limiter *RerunLimiter) ConnectionOption {
+	return func(c *conn) {
+		c.rerunLimiter = limiter
+	}
+}
+
+// WithConnectionRerunLimit limits the rate of subscription reruns of each
+// connection with its own RerunLimiter. It panics if config is invalid.
+func WithConnectionRerunLimit(config RerunLimiterConfig) ConnectionOption {
+	config, err := config.withDefaults()
+	if err != nil {
+		panic(err)
+	}
+	return func(c *conn) {
+		c.connectionRerunLimiter = newRerunLimiter(config)
+	}
+}
+
 // WithMinRerunIntervalFunc is deprecated.
 func WithMinRerunIntervalFunc(fn RerunIntervalFunc) ConnectionOption {
 	return func(c *conn) {
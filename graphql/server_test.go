@@ -0,0 +1,666 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/samson-crypto/thunder/graphql"
+	"github.com/samson-crypto/thunder/graphql/schemabuilder"
+	"github.com/samson-crypto/thunder/reactive"
+	"github.com/stretchr/testify/require"
+)
+
+var errSocketClosed = &websocket.CloseError{Code: websocket.CloseNormalClosure}
+
+// fakeSocket is an in-memory graphql.JSONSocket. Messages sent with send are
+// read by the connection, and messages written by the connection are received
+// with receive.
+type fakeSocket struct {
+	in  chan []byte
+	out chan map[string]interface{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// done is closed once the connection serving the socket has returned.
+	done chan struct{}
+}
+
+func newFakeSocket() *fakeSocket {
+	return &fakeSocket{
+		in:     make(chan []byte, 16),
+		out:    make(chan map[string]interface{}, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (s *fakeSocket) ReadJSON(value interface{}) error {
+	select {
+	case b := <-s.in:
+		return json.Unmarshal(b, value)
+	case <-s.closed:
+		return errSocketClosed
+	}
+}
+
+func (s *fakeSocket) WriteJSON(value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	select {
+	case s.out <- m:
+		return nil
+	case <-s.closed:
+		return errSocketClosed
+	}
+}
+
+func (s *fakeSocket) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *fakeSocket) send(t *testing.T, id, typ string, message interface{}) {
+	b, err := json.Marshal(map[string]interface{}{"id": id, "type": typ, "message": message})
+	require.NoError(t, err)
+	s.in <- b
+}
+
+func (s *fakeSocket) receive(t *testing.T) map[string]interface{} {
+	select {
+	case m := <-s.out:
+		return m
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for message")
+		return nil
+	}
+}
+
+type liveCounter struct {
+	mu       sync.Mutex
+	value    int64
+	resource *reactive.Resource
+}
+
+func (c *liveCounter) get(ctx context.Context) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reactive.AddDependency(ctx, c.resource, nil)
+	return c.value
+}
+
+func (c *liveCounter) increment() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value++
+	c.resource.Strobe()
+	return c.value
+}
+
+func makeLiveCounterSchema(c *liveCounter) *graphql.Schema {
+	schema := schemabuilder.NewSchema()
+	schema.Query().FieldFunc("value", func(ctx context.Context) int64 {
+		return c.get(ctx)
+	})
+	schema.Mutation().FieldFunc("increment", func(ctx context.Context) int64 {
+		return c.increment()
+	})
+	return schema.MustBuild()
+}
+
+func serveFakeSocket(t *testing.T, schema *graphql.Schema, opts ...graphql.ConnectionOption) *fakeSocket {
+	socket := newFakeSocket()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := graphql.CreateConnection(ctx, socket, schema, opts...)
+	socket.done = make(chan struct{})
+	go func() {
+		defer close(socket.done)
+		conn.ServeJSONSocket()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		socket.Close()
+		<-socket.done
+	})
+	return socket
+}
+
+func TestMutationAcknowledgement(t *testing.T) {
+	c := &liveCounter{resource: reactive.NewResource()}
+	socket := serveFakeSocket(t, makeLiveCounterSchema(c),
+		graphql.WithMinRerunInterval(time.Hour),
+		graphql.WithMutationAcknowledgement(5*time.Second))
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	initial := socket.receive(t)
+	require.Equal(t, "update", initial["type"])
+	require.Equal(t, float64(1), initial["version"])
+
+	socket.send(t, "mut", "mutate", map[string]interface{}{"query": "mutation { increment }"})
+
+	// The subscription's update must arrive before the mutation's result, and
+	// the result must acknowledge the update's version.
+	update := socket.receive(t)
+	require.Equal(t, "update", update["type"])
+	require.Equal(t, "sub", update["id"])
+	require.Equal(t, map[string]interface{}{"value": float64(1)}, update["message"])
+	require.Equal(t, float64(2), update["version"])
+
+	result := socket.receive(t)
+	require.Equal(t, "result", result["type"])
+	require.Equal(t, "mut", result["id"])
+	require.Equal(t, float64(2), result["version"])
+}
+
+func TestMutationAcknowledgementAsyncInvalidation(t *testing.T) {
+	c := &liveCounter{resource: reactive.NewResource()}
+	schema := schemabuilder.NewSchema()
+	schema.Query().FieldFunc("value", func(ctx context.Context) int64 {
+		return c.get(ctx)
+	})
+	schema.Mutation().FieldFunc("increment", func(ctx context.Context) int64 {
+		// Invalidate after the mutation returns, as a binlog would.
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.value++
+		time.AfterFunc(50*time.Millisecond, c.resource.Strobe)
+		return c.value
+	})
+	socket := serveFakeSocket(t, schema.MustBuild(),
+		graphql.WithMinRerunInterval(time.Hour),
+		graphql.WithMutationAcknowledgement(5*time.Second),
+		graphql.WithMutationSettleWindow(500*time.Millisecond))
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	initial := socket.receive(t)
+	require.Equal(t, float64(1), initial["version"])
+
+	socket.send(t, "mut", "mutate", map[string]interface{}{"query": "mutation { increment }"})
+
+	update := socket.receive(t)
+	require.Equal(t, "update", update["type"])
+	require.Equal(t, map[string]interface{}{"value": float64(1)}, update["message"])
+	require.Equal(t, float64(2), update["version"])
+
+	result := socket.receive(t)
+	require.Equal(t, "result", result["type"])
+	require.Equal(t, float64(2), result["version"])
+}
+
+func TestMutationWithoutAcknowledgement(t *testing.T) {
+	c := &liveCounter{resource: reactive.NewResource()}
+	socket := serveFakeSocket(t, makeLiveCounterSchema(c), graphql.WithMinRerunInterval(time.Hour))
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	socket.receive(t)
+
+	// The result does not wait for updates, so it carries no version.
+	socket.send(t, "mut", "mutate", map[string]interface{}{"query": "mutation { increment }"})
+	for i := 0; i < 2; i++ {
+		msg := socket.receive(t)
+		if msg["type"] == "result" {
+			require.NotContains(t, msg, "version")
+		}
+	}
+}
+
+type identityKey struct{}
+
+func makeIdentitySchema() *graphql.Schema {
+	schema := schemabuilder.NewSchema()
+	schema.Query().FieldFunc("me", func(ctx context.Context) string {
+		name, _ := ctx.Value(identityKey{}).(string)
+		return name
+	})
+	_ = schema.Mutation()
+	return schema.MustBuild()
+}
+
+func authenticateToken(expiry time.Duration) graphql.AuthenticateFunc {
+	return func(ctx context.Context, credentials json.RawMessage) (context.Context, time.Time, error) {
+		var token string
+		if err := json.Unmarshal(credentials, &token); err != nil {
+			return nil, time.Time{}, err
+		}
+		if token == "" {
+			return nil, time.Time{}, graphql.NewClientError("bad token")
+		}
+		var expiresAt time.Time
+		if expiry > 0 {
+			expiresAt = time.Now().Add(expiry)
+		}
+		return context.WithValue(ctx, identityKey{}, token), expiresAt, nil
+	}
+}
+
+func TestAuthenticationRequired(t *testing.T) {
+	socket := serveFakeSocket(t, makeIdentitySchema(), graphql.WithAuthenticator(authenticateToken(0)))
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ me }"})
+	msg := socket.receive(t)
+	require.Equal(t, "error", msg["type"])
+	require.Equal(t, "not authenticated", msg["message"])
+
+	socket.send(t, "init", "init", "")
+	msg = socket.receive(t)
+	require.Equal(t, "error", msg["type"])
+	require.Equal(t, "bad token", msg["message"])
+
+	socket.send(t, "init", "init", "alice")
+	msg = socket.receive(t)
+	require.Equal(t, "init", msg["type"])
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ me }"})
+	msg = socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, []interface{}{map[string]interface{}{"me": "alice"}}, msg["message"])
+}
+
+func TestAuthenticationRefresh(t *testing.T) {
+	socket := serveFakeSocket(t, makeIdentitySchema(),
+		graphql.WithMinRerunInterval(time.Hour),
+		graphql.WithAuthenticator(authenticateToken(0)))
+
+	socket.send(t, "init", "init", "alice")
+	require.Equal(t, "init", socket.receive(t)["type"])
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ me }"})
+	require.Equal(t, "update", socket.receive(t)["type"])
+
+	// Rotating credentials reruns the subscription under the new identity.
+	socket.send(t, "auth", "auth", "bob")
+	require.Equal(t, "auth", socket.receive(t)["type"])
+	msg := socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, map[string]interface{}{"me": "bob"}, msg["message"])
+}
+
+func TestAuthenticationRefreshDuringRerun(t *testing.T) {
+	resource := reactive.NewResource()
+	blocked := make(chan struct{})
+	unblock := make(chan struct{})
+	var block int32
+	schema := schemabuilder.NewSchema()
+	schema.Query().FieldFunc("me", func(ctx context.Context) string {
+		reactive.AddDependency(ctx, resource, nil)
+		if atomic.CompareAndSwapInt32(&block, 1, 0) {
+			close(blocked)
+			<-unblock
+		}
+		name, _ := ctx.Value(identityKey{}).(string)
+		return name
+	})
+	_ = schema.Mutation()
+	socket := serveFakeSocket(t, schema.MustBuild(),
+		graphql.WithAuthenticator(authenticateToken(0)))
+
+	socket.send(t, "init", "init", "alice")
+	require.Equal(t, "init", socket.receive(t)["type"])
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ me }"})
+	require.Equal(t, "update", socket.receive(t)["type"])
+
+	// Rotate credentials while a rerun that read the old identity is still
+	// running; the subscription must rerun again under the new identity.
+	atomic.StoreInt32(&block, 1)
+	resource.Strobe()
+	<-blocked
+	socket.send(t, "auth", "auth", "bob")
+	require.Equal(t, "auth", socket.receive(t)["type"])
+	close(unblock)
+
+	msg := socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, map[string]interface{}{"me": "bob"}, msg["message"])
+}
+
+func TestAuthenticationExpiry(t *testing.T) {
+	// The error is written before the socket closes, with or without a queue.
+	for _, opts := range [][]graphql.ConnectionOption{nil, {graphql.WithOutboundQueue(10)}} {
+		opts = append(opts, graphql.WithAuthenticator(authenticateToken(50*time.Millisecond)))
+		socket := serveFakeSocket(t, makeIdentitySchema(), opts...)
+
+		socket.send(t, "init", "init", "alice")
+		require.Equal(t, "init", socket.receive(t)["type"])
+
+		msg := socket.receive(t)
+		require.Equal(t, "error", msg["type"])
+		require.Equal(t, "credentials expired", msg["message"])
+
+		select {
+		case <-socket.closed:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "expected socket to be closed")
+		}
+	}
+}
+
+func TestAuthenticationIdentityCanceled(t *testing.T) {
+	canceled := make(chan error, 1)
+	schema := schemabuilder.NewSchema()
+	schema.Query().FieldFunc("wait", func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return "", ctx.Err()
+	})
+	_ = schema.Mutation()
+
+	var cancelIdentity context.CancelFunc
+	authenticate := func(ctx context.Context, credentials json.RawMessage) (context.Context, time.Time, error) {
+		ctx, cancelIdentity = context.WithCancel(ctx)
+		return ctx, time.Time{}, nil
+	}
+	socket := serveFakeSocket(t, schema.MustBuild(), graphql.WithAuthenticator(authenticate))
+	socket.send(t, "init", "init", "alice")
+	require.Equal(t, "init", socket.receive(t)["type"])
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ wait }"})
+
+	// Computations stop when the identity's context is done.
+	cancelIdentity()
+	select {
+	case err := <-canceled:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected computation to be canceled")
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	c := &liveCounter{resource: reactive.NewResource()}
+	schema := makeLiveCounterSchema(c)
+	store := graphql.NewSessionStore(time.Minute, nil)
+
+	socket := serveFakeSocket(t, schema, graphql.WithSessionStore(store))
+	socket.send(t, "", "session", map[string]interface{}{})
+	msg := socket.receive(t)
+	require.Equal(t, "session", msg["type"])
+	token := msg["message"].(string)
+	require.NotEmpty(t, token)
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	msg = socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, []interface{}{map[string]interface{}{"value": float64(0)}}, msg["message"])
+	version := msg["version"]
+
+	socket.Close()
+	<-socket.done
+
+	// Change the result while the client is disconnected.
+	c.increment()
+
+	// The resumed subscription only sends the change since the acknowledged
+	// result.
+	socket = serveFakeSocket(t, schema, graphql.WithSessionStore(store))
+	socket.send(t, "", "session", map[string]interface{}{"token": token, "version": version})
+	msg = socket.receive(t)
+	require.Equal(t, "session", msg["type"])
+	require.Equal(t, token, msg["message"])
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	msg = socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, map[string]interface{}{"value": float64(1)}, msg["message"])
+	require.Equal(t, float64(2), msg["version"])
+}
+
+func TestSessionResumeUnacknowledged(t *testing.T) {
+	c := &liveCounter{resource: reactive.NewResource()}
+	schema := makeLiveCounterSchema(c)
+	store := graphql.NewSessionStore(time.Minute, nil)
+
+	socket := serveFakeSocket(t, schema, graphql.WithSessionStore(store))
+	socket.send(t, "", "session", map[string]interface{}{})
+	token := socket.receive(t)["message"].(string)
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	require.Equal(t, "update", socket.receive(t)["type"])
+
+	socket.Close()
+	<-socket.done
+
+	// Without an acknowledgement, the resumed subscription sends its full
+	// result.
+	socket = serveFakeSocket(t, schema, graphql.WithSessionStore(store))
+	socket.send(t, "", "session", map[string]interface{}{"token": token})
+	require.Equal(t, token, socket.receive(t)["message"])
+
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	msg := socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, []interface{}{map[string]interface{}{"value": float64(0)}}, msg["message"])
+}
+
+func TestSessionIdentity(t *testing.T) {
+	store := graphql.NewSessionStore(time.Minute, func(ctx context.Context) string {
+		return ctx.Value(identityKey{}).(string)
+	})
+	connect := func(name string) *fakeSocket {
+		socket := serveFakeSocket(t, makeIdentitySchema(),
+			graphql.WithAuthenticator(authenticateToken(0)),
+			graphql.WithSessionStore(store))
+		socket.send(t, "init", "init", name)
+		require.Equal(t, "init", socket.receive(t)["type"])
+		return socket
+	}
+
+	socket := connect("alice")
+	socket.send(t, "", "session", map[string]interface{}{})
+	token := socket.receive(t)["message"].(string)
+
+	// Rotating credentials to another identity is refused.
+	socket.send(t, "auth", "auth", "bob")
+	msg := socket.receive(t)
+	require.Equal(t, "error", msg["type"])
+	require.Equal(t, "session belongs to another identity", msg["message"])
+	socket.Close()
+	<-socket.done
+
+	// Only the identity that started the session can resume it.
+	socket = connect("bob")
+	socket.send(t, "", "session", map[string]interface{}{"token": token})
+	msg = socket.receive(t)
+	require.Equal(t, "error", msg["type"])
+	require.Equal(t, "session belongs to another identity", msg["message"])
+
+	socket = connect("alice")
+	socket.send(t, "", "session", map[string]interface{}{"token": token})
+	msg = socket.receive(t)
+	require.Equal(t, "session", msg["type"])
+	require.Equal(t, token, msg["message"])
+
+	// Authenticated sessions need an identity function.
+	socket = serveFakeSocket(t, makeIdentitySchema(),
+		graphql.WithAuthenticator(authenticateToken(0)),
+		graphql.WithSessionStore(graphql.NewSessionStore(time.Minute, nil)))
+	socket.send(t, "init", "init", "alice")
+	socket.receive(t)
+	socket.send(t, "", "session", map[string]interface{}{})
+	require.Equal(t, "error", socket.receive(t)["type"])
+}
+
+func TestSessionResumeAfterCanceledRerun(t *testing.T) {
+	c := &liveCounter{resource: reactive.NewResource()}
+	started := make(chan struct{}, 1)
+	var block int32
+	schema := schemabuilder.NewSchema()
+	schema.Query().FieldFunc("value", func(ctx context.Context) (int64, error) {
+		value := c.get(ctx)
+		if atomic.LoadInt32(&block) == 1 {
+			// Block the rerun until the connection closes and cancels it.
+			started <- struct{}{}
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return value, nil
+	})
+	_ = schema.Mutation()
+	store := graphql.NewSessionStore(time.Minute, nil)
+
+	socket := serveFakeSocket(t, schema.MustBuild(), graphql.WithSessionStore(store), graphql.WithMinRerunInterval(0))
+	socket.send(t, "", "session", map[string]interface{}{})
+	token := socket.receive(t)["message"].(string)
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	version := socket.receive(t)["version"]
+	socket.send(t, "", "ack", version)
+
+	atomic.StoreInt32(&block, 1)
+	c.increment()
+	<-started
+	socket.Close()
+	<-socket.done
+	atomic.StoreInt32(&block, 0)
+
+	// The canceled rerun did not drop the subscription from the session.
+	socket = serveFakeSocket(t, schema.MustBuild(), graphql.WithSessionStore(store))
+	socket.send(t, "", "session", map[string]interface{}{"token": token})
+	require.Equal(t, token, socket.receive(t)["message"])
+	socket.send(t, "sub", "subscribe", map[string]interface{}{"query": "{ value }"})
+	msg := socket.receive(t)
+	require.Equal(t, "update", msg["type"])
+	require.Equal(t, map[string]interface{}{"value": float64(1)}, msg["message"])
+}
+
+func TestOutboundQueueSlowConsumerGrace(t *testing.T) {
+	serve := func(grace time.Duration) (*fakeSocket, chan struct{}) {
+		// A socket that is not read from blocks all writes.
+		socket := newFakeSocket()
+		socket.out = make(chan map[string]interface{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		conn := graphql.CreateConnection(ctx, socket, makeIdentitySchema(),
+			graphql.WithOutboundQueue(2), graphql.WithSlowConsumerGrace(grace))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			conn.ServeJSONSocket()
+		}()
+
+		// The fourth message overflows the queue without filling it.
+		for i := 0; i < 4; i++ {
+			socket.send(t, "", "echo", nil)
+		}
+		return socket, done
+	}
+
+	// A client that catches up within the grace period stays connected.
+	socket, done := serve(time.Minute)
+	for i := 0; i < 4; i++ {
+		require.Equal(t, "echo", socket.receive(t)["type"])
+	}
+	socket.send(t, "", "echo", nil)
+	require.Equal(t, "echo", socket.receive(t)["type"])
+	select {
+	case <-done:
+		require.FailNow(t, "expected client to stay connected")
+	default:
+	}
+
+	// A client that stays behind is disconnected once it expires.
+	_, done = serve(50 * time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected slow consumer to be disconnected")
+	}
+}
+
+func TestConnectionRerunLimitPriority(t *testing.T) {
+	require.Panics(t, func() {
+		graphql.WithConnectionRerunLimit(graphql.RerunLimiterConfig{})
+	})
+
+	// Once the one token is taken, a rerun is admitted every 200ms.
+	c := &liveCounter{resource: reactive.NewResource()}
+	// Spawn reruns so the invalidated subscriptions contend for the limiter
+	// rather than rerun one after another.
+	socket := serveFakeSocket(t, makeLiveCounterSchema(c),
+		graphql.WithConnectionRerunLimit(graphql.RerunLimiterConfig{Rate: 5, Burst: 1}),
+		graphql.WithMinRerunInterval(0),
+		graphql.WithAlwaysSpawnGoroutineFunc(func(context.Context, *graphql.Query) bool { return true }))
+
+	subscribe := func(id, priority string) {
+		b, err := json.Marshal(map[string]interface{}{
+			"id":         id,
+			"type":       "subscribe",
+			"message":    map[string]interface{}{"query": "{ value }"},
+			"extensions": map[string]interface{}{"priority": priority},
+		})
+		require.NoError(t, err)
+		socket.in <- b
+		require.Equal(t, "update", socket.receive(t)["type"])
+	}
+	for _, id := range []string{"low1", "low2", "low3"} {
+		subscribe(id, "low")
+	}
+	subscribe("high", "high")
+
+	c.increment()
+	var order []string
+	for range []string{"low1", "low2", "low3", "high"} {
+		order = append(order, socket.receive(t)["id"].(string))
+	}
+
+	// Whichever rerun comes first takes the token, and the high priority rerun
+	// runs before the low priority reruns that waited with it.
+	if order[0] != "high" {
+		require.Equal(t, "high", order[1], "reruns ran in order %v", order)
+	}
+}
+
+type queueMetrics struct {
+	mu      sync.Mutex
+	dropped int
+}
+
+func (m *queueMetrics) QueueDepth(ctx context.Context, depth int)      {}
+func (m *queueMetrics) UpdateCoalesced(ctx context.Context, id string) {}
+func (m *queueMetrics) UpdatesDropped(ctx context.Context, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped += count
+}
+
+func TestOutboundQueueDisconnectsSlowConsumer(t *testing.T) {
+	// A socket that is never read from blocks all writes.
+	socket := newFakeSocket()
+	socket.out = make(chan map[string]interface{})
+
+	metrics := &queueMetrics{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := graphql.CreateConnection(ctx, socket, makeIdentitySchema(),
+		graphql.WithOutboundQueue(1), graphql.WithOutboundQueueMetrics(metrics))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.ServeJSONSocket()
+	}()
+
+	// At most one message is blocked in the socket and the queue holds two
+	// while it overflows, so the fourth fills it.
+	for i := 0; i < 4; i++ {
+		socket.send(t, "", "echo", nil)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected slow consumer to be disconnected")
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	require.True(t, metrics.dropped >= 1)
+}
@@ -0,0 +1,192 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxUnacknowledgedUpdates is the number of unacknowledged updates a session
+// remembers per subscription. Subscriptions whose acknowledged result is lost
+// to this limit send their full result when resumed.
+const maxUnacknowledgedUpdates = 32
+
+// SessionStore keeps the subscription results of resumable sessions, so that
+// a client reconnecting with a session token only receives the changes since
+// the last update it acknowledged.
+//
+// A single SessionStore should be shared by all connections of a server.
+type SessionStore struct {
+	grace    time.Duration
+	identify SessionIdentityFunc
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// SessionIdentityFunc returns a key for the identity authenticated in ctx, such
+// as a user id. ctx is the context returned by the connection's
+// AuthenticateFunc.
+type SessionIdentityFunc func(ctx context.Context) string
+
+// NewSessionStore creates a SessionStore that keeps a session's state for
+// grace after its connection closes.
+//
+// Sessions are bound to the identity identify returns for the connection that
+// started them, and only connections authenticated as the same identity can
+// resume them. identify is required for connections that authenticate with
+// WithAuthenticator, and may be nil otherwise.
+func NewSessionStore(grace time.Duration, identify SessionIdentityFunc) *SessionStore {
+	return &SessionStore{
+		grace:    grace,
+		identify: identify,
+		sessions: make(map[string]*session),
+	}
+}
+
+// attach attaches a connection authenticated as identity to the session with
+// the given token. If token is empty or no longer known, a new session is
+// started.
+func (s *SessionStore) attach(token string, identity string) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[token]; ok {
+		if sess.identity != identity {
+			return nil, NewSafeError("session belongs to another identity")
+		}
+		if sess.attached {
+			return nil, NewSafeError("session in use")
+		}
+		sess.attached = true
+		sess.expiry.Stop()
+		return sess, nil
+	}
+
+	sess := &session{
+		token:         uuid.NewV4().String(),
+		identity:      identity,
+		attached:      true,
+		subscriptions: make(map[string]*sessionSubscription),
+	}
+	s.sessions[sess.token] = sess
+	return sess, nil
+}
+
+// detach detaches a connection from its session, and forgets the session if
+// no connection attaches to it within the grace period.
+func (s *SessionStore) detach(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess.attached = false
+	sess.expiry = time.AfterFunc(s.grace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if !sess.attached {
+			delete(s.sessions, sess.token)
+		}
+	})
+}
+
+// session holds the state of a resumable session. attached and expiry are
+// protected by the SessionStore's mutex.
+type session struct {
+	token    string
+	identity string
+
+	attached bool
+	expiry   *time.Timer
+
+	mu            sync.Mutex
+	version       uint64
+	subscriptions map[string]*sessionSubscription
+}
+
+type sessionUpdate struct {
+	version uint64
+	result  interface{}
+}
+
+// sessionSubscription tracks the results sent for a subscription.
+// acknowledged is the last result the client has acknowledged, and
+// unacknowledged holds the results sent since.
+type sessionSubscription struct {
+	message        string
+	resumable      bool
+	acknowledged   interface{}
+	unacknowledged []sessionUpdate
+}
+
+// subscribe starts tracking a subscription and returns the result the client
+// last acknowledged for it, or nil if the subscription cannot be resumed.
+// message identifies the subscription's query and variables.
+func (s *session) subscribe(id string, message string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub, ok := s.subscriptions[id]; ok && sub.message == message && sub.resumable {
+		sub.unacknowledged = nil
+		return sub.acknowledged
+	}
+
+	s.subscriptions[id] = &sessionSubscription{
+		message:   message,
+		resumable: true,
+	}
+	return nil
+}
+
+func (s *session) unsubscribe(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscriptions, id)
+}
+
+// record remembers a result sent to the client with the given version.
+func (s *session) record(id string, version uint64, result interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version = version
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return
+	}
+
+	if len(sub.unacknowledged) == maxUnacknowledgedUpdates {
+		sub.unacknowledged = sub.unacknowledged[1:]
+		sub.resumable = false
+	}
+	sub.unacknowledged = append(sub.unacknowledged, sessionUpdate{version: version, result: result})
+}
+
+// acknowledge marks all updates up to and including version as received by
+// the client.
+func (s *session) acknowledge(version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscriptions {
+		i := 0
+		for i < len(sub.unacknowledged) && sub.unacknowledged[i].version <= version {
+			i++
+		}
+		if i > 0 {
+			sub.acknowledged = sub.unacknowledged[i-1].result
+			sub.resumable = true
+			sub.unacknowledged = sub.unacknowledged[i:]
+		}
+	}
+}
+
+func (s *session) lastVersion() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version
+}
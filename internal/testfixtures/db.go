@@ -60,6 +60,10 @@ func (t *TestDatabase) Close() error {
 	if err := t.DB.Close(); err != nil {
 		return err
 	}
+	if t.ControlDB == nil {
+		// In-memory databases disappear with their connection.
+		return nil
+	}
 	if _, err := t.ControlDB.Exec(fmt.Sprintf("DROP DATABASE %s", t.DBName)); err != nil {
 		return err
 	}
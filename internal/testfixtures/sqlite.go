@@ -0,0 +1,44 @@
+package testfixtures
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewSQLiteTestDatabase creates an in-memory SQLite database, private to the
+// returned TestDatabase, and runs statements to set it up. Use it with
+// sqlgen's generated tables to test without a MySQL server:
+//
+//   statements, err := schema.CreateTableStatements(sqlgen.SQLite)
+//   ...
+//   testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+//   ...
+//   db := sqlgen.NewDBWithDialect(testDb.DB, schema, sqlgen.SQLite)
+//
+// The database lives in a single connection, so a query made outside of an
+// open transaction blocks until the transaction finishes.
+func NewSQLiteTestDatabase(statements ...string) (*TestDatabase, error) {
+	name := fmt.Sprintf("thunder_test_%d", rand.Intn(1<<30))
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory", name))
+	if err != nil {
+		return nil, err
+	}
+	// Every connection to an in-memory database opens a new, empty database.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &TestDatabase{
+		DB:     db,
+		DBName: name,
+	}, nil
+}
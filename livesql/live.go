@@ -2,6 +2,7 @@ package livesql
 
 import (
 	"context"
+	"reflect"
 	"sync"
 
 	"github.com/samson-crypto/thunder/internal"
@@ -106,6 +107,8 @@ type LiveDB struct {
 	*sqlgen.DB
 
 	tracker *dbTracker
+
+	primaryReads bool
 }
 
 // NewLiveDB constructs a new LiveDB
@@ -116,6 +119,15 @@ func NewLiveDB(db *sqlgen.DB) *LiveDB {
 	}
 }
 
+// WithPrimaryReads returns a copy of the LiveDB that reads from the primary
+// even if the DB has replicas, so that query results are consistent with the
+// binlog updates that invalidate them.
+func (ldb *LiveDB) WithPrimaryReads() *LiveDB {
+	ldbCopy := *ldb
+	ldbCopy.primaryReads = true
+	return &ldbCopy
+}
+
 type queryCacheKey struct {
 	clause string
 	args   interface{}
@@ -123,6 +135,10 @@ type queryCacheKey struct {
 
 // query reactively performs a SelectQuery
 func (ldb *LiveDB) query(ctx context.Context, query *sqlgen.BaseSelectQuery) ([]interface{}, error) {
+	if ldb.primaryReads {
+		ctx = sqlgen.WithPrimary(ctx)
+	}
+
 	// Fall back to sqlgen querying if there is no reactive rerunner present or if we're in
 	// a transaction.
 	if !reactive.HasRerunner(ctx) || ldb.HasTx(ctx) {
@@ -222,6 +238,92 @@ func (ldb *LiveDB) QueryRow(ctx context.Context, result interface{}, filter sqlg
 	return sqlgen.CopySingletonSlice(result, rows)
 }
 
+// Preload loads the named relations of rows, and will invalidate ctx when the
+// related rows change. See sqlgen.DB.Preload.
+func (ldb *LiveDB) Preload(ctx context.Context, rows interface{}, relations ...string) error {
+	// Queries in a transaction are not reactive.
+	if ldb.HasTx(ctx) {
+		return ldb.DB.Preload(ctx, rows, relations...)
+	}
+	for _, relation := range relations {
+		query, err := ldb.Schema.MakePreload(rows, relation)
+		if err != nil {
+			return err
+		}
+		if err := query.Load(ctx, ldb.query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type aggregateCacheKey struct {
+	queryCacheKey
+	result reflect.Type
+}
+
+// aggregate reactively performs an aggregate query
+func (ldb *LiveDB) aggregate(ctx context.Context, query *sqlgen.BaseAggregateQuery, result reflect.Type) ([]interface{}, error) {
+	if ldb.primaryReads {
+		ctx = sqlgen.WithPrimary(ctx)
+	}
+
+	// Fall back to sqlgen querying if there is no reactive rerunner present or if we're in
+	// a transaction.
+	if !reactive.HasRerunner(ctx) || ldb.HasTx(ctx) {
+		return ldb.DB.BaseAggregate(ctx, query)
+	}
+	aggregateQuery, err := query.MakeAggregateQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	clause, args := aggregateQuery.ToSQL()
+
+	// Queries with different result types can have the same SQL, so include
+	// the result type in the cache key.
+	key := aggregateCacheKey{
+		queryCacheKey: queryCacheKey{clause: clause, args: internal.MakeHashable(args)},
+		result:        result,
+	}
+
+	rows, err := reactive.Cache(ctx, key, func(ctx context.Context) (interface{}, error) {
+		// Any change to a row matching the filter can change the aggregates.
+		tester, err := ldb.Schema.MakeTester(query.Table.Name, query.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		// Register the dependency before we do the query to not miss any updates
+		// between querying and registering.
+		// Do not fail the query if this step fails.
+		_ = ldb.tracker.registerDependency(ctx, ldb.Schema, query.Table.Name, tester, query.Filter)
+
+		return ldb.DB.BaseAggregate(ctx, query)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return rows.([]interface{}), nil
+}
+
+// Aggregate runs an aggregate query and will invalidate ctx when rows matching
+// filter change. See sqlgen.DB.Aggregate.
+func (ldb *LiveDB) Aggregate(ctx context.Context, model interface{}, result interface{}, filter sqlgen.Filter, options *sqlgen.AggregateOptions) error {
+	query, err := ldb.Schema.MakeAggregate(model, result, filter, options)
+	if err != nil {
+		return err
+	}
+
+	rows, err := ldb.aggregate(ctx, query, reflect.TypeOf(result))
+	if err != nil {
+		return err
+	}
+
+	return sqlgen.CopySlice(result, rows)
+}
+
 func (ldb *LiveDB) Close() error {
 	return ldb.Conn.Close()
 }
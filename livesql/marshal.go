@@ -80,8 +80,14 @@ func FilterToProto(schema *sqlgen.Schema, tableName string, filter sqlgen.Filter
 		return &thunderpb.SQLFilter{Table: tableName}, nil
 	}
 
+	// The proto only holds columns, so an Unscoped filter loses its mark.
+	filter = sqlgen.StripUnscoped(filter)
 	fields := make(map[string]*thunderpb.Field, len(filter))
 	for col, val := range filter {
+		if _, ok := val.(*sqlgen.Condition); ok {
+			return nil, fmt.Errorf("filter conditions cannot be marshaled: %s", col)
+		}
+
 		column, ok := table.ColumnsByName[col]
 		if !ok {
 			return nil, fmt.Errorf("unknown column %s", col)
@@ -77,6 +77,11 @@ func TestMarshal(t *testing.T) {
 			filter:      sqlgen.Filter{"name": nil},
 			unmarshaled: sqlgen.Filter{"name": (*string)(nil)},
 		},
+		{
+			name:        "unscoped",
+			filter:      sqlgen.Unscoped(sqlgen.Filter{"id": int64(1)}),
+			unmarshaled: sqlgen.Filter{"id": int64(1)},
+		},
 		{
 			name:   "nil for int64",
 			filter: sqlgen.Filter{"id": nil},
@@ -315,6 +315,16 @@ type Rerunner struct {
 	computation *computation
 	stop        bool
 
+	// currentMu protects current, which mirrors computation but can be read
+	// without waiting for a running computation to finish. generation counts
+	// calls to Invalidate; a computation that started before the latest call
+	// is invalidated as soon as it completes. onInvalidate is called whenever
+	// the current computation is invalidated.
+	currentMu    sync.Mutex
+	current      *computation
+	generation   uint64
+	onInvalidate func()
+
 	lastRun time.Time
 }
 
@@ -352,6 +362,63 @@ func (r *Rerunner) RerunImmediately() {
 	}
 }
 
+// OnInvalidate calls f whenever the rerunner's computation is invalidated,
+// before it reruns. f is not called once the rerunner is stopped.
+func (r *Rerunner) OnInvalidate(f func()) {
+	r.currentMu.Lock()
+	defer r.currentMu.Unlock()
+
+	r.onInvalidate = f
+}
+
+func (r *Rerunner) notifyInvalidate() {
+	r.currentMu.Lock()
+	f := r.onInvalidate
+	r.currentMu.Unlock()
+
+	if f != nil && r.ctx.Err() == nil {
+		f()
+	}
+}
+
+// Invalidate discards the rerunner's cache and reruns its computation as if
+// all of its dependencies had changed. This is useful when the computation
+// depends on state outside of the reactive graph, such as the identity it
+// runs as.
+func (r *Rerunner) Invalidate() {
+	r.cache.purgeCache()
+
+	r.currentMu.Lock()
+	defer r.currentMu.Unlock()
+
+	// A computation that is running now may have read the old state, so it is
+	// invalidated once it completes.
+	r.generation++
+	if r.current != nil {
+		go r.current.node.invalidate()
+	}
+}
+
+// currentGeneration returns the generation a computation starting now runs in.
+func (r *Rerunner) currentGeneration() uint64 {
+	r.currentMu.Lock()
+	defer r.currentMu.Unlock()
+
+	return r.generation
+}
+
+// setCurrent makes c, which started in generation, the current computation,
+// and invalidates it if Invalidate was called since it started.
+func (r *Rerunner) setCurrent(c *computation, generation uint64) {
+	r.currentMu.Lock()
+	defer r.currentMu.Unlock()
+
+	r.current = c
+	if c != nil && generation != r.generation {
+		go c.node.invalidate()
+	}
+}
+
 // run performs an actual computation
 func (r *Rerunner) run() {
 	// Wait for the minimum rerun interval. Exit early if the computation is stopped.
@@ -397,6 +464,7 @@ func (r *Rerunner) run() {
 	ctx = context.WithValue(ctx, cacheKey{}, r.cache)
 	ctx = context.WithValue(ctx, dependencySetKey{}, &dependencySet{})
 
+	generation := r.currentGeneration()
 	currentComputation, err := run(ctx, r.f)
 	r.lastRun = time.Now()
 	if err != nil {
@@ -424,11 +492,13 @@ func (r *Rerunner) run() {
 		}
 
 		r.computation = currentComputation
+		r.setCurrent(currentComputation, generation)
 		r.retryDelay = r.minRerunInterval
 
 		// Schedule a rerun whenever our node becomes invalidated (which might already
 		// have happened!)
 		currentComputation.node.handleInvalidate(func() {
+			r.notifyInvalidate()
 			if r.alwaysSpawnGoroutine {
 				go r.run()
 			} else {
@@ -448,6 +518,7 @@ func (r *Rerunner) Stop() {
 		go r.computation.node.release()
 		r.computation = nil
 	}
+	r.setCurrent(nil, 0)
 	r.mu.Unlock()
 }
 
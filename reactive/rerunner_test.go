@@ -409,3 +409,105 @@ func TestRerunImmediately(t *testing.T) {
 	r.Invalidate()
 	run.Expect(t, "expected rerun")
 }
+
+// TestOnInvalidate tests that OnInvalidate is called for every invalidation
+// before the rerun, and not after the runner is stopped.
+func TestOnInvalidate(t *testing.T) {
+	run := NewExpect()
+	r := NewResource()
+	invalidations := make(chan struct{}, 4)
+	var runs int32
+
+	runner := NewRerunner(context.Background(), func(ctx context.Context) (interface{}, error) {
+		AddDependency(ctx, r, nil)
+		if atomic.AddInt32(&runs, 1) > 1 && len(invalidations) != 1 {
+			t.Error("expected invalidation before rerun")
+		}
+		run.Trigger()
+		return nil, nil
+	}, 0, false)
+	runner.OnInvalidate(func() {
+		invalidations <- struct{}{}
+	})
+
+	run.Expect(t, "expected run")
+	if len(invalidations) != 0 {
+		t.Error("expected no invalidation before strobe")
+	}
+
+	run = NewExpect()
+	r.Strobe()
+	run.Expect(t, "expected rerun")
+	<-invalidations
+
+	runner.Stop()
+	r.Strobe()
+	time.Sleep(10 * time.Millisecond)
+	if len(invalidations) != 0 {
+		t.Error("expected no invalidation after stop")
+	}
+}
+
+// TestInvalidate tests that Invalidate reruns a computation and discards its
+// cache.
+func TestInvalidate(t *testing.T) {
+	run := NewExpect()
+	var cached int32
+
+	runner := NewRerunner(context.Background(), func(ctx context.Context) (interface{}, error) {
+		if _, err := Cache(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&cached, 1)
+			return nil, nil
+		}); err != nil {
+			t.Error(err)
+		}
+		run.Trigger()
+		return nil, nil
+	}, 0, false)
+	defer runner.Stop()
+
+	run.Expect(t, "expected run")
+
+	run = NewExpect()
+	runner.Invalidate()
+	run.Expect(t, "expected rerun")
+
+	if n := atomic.LoadInt32(&cached); n != 2 {
+		t.Errorf("expected cached computation to run twice, got %d", n)
+	}
+}
+
+// TestInvalidateDuringRerun tests that Invalidate reruns a computation that
+// was already running when Invalidate was called.
+func TestInvalidateDuringRerun(t *testing.T) {
+	r := NewResource()
+	started := make(chan int32, 4)
+	unblock := make(chan struct{})
+	var runs int32
+
+	runner := NewRerunner(context.Background(), func(ctx context.Context) (interface{}, error) {
+		AddDependency(ctx, r, nil)
+		n := atomic.AddInt32(&runs, 1)
+		started <- n
+		if n == 2 {
+			<-unblock
+		}
+		return nil, nil
+	}, 0, false)
+	defer runner.Stop()
+
+	<-started
+	r.Strobe()
+	<-started
+
+	// The second run is blocked, so it started before Invalidate and must
+	// run again once it completes.
+	runner.Invalidate()
+	close(unblock)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected rerun after invalidating a running computation")
+	}
+}
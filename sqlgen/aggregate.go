@@ -0,0 +1,442 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/samson-crypto/thunder/batch"
+)
+
+// An Aggregate is an aggregate function over the rows of a table, such as
+// Sum("amount").
+type Aggregate struct {
+	function string
+	column   string
+}
+
+// CountAll counts rows, as COUNT(*).
+func CountAll() Aggregate {
+	return Aggregate{function: "COUNT"}
+}
+
+// Sum sums column. The sum of no rows is NULL, and scans as zero into a
+// non-pointer field.
+func Sum(column string) Aggregate {
+	return Aggregate{function: "SUM", column: column}
+}
+
+// Min selects the smallest value of column.
+func Min(column string) Aggregate {
+	return Aggregate{function: "MIN", column: column}
+}
+
+// Max selects the largest value of column.
+func Max(column string) Aggregate {
+	return Aggregate{function: "MAX", column: column}
+}
+
+// expression returns the SQL expression of the aggregate.
+func (a Aggregate) expression() string {
+	if a.column == "" {
+		return a.function + "(*)"
+	}
+	return fmt.Sprintf("%s(%s)", a.function, a.column)
+}
+
+// AggregateOptions configures an aggregate query.
+type AggregateOptions struct {
+	// GroupBy are the columns rows are grouped by. Without GroupBy, an
+	// aggregate query returns a single row.
+	GroupBy []string
+	// Aggregates maps columns of the result struct to the aggregates selected
+	// into them.
+	Aggregates map[string]Aggregate
+
+	// OrderBy may refer to group by columns and to the result columns of
+	// aggregates.
+	OrderBy string
+	Limit   int
+
+	AllowNoIndex bool
+}
+
+// BaseAggregateQuery is an aggregate query over a table, built by
+// MakeAggregate.
+type BaseAggregateQuery struct {
+	Table   *Table
+	Filter  Filter
+	Options *AggregateOptions
+
+	// result describes the result struct, and columns are the expressions
+	// selected into its columns.
+	result  *Table
+	columns []string
+
+	// readPrimary is set by DB.BaseAggregate for batched queries that must read
+	// from the primary.
+	readPrimary bool
+
+	// scoped is set if Filter holds the soft-delete scope, which batched
+	// aggregates apply once to the whole batch.
+	scoped bool
+}
+
+// aggregateResult returns the descriptor of an aggregate result struct.
+func (s *Schema) aggregateResult(typ reflect.Type) (*Table, error) {
+	if cached, ok := s.aggregateResults.Load(typ); ok {
+		return cached.(*Table), nil
+	}
+	result, err := s.buildDescriptor(typ.Name(), AutoIncrement, typ)
+	if err != nil {
+		return nil, err
+	}
+	s.aggregateResults.Store(typ, result)
+	return result, nil
+}
+
+// MakeAggregate builds an aggregate query over the table of model, a pointer to
+// a struct, into result, a pointer to a slice of pointers to structs. Every
+// column of the result struct must be a group by column or an aggregate.
+func (s *Schema) MakeAggregate(model interface{}, result interface{}, filter Filter, options *AggregateOptions) (*BaseAggregateQuery, error) {
+	typ, err := checkCountModelTypeShape(reflect.TypeOf(model))
+	if err != nil {
+		return nil, err
+	}
+	table, err := s.get(typ)
+	if err != nil {
+		return nil, err
+	}
+	table = table.baseTable()
+
+	resultType, err := checkQueryTypeShape(reflect.TypeOf(result))
+	if err != nil {
+		return nil, err
+	}
+	resultTable, err := s.aggregateResult(resultType)
+	if err != nil {
+		return nil, err
+	}
+
+	if options == nil || len(options.GroupBy)+len(options.Aggregates) == 0 {
+		return nil, errors.New("aggregate requires group by columns or aggregates")
+	}
+	grouped := make(map[string]bool, len(options.GroupBy))
+	for _, name := range options.GroupBy {
+		if _, ok := table.ColumnsByName[name]; !ok {
+			return nil, fmt.Errorf("unknown group by column %s", name)
+		}
+		if _, ok := resultTable.ColumnsByName[name]; !ok {
+			return nil, fmt.Errorf("result %s has no column %s", resultType, name)
+		}
+		grouped[name] = true
+	}
+	for name, aggregate := range options.Aggregates {
+		if aggregate.function == "" {
+			return nil, fmt.Errorf("invalid aggregate for %s", name)
+		}
+		if _, ok := table.ColumnsByName[aggregate.column]; aggregate.column != "" && !ok {
+			return nil, fmt.Errorf("unknown aggregate column %s", aggregate.column)
+		}
+		if _, ok := resultTable.ColumnsByName[name]; !ok {
+			return nil, fmt.Errorf("result %s has no column %s", resultType, name)
+		}
+		if grouped[name] {
+			return nil, fmt.Errorf("result column %s is both grouped and aggregated", name)
+		}
+	}
+
+	columns := make([]string, 0, len(resultTable.Columns))
+	for _, column := range resultTable.Columns {
+		if aggregate, ok := options.Aggregates[column.Name]; ok {
+			columns = append(columns, aggregate.expression()+" AS "+column.Name)
+		} else if grouped[column.Name] {
+			columns = append(columns, column.Name)
+		} else {
+			return nil, fmt.Errorf("result column %s is not grouped or aggregated", column.Name)
+		}
+	}
+
+	return &BaseAggregateQuery{
+		Table:   table,
+		Filter:  table.scopeFilter(filter),
+		Options: options,
+
+		result:  resultTable,
+		columns: columns,
+		scoped:  table.scoped(filter),
+	}, nil
+}
+
+// MakeAggregateQuery builds the query's statement.
+func (b *BaseAggregateQuery) MakeAggregateQuery() (*AggregateQuery, error) {
+	where, err := makeWhere(b.Table, b.Filter)
+	if err != nil {
+		return nil, err
+	}
+	clause, values := where.ToSQL()
+	return b.makeAggregateQuery(nil, clause, values), nil
+}
+
+// makeAggregateQuery builds the query's statement with a WHERE clause, and
+// also selects and groups by keyColumns.
+func (b *BaseAggregateQuery) makeAggregateQuery(keyColumns []string, where string, values []interface{}) *AggregateQuery {
+	columns := append(append([]string{}, keyColumns...), b.columns...)
+	groupBy := append([]string{}, keyColumns...)
+	seen := make(map[string]bool, len(keyColumns))
+	for _, column := range keyColumns {
+		seen[column] = true
+	}
+	for _, column := range b.Options.GroupBy {
+		if !seen[column] {
+			groupBy = append(groupBy, column)
+		}
+	}
+
+	return &AggregateQuery{
+		Table:   b.Table.Name,
+		Columns: columns,
+		Where:   where,
+		Values:  values,
+		GroupBy: groupBy,
+		OrderBy: b.Options.OrderBy,
+		Limit:   b.Options.Limit,
+	}
+}
+
+// batchable returns true if the query can be batched with other aggregates of
+// the same shape. Batched queries select and group by their filter columns, so
+// the filter must only compare columns by equality.
+func (b *BaseAggregateQuery) batchable() bool {
+	if b.Options.OrderBy != "" || b.Options.Limit != 0 || b.Options.AllowNoIndex {
+		return false
+	}
+	filter := b.batchFilter()
+	if !isSimpleFilter(filter) {
+		return false
+	}
+	for name := range filter {
+		if _, ok := b.Table.ColumnsByName[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// batchFilter returns the filter that batched aggregates group by, which
+// leaves out the soft-delete scope.
+func (b *BaseAggregateQuery) batchFilter() Filter {
+	if !b.scoped {
+		return b.Filter
+	}
+	return b.Table.unscope(b.Filter)
+}
+
+// aggregateBatchKey groups batched aggregates by table, result, selected
+// expressions, filter columns, soft-delete scope and connection.
+type aggregateBatchKey struct {
+	table       *Table
+	result      *Table
+	shape       string
+	scoped      bool
+	readPrimary bool
+}
+
+func (b *BaseAggregateQuery) batchKey() aggregateBatchKey {
+	return aggregateBatchKey{
+		table:  b.Table,
+		result: b.result,
+		shape: strings.Join([]string{
+			strings.Join(b.columns, ","),
+			strings.Join(b.Options.GroupBy, ","),
+			columnsKey(extractColumns(b.batchFilter())),
+		}, ";"),
+		scoped:      b.scoped,
+		readPrimary: b.readPrimary,
+	}
+}
+
+// parseRows parses the result rows of the query. If the query selected
+// keyColumns, they are parsed into rows of the table and returned as keys.
+func (b *BaseAggregateQuery) parseRows(res *sql.Rows, keyColumns []*Column) ([]interface{}, []interface{}, error) {
+	var rows, keys []interface{}
+	for res.Next() {
+		row := reflect.New(b.result.Type)
+		key := reflect.New(b.Table.Type)
+
+		keyScanners := b.Table.Scanners.Get().([]interface{})
+		resultScanners := b.result.Scanners.Get().([]interface{})
+		targets := make([]interface{}, 0, len(keyColumns)+len(b.result.Columns))
+		for _, column := range keyColumns {
+			targets = append(targets, scanTarget(keyScanners[column.Order], key.Elem(), column))
+		}
+		for _, column := range b.result.Columns {
+			targets = append(targets, scanTarget(resultScanners[column.Order], row.Elem(), column))
+		}
+		err := res.Scan(targets...)
+		b.Table.Scanners.Put(keyScanners)
+		b.result.Scanners.Put(resultScanners)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sqlgen: parsing error for aggregate of `%s`: %v", b.Table.Name, err)
+		}
+
+		rows = append(rows, row.Interface())
+		keys = append(keys, key.Interface())
+	}
+	if err := res.Err(); err != nil {
+		return nil, nil, err
+	}
+	return rows, keys, nil
+}
+
+// BaseAggregate runs an aggregate query, and returns pointers to result
+// structs. Queries with equality filters are batched with aggregates of the
+// same shape.
+func (db *DB) BaseAggregate(ctx context.Context, query *BaseAggregateQuery) ([]interface{}, error) {
+	aggregateQuery, err := query.MakeAggregateQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.checkFilterAgainstLimits(ctx, aggregateQuery, query.Filter, query.Table); err != nil {
+		return nil, err
+	}
+
+	primary := db.readsFromPrimary(ctx, false)
+
+	if query.batchable() && !db.HasTx(ctx) && batch.HasBatching(ctx) {
+		query.readPrimary = primary
+		rows, err := db.batchFetch.Invoke(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return rows.([]interface{}), nil
+	}
+
+	clause, args := db.toSQL(aggregateQuery)
+
+	if !query.Options.AllowNoIndex {
+		if err := db.checkIndex(ctx, query.Table.Name, clause, args); err != nil {
+			return nil, err
+		}
+	}
+
+	var rows []interface{}
+	event := &QueryEvent{Operation: "Aggregate", Table: query.Table.Name, Clause: clause, Args: args}
+	if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		res, err := db.reader(ctx, primary).QueryContext(ctx, clause, args...)
+		if err != nil {
+			return -1, err
+		}
+		defer res.Close()
+		rows, _, err = query.parseRows(res, nil)
+		return int64(len(rows)), err
+	}); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// batchAggregates runs batched aggregates of the same shape with a single
+// statement that also groups by their filter columns, and matches the
+// returned rows against the queries.
+func (db *DB) batchAggregates(ctx context.Context, items []interface{}) ([]interface{}, error) {
+	first := items[0].(*BaseAggregateQuery)
+	table := first.Table
+
+	keyNames := extractColumns(first.batchFilter())
+	keyColumns := make([]*Column, 0, len(keyNames))
+	for _, name := range keyNames {
+		keyColumns = append(keyColumns, table.ColumnsByName[name])
+	}
+
+	filters := make([]Filter, 0, len(items))
+	for _, item := range items {
+		filters = append(filters, item.(*BaseAggregateQuery).batchFilter())
+	}
+	where, values := makeBatchQuery(db.dialect, filters)
+	if first.scoped {
+		// The soft-delete scope is the same for all aggregates, so it is
+		// applied once.
+		scope, err := makeWhere(table, table.scopeFilter(nil))
+		if err != nil {
+			return nil, err
+		}
+		scopeWhere, scopeValues := scope.ToSQL()
+		if where != "" {
+			where = fmt.Sprintf("(%s) AND (%s)", scopeWhere, where)
+			values = append(scopeValues, values...)
+		} else {
+			where, values = scopeWhere, scopeValues
+		}
+	}
+	clause, args := db.toSQL(first.makeAggregateQuery(keyNames, where, values))
+
+	var rows, keys []interface{}
+	event := &QueryEvent{Operation: "Aggregate", Table: table.Name, Clause: clause, Args: args, Batched: true}
+	if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		res, err := db.reader(ctx, first.readPrimary).QueryContext(ctx, clause, args...)
+		if err != nil {
+			return -1, err
+		}
+		defer res.Close()
+		rows, keys, err = first.parseRows(res, keyColumns)
+		return int64(len(rows)), err
+	}); err != nil {
+		return nil, err
+	}
+
+	matcher := newMatcher()
+	for i, item := range items {
+		matcher.add(i, coerceMap(item.(*BaseAggregateQuery).batchFilter()))
+	}
+	results := make([][]interface{}, len(items))
+	for i, row := range rows {
+		f := coerceMap(table.extractRow(keys[i]))
+		for _, idx := range matcher.match(f) {
+			results[idx.(int)] = append(results[idx.(int)], row)
+		}
+	}
+
+	rawResults := make([]interface{}, 0, len(items))
+	for _, result := range results {
+		// Without GROUP BY, an aggregate over no rows still returns a row, but
+		// the batched statement has no group for it.
+		if len(result) == 0 && len(first.Options.GroupBy) == 0 {
+			result = []interface{}{reflect.New(first.result.Type).Interface()}
+		}
+		rawResults = append(rawResults, result)
+	}
+	return rawResults, nil
+}
+
+// Aggregate runs an aggregate query over the table of model, and stores the
+// result rows in result. For example:
+//
+//   type RegionTotal struct {
+//     Region string
+//     Count  int64
+//     Total  int64
+//   }
+//
+//   var totals []*RegionTotal
+//   err := db.Aggregate(ctx, &Sale{}, &totals, Filter{"year": 2020}, &AggregateOptions{
+//     GroupBy:    []string{"region"},
+//     Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("amount")},
+//   })
+func (db *DB) Aggregate(ctx context.Context, model interface{}, result interface{}, filter Filter, options *AggregateOptions) error {
+	query, err := db.Schema.MakeAggregate(model, result, filter, options)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.BaseAggregate(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return CopySlice(result, rows)
+}
@@ -0,0 +1,186 @@
+package sqlgen
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Sale struct {
+	Id      int64 `sql:",primary"`
+	Region  string
+	Product string
+	Amount  int64
+}
+
+type RegionTotal struct {
+	Region string
+	Count  int64
+	Total  int64
+}
+
+type SaleRange struct {
+	Smallest *int64
+	Largest  *int64
+	Total    *int64
+}
+
+func TestAggregateQuery(t *testing.T) {
+	s := NewSchema()
+	s.MustRegisterType("sales", AutoIncrement, Sale{})
+
+	var totals []*RegionTotal
+	options := &AggregateOptions{
+		GroupBy:    []string{"region"},
+		Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("amount")},
+		OrderBy:    "total DESC",
+		Limit:      10,
+	}
+	query, err := s.MakeAggregate(&Sale{}, &totals, Filter{"product": "owl"}, options)
+	require.NoError(t, err)
+	aggregateQuery, err := query.MakeAggregateQuery()
+	require.NoError(t, err)
+	clause, args := aggregateQuery.ToSQL()
+	assert.Equal(t, "SELECT region, COUNT(*) AS count, SUM(amount) AS total FROM sales WHERE product = ? GROUP BY region ORDER BY total DESC LIMIT 10", clause)
+	assert.Equal(t, []interface{}{"owl"}, args)
+
+	var ranges []*SaleRange
+	query, err = s.MakeAggregate(&Sale{}, &ranges, nil, &AggregateOptions{
+		Aggregates: map[string]Aggregate{"smallest": Min("amount"), "largest": Max("amount"), "total": Sum("amount")},
+	})
+	require.NoError(t, err)
+	aggregateQuery, err = query.MakeAggregateQuery()
+	require.NoError(t, err)
+	clause, _ = aggregateQuery.ToSQL()
+	assert.Equal(t, "SELECT MIN(amount) AS smallest, MAX(amount) AS largest, SUM(amount) AS total FROM sales", clause)
+
+	for _, options := range []*AggregateOptions{
+		nil,
+		{GroupBy: []string{"missing"}, Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("amount")}},
+		{GroupBy: []string{"product"}, Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("amount")}},
+		{GroupBy: []string{"region"}, Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("missing")}},
+		{GroupBy: []string{"region"}, Aggregates: map[string]Aggregate{"count": CountAll(), "total": {}}},
+		{GroupBy: []string{"region"}, Aggregates: map[string]Aggregate{"count": CountAll()}},
+		{GroupBy: []string{"region"}, Aggregates: map[string]Aggregate{"region": CountAll(), "count": CountAll(), "total": Sum("amount")}},
+	} {
+		_, err := s.MakeAggregate(&Sale{}, &totals, nil, options)
+		assert.Error(t, err)
+	}
+	_, err = s.MakeAggregate(&User{}, &totals, nil, options)
+	assert.Error(t, err)
+}
+
+func setupSales(t *testing.T) (*testfixtures.TestDatabase, *DB) {
+	schema := NewSchema()
+	schema.MustRegisterType("sales", AutoIncrement, Sale{})
+
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+
+	require.NoError(t, db.InsertRows(context.Background(), []*Sale{
+		{Region: "east", Product: "owl", Amount: 10},
+		{Region: "east", Product: "owl", Amount: 20},
+		{Region: "west", Product: "owl", Amount: 5},
+		{Region: "west", Product: "cat", Amount: 7},
+	}, 10))
+	return testDb, db
+}
+
+func TestSQLiteAggregate(t *testing.T) {
+	testDb, db := setupSales(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	var totals []*RegionTotal
+	require.NoError(t, db.Aggregate(ctx, &Sale{}, &totals, Filter{"product": "owl"}, &AggregateOptions{
+		GroupBy:    []string{"region"},
+		Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("amount")},
+		OrderBy:    "region",
+	}))
+	assert.Equal(t, []*RegionTotal{
+		{Region: "east", Count: 2, Total: 30},
+		{Region: "west", Count: 1, Total: 5},
+	}, totals)
+
+	var ranges []*SaleRange
+	rangeOptions := &AggregateOptions{
+		Aggregates: map[string]Aggregate{"smallest": Min("amount"), "largest": Max("amount"), "total": Sum("amount")},
+	}
+	require.NoError(t, db.Aggregate(ctx, &Sale{}, &ranges, Filter{"region": "west"}, rangeOptions))
+	five, seven, twelve := int64(5), int64(7), int64(12)
+	assert.Equal(t, []*SaleRange{{Smallest: &five, Largest: &seven, Total: &twelve}}, ranges)
+
+	// An aggregate without GROUP BY over no rows has a single NULL row.
+	require.NoError(t, db.Aggregate(ctx, &Sale{}, &ranges, Filter{"region": "north"}, rangeOptions))
+	assert.Equal(t, []*SaleRange{{}}, ranges)
+}
+
+func TestSQLiteBatchAggregate(t *testing.T) {
+	testDb, db := setupSales(t)
+	defer testDb.Close()
+	hook := &recordingHook{}
+	db, err := db.WithQueryHook(hook)
+	require.NoError(t, err)
+	ctx := batch.WithBatching(context.Background())
+
+	totalOptions := func() *AggregateOptions {
+		return &AggregateOptions{
+			GroupBy:    []string{"region"},
+			Aggregates: map[string]Aggregate{"count": CountAll(), "total": Sum("amount")},
+		}
+	}
+	rangeOptions := &AggregateOptions{
+		Aggregates: map[string]Aggregate{"smallest": Min("amount"), "largest": Max("amount"), "total": Sum("amount")},
+	}
+
+	var wg sync.WaitGroup
+	var owls, cats, dogs []*RegionTotal
+	var east, north []*SaleRange
+	wg.Add(5)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Sale{}, &owls, Filter{"product": "owl"}, totalOptions()))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Sale{}, &cats, Filter{"product": "cat"}, totalOptions()))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Sale{}, &dogs, Filter{"product": "dog"}, totalOptions()))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Sale{}, &east, Filter{"region": "east"}, rangeOptions))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Sale{}, &north, Filter{"region": "north"}, rangeOptions))
+	}()
+	wg.Wait()
+
+	assert.ElementsMatch(t, []*RegionTotal{
+		{Region: "east", Count: 2, Total: 30},
+		{Region: "west", Count: 1, Total: 5},
+	}, owls)
+	assert.Equal(t, []*RegionTotal{{Region: "west", Count: 1, Total: 7}}, cats)
+	assert.Empty(t, dogs)
+	ten, twenty, thirty := int64(10), int64(20), int64(30)
+	assert.Equal(t, []*SaleRange{{Smallest: &ten, Largest: &twenty, Total: &thirty}}, east)
+	assert.Equal(t, []*SaleRange{{}}, north)
+
+	// The totals and the ranges each ran as a single statement.
+	require.Len(t, hook.after, 2)
+	for _, event := range hook.after {
+		assert.Equal(t, "Aggregate", event.Operation)
+		assert.True(t, event.Batched)
+	}
+}
@@ -3,8 +3,15 @@ package sqlgen
 import (
 	"bytes"
 	"sort"
+	"strings"
 )
 
+// An expandTuplesDialect is a Dialect that cannot efficiently match tuples
+// with IN, so batch queries match each tuple with its own AND clause.
+type expandTuplesDialect interface {
+	expandTuples()
+}
+
 // makeBatchQuery combines a set of filters into a single SQL that matches any
 // of the filters, in order to fulfill many independent queries with a single
 // SQL SELECT.
@@ -14,10 +21,13 @@ import (
 //   {"id": 10}
 //   {"id": 20}
 //   {"name": "Bob", "city": "San Francisco"}
+//   {"name": "Alice", "city": "Oakland"}
 // will get combined to form the query
-//   WHERE ((city, name)) in ("San Francisco", "Bob") OR id in (10, 20)
-// (except with parameter substitution.)
-func makeBatchQuery(filters []Filter) (string, []interface{}) {
+//   WHERE (city, name) IN (("San Francisco", "Bob"), ("Oakland", "Alice")) OR id IN (10, 20)
+// (except with parameter substitution.) Filters on several columns, such as
+// composite primary keys, are matched as tuples, unless dialect is an
+// expandTuplesDialect.
+func makeBatchQuery(dialect Dialect, filters []Filter) (string, []interface{}) {
 	// A batchQueryGroup holds all value tuples for a given set of columns in the
 	// WHERE statement.
 	type batchQueryGroup struct {
@@ -60,6 +70,7 @@ func makeBatchQuery(filters []Filter) (string, []interface{}) {
 	sort.Strings(groupKeys)
 
 	// Build the WHERE clause one group at a time.
+	_, expand := dialect.(expandTuplesDialect)
 	var clause bytes.Buffer
 	var args []interface{}
 	for i, key := range groupKeys {
@@ -85,6 +96,25 @@ func makeBatchQuery(filters []Filter) (string, []interface{}) {
 				args = append(args, tuple...)
 			}
 			clause.WriteString(")")
+		} else if !expand {
+			clause.WriteString("(")
+			clause.WriteString(strings.Join(group.columns, ", "))
+			clause.WriteString(") IN (")
+			for j, tuple := range group.tuples {
+				if j > 0 {
+					clause.WriteString(", ")
+				}
+				clause.WriteString("(")
+				for k := range tuple {
+					if k > 0 {
+						clause.WriteString(", ")
+					}
+					clause.WriteString("?")
+				}
+				clause.WriteString(")")
+				args = append(args, tuple...)
+			}
+			clause.WriteString(")")
 		} else {
 
 			for i, tuple := range group.tuples {
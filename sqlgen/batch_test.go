@@ -9,6 +9,7 @@ import (
 func TestMakeBatchQuery(t *testing.T) {
 	testcases := []struct {
 		Title   string
+		Dialect Dialect
 		Filters []Filter
 		Clause  string
 		Args    []interface{}
@@ -30,6 +31,17 @@ func TestMakeBatchQuery(t *testing.T) {
 				{"id_a": 20, "id_b": "bar"},
 				{"id_a": 30, "id_b": "baz"},
 			},
+			Clause: "(id_a, id_b) IN ((?, ?), (?, ?), (?, ?))",
+			Args:   []interface{}{10, "foo", 20, "bar", 30, "baz"},
+		},
+		{
+			Title:   "Expanded compound IDs",
+			Dialect: SQLite,
+			Filters: []Filter{
+				{"id_a": 10, "id_b": "foo"},
+				{"id_a": 20, "id_b": "bar"},
+				{"id_a": 30, "id_b": "baz"},
+			},
 			Clause: "(id_a=? AND id_b=?) OR (id_a=? AND id_b=?) OR (id_a=? AND id_b=?)",
 			Args:   []interface{}{10, "foo", 20, "bar", 30, "baz"},
 		},
@@ -52,13 +64,17 @@ func TestMakeBatchQuery(t *testing.T) {
 				{"id_a": 20, "id_b": "bar"},
 				{"id_a": 30, "id_b": "baz"},
 			},
-			Clause: "id IN (?, ?, ?) OR (id_a=? AND id_b=?) OR (id_a=? AND id_b=?) OR (id_a=? AND id_b=?)",
+			Clause: "id IN (?, ?, ?) OR (id_a, id_b) IN ((?, ?), (?, ?), (?, ?))",
 			Args:   []interface{}{10, 20, 30, 10, "foo", 20, "bar", 30, "baz"},
 		},
 	}
 
 	for _, testcase := range testcases {
-		clause, args := makeBatchQuery(testcase.Filters)
+		dialect := testcase.Dialect
+		if dialect == nil {
+			dialect = MySQL
+		}
+		clause, args := makeBatchQuery(dialect, testcase.Filters)
 		if clause != testcase.Clause {
 			t.Errorf("%s clause: got %s, expected %s", testcase.Title, clause, testcase.Clause)
 		}
@@ -0,0 +1,182 @@
+package sqlgen
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Membership struct {
+	OrgId  int64 `sql:",primary"`
+	UserId int64 `sql:",primary"`
+	Role   string
+}
+
+type LineItem struct {
+	Id      int64 `sql:",primary"`
+	OrderId int64 `sql:",primary"`
+	Sku     string
+}
+
+func TestCompositeKeyQuery(t *testing.T) {
+	s := NewSchema()
+	s.MustRegisterType("memberships", UniqueId, Membership{})
+	s.MustRegisterType("line_items", AutoIncrement, LineItem{})
+
+	// Projections need every primary key column.
+	assert.Error(t, s.RegisterProjection("memberships", struct {
+		OrgId int64
+		Role  string
+	}{}))
+	require.NoError(t, s.RegisterProjection("memberships", struct{ OrgId, UserId int64 }{}))
+
+	insert, err := s.MakeInsertRow(&Membership{OrgId: 1, UserId: 2, Role: "admin"})
+	require.NoError(t, err)
+	clause, args := insert.ToSQL()
+	assert.Equal(t, "INSERT INTO memberships (org_id, user_id, role) VALUES (?, ?, ?)", clause)
+	assert.Equal(t, []interface{}{int64(1), int64(2), "admin"}, args)
+
+	update, err := s.MakeUpdateRow(&Membership{OrgId: 1, UserId: 2, Role: "member"})
+	require.NoError(t, err)
+	clause, args = update.ToSQL()
+	assert.Equal(t, "UPDATE memberships SET role = ? WHERE org_id = ? AND user_id = ?", clause)
+	assert.Equal(t, []interface{}{"member", int64(1), int64(2)}, args)
+
+	del, err := s.MakeDeleteRow(&Membership{OrgId: 1, UserId: 2})
+	require.NoError(t, err)
+	clause, args = del.ToSQL()
+	assert.Equal(t, "DELETE FROM memberships WHERE org_id = ? AND user_id = ?", clause)
+	assert.Equal(t, []interface{}{int64(1), int64(2)}, args)
+
+	// Only the first primary key column of an AutoIncrement table is assigned
+	// by the database.
+	insert, err = s.MakeInsertRow(&LineItem{Id: 5, OrderId: 3, Sku: "owl"})
+	require.NoError(t, err)
+	clause, args = insert.ToSQL()
+	assert.Equal(t, "INSERT INTO line_items (order_id, sku) VALUES (?, ?)", clause)
+	assert.Equal(t, []interface{}{int64(3), "owl"}, args)
+
+	// AutoIncrement keys that inserts cannot set to the assigned id still
+	// register, and are left alone by inserts.
+	for _, value := range []interface{}{
+		struct {
+			Key string `sql:",primary"`
+		}{},
+		struct {
+			Id *int64 `sql:",primary"`
+		}{},
+	} {
+		s := NewSchema()
+		require.NoError(t, s.RegisterType("legacy", AutoIncrement, value))
+		assert.Nil(t, s.ByName["legacy"].insertIdColumn())
+	}
+}
+
+type PointerId struct {
+	Id   *int64 `sql:",primary"`
+	Name string
+}
+
+func TestSQLitePointerAutoIncrement(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("pointer_ids", AutoIncrement, PointerId{})
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer testDb.Close()
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+	ctx := context.Background()
+
+	row := &PointerId{Name: "a"}
+	_, err = db.InsertRow(ctx, row)
+	require.NoError(t, err)
+	assert.Nil(t, row.Id)
+	require.NoError(t, db.InsertRows(ctx, []*PointerId{{Name: "b"}, {Name: "c"}}, 10))
+
+	var rows []*PointerId
+	require.NoError(t, db.Query(ctx, &rows, nil, &SelectOptions{OrderBy: "id"}))
+	require.Len(t, rows, 3)
+	assert.Equal(t, "c", rows[2].Name)
+	require.NotNil(t, rows[2].Id)
+	assert.Equal(t, int64(3), *rows[2].Id)
+}
+
+func TestSQLiteCompositeKeys(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("memberships", UniqueId, Membership{})
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer testDb.Close()
+	hook := &recordingHook{}
+	db, err := NewDBWithDialect(testDb.DB, schema, SQLite).WithQueryHook(hook)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*Membership{
+		{OrgId: 1, UserId: 1, Role: "admin"},
+		{OrgId: 1, UserId: 2, Role: "member"},
+		{OrgId: 2, UserId: 1, Role: "member"},
+	}, 10))
+
+	require.NoError(t, db.UpdateRow(ctx, &Membership{OrgId: 1, UserId: 2, Role: "admin"}))
+	require.NoError(t, db.DeleteRow(ctx, &Membership{OrgId: 2, UserId: 1}))
+
+	// Queries by composite key are batched into a single statement.
+	hook.after = nil
+	batchCtx := batch.WithBatching(ctx)
+	keys := [][2]int64{{1, 1}, {1, 2}, {2, 1}}
+	results := make([]*Membership, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key [2]int64) {
+			defer wg.Done()
+			var membership *Membership
+			err := db.QueryRow(batchCtx, &membership, Filter{"org_id": key[0], "user_id": key[1]}, nil)
+			if err == nil {
+				results[i] = membership
+			}
+		}(i, key)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []*Membership{
+		{OrgId: 1, UserId: 1, Role: "admin"},
+		{OrgId: 1, UserId: 2, Role: "admin"},
+		nil,
+	}, results)
+	require.Len(t, hook.after, 1)
+	assert.True(t, hook.after[0].Batched)
+}
+
+func TestSQLiteInsertRowsIds(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	first := &User{Name: "first"}
+	_, err := db.InsertRow(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.Id)
+
+	// Every chunk of rows gets the ids assigned by its statement.
+	var users []*User
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		users = append(users, &User{Name: name})
+	}
+	require.NoError(t, db.InsertRows(ctx, users, 2))
+	for i, user := range users {
+		var fetched *User
+		require.NoError(t, db.QueryRow(ctx, &fetched, Filter{"id": user.Id}, nil))
+		assert.Equal(t, int64(i+2), user.Id)
+		assert.Equal(t, user.Name, fetched.Name)
+	}
+}
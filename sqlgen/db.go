@@ -3,11 +3,9 @@ package sqlgen
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
-	"strings"
 
 	"github.com/samsarahq/go/oops"
 	"github.com/samson-crypto/thunder/batch"
@@ -17,15 +15,25 @@ import (
 // database connection exists and is alive at all times during the lifecycle of
 // the object.
 type DB struct {
-	Conn   *sql.DB
-	Schema *Schema
+	Conn    *sql.DB
+	Schema  *Schema
+	dialect Dialect
 
 	batchFetch *batch.Func
 	shardLimit Filter
+	shardKey   *shardKeyLimit
 
 	dynamicLimit DynamicLimit
 
 	panicOnNoIndex bool
+
+	hooks []QueryHook
+
+	indexChecker *indexChecker
+
+	replicas *replicas
+
+	txRetryPolicy *TxRetryPolicy
 }
 
 type DynamicLimitFilterCallback func(context.Context, string) Filter
@@ -52,26 +60,62 @@ type DynamicLimit struct {
 	ShouldContinueOnError DynamicLimitErrorCallback
 }
 
+// NewDB creates a DB for a MySQL database.
 func NewDB(conn *sql.DB, schema *Schema) *DB {
+	return NewDBWithDialect(conn, schema, MySQL)
+}
+
+// NewDBWithDialect creates a DB that generates statements for dialect.
+func NewDBWithDialect(conn *sql.DB, schema *Schema, dialect Dialect) *DB {
 	db := &DB{
 		Conn:           conn,
 		Schema:         schema,
+		dialect:        dialect,
 		panicOnNoIndex: false,
 	}
+	db.batchFetch = db.newBatchFetch()
+	return db
+}
+
+// clone returns a copy of db for a With method to modify. Batched queries run
+// on the DB that built batchFetch, so the copy builds its own, which runs
+// queries with the copy's settings.
+func (db *DB) clone() *DB {
+	dbCopy := *db
+	dbCopy.batchFetch = dbCopy.newBatchFetch()
+	return &dbCopy
+}
 
-	db.batchFetch = &batch.Func{
+// newBatchFetch builds the batch.Func that runs batched queries on db.
+func (db *DB) newBatchFetch() *batch.Func {
+	return &batch.Func{
 		Many: func(ctx context.Context, items []interface{}) ([]interface{}, error) {
-			table := items[0].(*BaseSelectQuery).Table
+			if _, ok := items[0].(*BaseAggregateQuery); ok {
+				return db.batchAggregates(ctx, items)
+			}
 
-			// First, build the SQL query.
+			first := items[0].(*BaseSelectQuery)
+			table := first.Table
+			var columns []string
+			if first.Options != nil {
+				columns = first.Options.Columns
+			}
+
+			// First, build the SQL query. The soft-delete scope is the same for
+			// all queries, so it is applied once.
 			filters := make([]Filter, 0, len(items))
 			for _, item := range items {
-				filters = append(filters, item.(*BaseSelectQuery).Filter)
+				filters = append(filters, item.(*BaseSelectQuery).batchFilter())
+			}
+			clause, args := makeBatchQuery(db.dialect, filters)
+			var scope Filter
+			if first.scoped {
+				scope = table.baseTable().scopeFilter(nil)
 			}
-			clause, args := makeBatchQuery(filters)
-			query, err := db.Schema.makeSelect(table.Type, nil, &SelectOptions{
-				Where:  clause,
-				Values: args,
+			query, err := db.Schema.makeSelect(table.Type, scope, &SelectOptions{
+				Where:   clause,
+				Values:  args,
+				Columns: columns,
 			})
 			if err != nil {
 				return nil, err
@@ -80,16 +124,20 @@ func NewDB(conn *sql.DB, schema *Schema) *DB {
 			if err != nil {
 				return nil, err
 			}
-			clause, args = selectQuery.ToSQL()
+			clause, args = db.toSQL(selectQuery)
 
 			// Then, run the SQL query.
-			res, err := db.Conn.QueryContext(ctx, clause, args...)
-			if err != nil {
-				return nil, err
-			}
-			defer res.Close()
-			rows, err := db.Schema.ParseRows(selectQuery, res)
-			if err != nil {
+			var rows []interface{}
+			event := &QueryEvent{Operation: "Query", Table: table.Name, Clause: clause, Args: args, Batched: true}
+			if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+				res, err := db.reader(ctx, first.readPrimary).QueryContext(ctx, clause, args...)
+				if err != nil {
+					return -1, err
+				}
+				defer res.Close()
+				rows, err = db.Schema.ParseRows(selectQuery, res)
+				return int64(len(rows)), err
+			}); err != nil {
 				return nil, err
 			}
 
@@ -101,7 +149,7 @@ func NewDB(conn *sql.DB, schema *Schema) *DB {
 				// and filters to flatten out all pointers to values, etc., to copy what
 				// the row tester does when matching against the binlog. This way, a filter
 				// specifying age=48 will match a value *age=48.
-				matcher.add(i, coerceMap(query.Filter))
+				matcher.add(i, coerceMap(query.batchFilter()))
 			}
 			results := make([][]interface{}, len(items))
 			for _, row := range rows {
@@ -121,10 +169,29 @@ func NewDB(conn *sql.DB, schema *Schema) *DB {
 			return rawResults, nil
 		},
 		Shard: func(item interface{}) interface{} {
-			return item.(*BaseSelectQuery).Table
+			if query, ok := item.(*BaseAggregateQuery); ok {
+				return query.batchKey()
+			}
+			return item.(*BaseSelectQuery).batchKey()
 		},
 	}
-	return db
+}
+
+// Dialect returns the dialect of the DB's statements.
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// toSQL builds the statement for query in the DB's dialect.
+func (db *DB) toSQL(query SQLQuery) (string, []interface{}) {
+	var clause string
+	var args []interface{}
+	if q, ok := query.(dialectQuery); ok {
+		clause, args = q.toDialectSQL(db.dialect)
+	} else {
+		clause, args = query.ToSQL()
+	}
+	return db.dialect.Rebind(clause, args)
 }
 
 // WithShardLimit scopes the DB to only allow queries with the given key-value
@@ -136,9 +203,9 @@ func (db *DB) WithShardLimit(shardLimit Filter) (*DB, error) {
 		return nil, errors.New("already has shard limit")
 	}
 
-	dbCopy := *db
+	dbCopy := db.clone()
 	dbCopy.shardLimit = shardLimit
-	return &dbCopy, nil
+	return dbCopy, nil
 }
 
 // WithPanicOnNoIndex will configure this db connection to run an
@@ -162,9 +229,9 @@ func (db *DB) WithDynamicLimit(dynamicLimit DynamicLimit) (*DB, error) {
 		return nil, errors.New("already has dynamic limit")
 	}
 
-	dbCopy := *db
+	dbCopy := db.clone()
 	dbCopy.dynamicLimit = dynamicLimit
-	return &dbCopy, nil
+	return dbCopy, nil
 }
 
 func (db *DB) checkFilterAgainstLimit(filter Filter, limit Filter) error {
@@ -181,6 +248,8 @@ func (db *DB) checkFilterAgainstLimit(filter Filter, limit Filter) error {
 }
 
 func (db *DB) checkFilterAgainstLimits(ctx context.Context, query SQLQuery, filter Filter, table *Table) error {
+	filter = StripUnscoped(filter)
+
 	// Check for shard limit
 	if db.shardLimit != nil {
 		err := db.checkFilterAgainstLimit(filter, db.shardLimit)
@@ -189,6 +258,13 @@ func (db *DB) checkFilterAgainstLimits(ctx context.Context, query SQLQuery, filt
 		}
 	}
 
+	// Check for the shard key of a ShardedDB shard.
+	if db.shardKey != nil {
+		if err := db.shardKey.checkFilter(table, filter); err != nil {
+			return fmt.Errorf("check failed for shard: %s", err.Error())
+		}
+	}
+
 	// Check for dynamic limit.
 	if db.dynamicLimit.GetLimitFilter != nil && db.dynamicLimit.ShouldContinueOnError != nil {
 		limitFilter := db.dynamicLimit.GetLimitFilter(ctx, table.Name)
@@ -235,6 +311,13 @@ func (db *DB) checkColumnValuesAgainstLimits(ctx context.Context, query SQLQuery
 		}
 	}
 
+	// Check for the shard key of a ShardedDB shard.
+	if db.shardKey != nil {
+		if err := db.shardKey.checkColumnValues(columns, values); err != nil {
+			return fmt.Errorf("column values check failed for shard: %s", err.Error())
+		}
+	}
+
 	// Check for dynamic limit.
 	if db.dynamicLimit.GetLimitFilter != nil && db.dynamicLimit.ShouldContinueOnError != nil {
 		limitFilter := db.dynamicLimit.GetLimitFilter(ctx, tableName)
@@ -281,38 +364,28 @@ func parseExplainResults(resultRows *sql.Rows) (results []ExplainResultRow, err
 
 func (db *DB) runExplainQuery(ctx context.Context, clause string, args []interface{}) error {
 	// We run an explain first and panic if there's no index
-	explained := "EXPLAIN " + clause
-	res, err := db.QueryExecer(ctx).QueryContext(ctx, explained, args...)
+	plan, ok, err := db.dialect.CheckIndex(ctx, db.QueryExecer(ctx), clause, args)
 	if err != nil {
-		return oops.Wrapf(err, "Failed to run explain on the query")
-	}
-	defer res.Close()
-
-	explainRes, err := parseExplainResults(res)
-	if err != nil {
-		return oops.Wrapf(err, "failed to parse explain results")
+		return err
 	}
 
-	for _, explain := range explainRes {
-		// The query is ok if it has an index, a possible index, or is hitting const tables and
-		// finding no rows (this last case returns "Impossible WHERE...")
-		if explain.Key == nil && explain.PossibleKeys == nil && (explain.Extra == nil || !strings.HasPrefix(*explain.Extra, "Impossible WHERE")) {
-			explainJSON, _ := json.Marshal(explain)
-			helpMsg := "If you get this message, either check your indices or you can explicitly use a FullScanQuery knowing you're performing a full table scan."
-			panic(fmt.Sprintf(
-				"A sql query was used that misses indexes. %s\n\n%s\n\nwith args\n%s\n\n%s",
-				helpMsg,
-				clause,
-				args,
-				string(explainJSON),
-			))
-		}
+	if !ok {
+		helpMsg := "If you get this message, either check your indices or you can explicitly use a FullScanQuery knowing you're performing a full table scan."
+		panic(fmt.Sprintf(
+			"A sql query was used that misses indexes. %s\n\n%s\n\nwith args\n%s\n\n%s",
+			helpMsg,
+			clause,
+			args,
+			plan,
+		))
 	}
 
 	return nil
 }
 
 func (db *DB) BaseQuery(ctx context.Context, query *BaseSelectQuery) ([]interface{}, error) {
+	batchable := query.batchable()
+
 	selectQuery, err := query.MakeSelectQuery()
 	if err != nil {
 		return nil, err
@@ -322,7 +395,10 @@ func (db *DB) BaseQuery(ctx context.Context, query *BaseSelectQuery) ([]interfac
 		return nil, err
 	}
 
-	if query.Options == nil && !db.HasTx(ctx) && batch.HasBatching(ctx) {
+	primary := db.readsFromPrimary(ctx, query.Options != nil && query.Options.ForUpdate)
+
+	if batchable && !db.HasTx(ctx) && batch.HasBatching(ctx) {
+		query.readPrimary = primary
 		rows, err := db.batchFetch.Invoke(ctx, query)
 		if err != nil {
 			return nil, err
@@ -330,28 +406,50 @@ func (db *DB) BaseQuery(ctx context.Context, query *BaseSelectQuery) ([]interfac
 		return rows.([]interface{}), nil
 	}
 
-	clause, args := selectQuery.ToSQL()
+	clause, args := db.toSQL(selectQuery)
 
-	if db.panicOnNoIndex && (query.Options == nil || !query.Options.AllowNoIndex) {
-		err = db.runExplainQuery(ctx, clause, args)
-		if err != nil {
+	if query.Options == nil || !query.Options.AllowNoIndex {
+		if err := db.checkIndex(ctx, query.Table.Name, clause, args); err != nil {
 			return nil, oops.Wrapf(err, "Failed to run explain query")
 		}
 	}
 
-	res, err := db.QueryExecer(ctx).QueryContext(ctx, clause, args...)
-	if err != nil {
+	var rows []interface{}
+	event := &QueryEvent{Operation: "Query", Table: query.Table.Name, Clause: clause, Args: args}
+	if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		res, err := db.reader(ctx, primary).QueryContext(ctx, clause, args...)
+		if err != nil {
+			return -1, err
+		}
+		defer res.Close()
+		rows, err = db.Schema.ParseRows(selectQuery, res)
+		return int64(len(rows)), err
+	}); err != nil {
 		return nil, err
 	}
-	defer res.Close()
-
-	return db.Schema.ParseRows(selectQuery, res)
+	return rows, nil
 }
 
 func (db *DB) execWithTrace(ctx context.Context, query SQLQuery, operationName string) (sql.Result, error) {
-	clause, args := query.ToSQL()
+	clause, args := db.toSQL(query)
 
-	return db.QueryExecer(ctx).ExecContext(ctx, clause, args...)
+	var res sql.Result
+	event := &QueryEvent{Operation: operationName, Table: queryTable(query), Clause: clause, Args: args}
+	if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		var err error
+		if res, err = db.QueryExecer(ctx).ExecContext(ctx, clause, args...); err != nil {
+			return -1, err
+		}
+		markWrite(ctx)
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return -1, nil
+		}
+		return affected, nil
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 // Count counts the number of relevant rows in a database, matching options in filter
@@ -376,10 +474,16 @@ func (db *DB) Count(ctx context.Context, model interface{}, filter Filter) (int6
 		return 0, err
 	}
 
-	clause, args := countQuery.ToSQL()
+	clause, args := db.toSQL(countQuery)
+	if err := db.checkIndex(ctx, query.Table.Name, clause, args); err != nil {
+		return 0, err
+	}
+
 	var count int64
-	err = db.QueryExecer(ctx).QueryRowContext(ctx, clause, args...).Scan(&count)
-	if err != nil {
+	event := &QueryEvent{Operation: "Count", Table: countQuery.Table, Clause: clause, Args: args}
+	if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		return 1, db.reader(ctx, db.readsFromPrimary(ctx, false)).QueryRowContext(ctx, clause, args...).Scan(&count)
+	}); err != nil {
 		return 0, err
 	}
 
@@ -451,6 +555,9 @@ func (db *DB) QueryRow(ctx context.Context, result interface{}, filter Filter, o
 //   user := &User{Name: "foo"}
 //   if err := db.InsertRow(ctx, user); err != nil {
 //
+// InsertRow sets row's auto-increment primary key column, if any, to the id
+// the database assigned. If row's table has readonly or default columns,
+// InsertRow also reads their values back into row.
 func (db *DB) InsertRow(ctx context.Context, row interface{}) (sql.Result, error) {
 	query, err := db.Schema.MakeInsertRow(row)
 	if err != nil {
@@ -461,7 +568,165 @@ func (db *DB) InsertRow(ctx context.Context, row interface{}) (sql.Result, error
 		return nil, err
 	}
 
-	return db.execWithTrace(ctx, query, "InsertRow")
+	table := db.Schema.ByName[query.Table]
+	column := table.insertIdColumn()
+	if column == nil {
+		res, err := db.execWithTrace(ctx, query, "InsertRow")
+		if err != nil {
+			return nil, err
+		}
+		if err := db.readServerFilled(ctx, table, row, res, "InsertRow"); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	ids, res, err := db.insertIds(ctx, query, column, 1, "InsertRow")
+	if err != nil {
+		return nil, err
+	}
+	if err := table.setAutoIncrementIds([]interface{}{row}, ids); err != nil {
+		return nil, err
+	}
+	if err := db.readServerFilled(ctx, table, row, res, "InsertRow"); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// readServerFilled reads the values of table's readonly and default columns
+// back into row after it was written with result res. If res reports that no
+// rows were affected, for example because an upsert ignored a conflict or left
+// an identical row unchanged, nothing was written and row is left as is. It
+// is an error if the written row cannot be found by its primary key.
+func (db *DB) readServerFilled(ctx context.Context, table *Table, row interface{}, res sql.Result, operationName string) error {
+	columns := table.serverFilledColumns()
+	if len(columns) == 0 {
+		return nil
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil
+	}
+
+	values, err := table.unbuildStruct(row)
+	if err != nil {
+		return err
+	}
+	where := &SimpleWhere{}
+	for i, column := range table.Columns {
+		if column.Primary {
+			where.Columns = append(where.Columns, column.Name)
+			where.Values = append(where.Values, values[i])
+		}
+	}
+	whereClause, whereValues := where.ToSQL()
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	query := &SelectQuery{
+		Table:   table.Name,
+		Columns: names,
+		Options: &SelectOptions{Where: whereClause, Values: whereValues},
+	}
+
+	elem := reflect.ValueOf(row).Elem()
+	scanners := table.Scanners.Get().([]interface{})
+	defer table.Scanners.Put(scanners)
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		targets[i] = scanTarget(scanners[column.Order], elem, column)
+	}
+
+	clause, args := db.toSQL(query)
+	event := &QueryEvent{Operation: operationName, Table: table.Name, Clause: clause, Args: args}
+	return db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		err := db.QueryExecer(ctx).QueryRowContext(ctx, clause, args...).Scan(targets...)
+		if err == sql.ErrNoRows {
+			// The write went to another row, for example an upsert that
+			// conflicted on another unique key.
+			return 0, fmt.Errorf("sqlgen: %s row written by %s not found by its primary key: %w", table.Name, operationName, err)
+		}
+		if err != nil {
+			return -1, err
+		}
+		return 1, nil
+	})
+}
+
+// insertResult is the sql.Result of an INSERT ... RETURNING statement.
+type insertResult struct {
+	id   int64
+	rows int64
+}
+
+func (r insertResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r insertResult) RowsAffected() (int64, error) { return r.rows, nil }
+
+// A lastRowIdDialect is a Dialect whose sql.Result.LastInsertId after a
+// multi-row insert is the id of the last row, rather than the first.
+type lastRowIdDialect interface {
+	lastInsertIdIsLastRow()
+}
+
+// insertIds runs query, which inserts count rows into a table with the
+// auto-increment column, and returns the ids assigned to the rows in order.
+//
+// Dialects with a RETURNING clause return every id. Otherwise, the ids are
+// derived from sql.Result.LastInsertId, which assumes the rows of a single
+// statement are assigned consecutive ids, as MySQL does unless
+// auto_increment_increment is changed.
+func (db *DB) insertIds(ctx context.Context, query SQLQuery, column *Column, count int, operationName string) ([]int64, sql.Result, error) {
+	returning := db.dialect.ReturningClause(column.Name)
+	if returning == "" {
+		res, err := db.execWithTrace(ctx, query, operationName)
+		if err != nil {
+			return nil, nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := db.dialect.(lastRowIdDialect); ok {
+			id -= int64(count - 1)
+		}
+		ids := make([]int64, count)
+		for i := range ids {
+			ids[i] = id + int64(i)
+		}
+		return ids, res, nil
+	}
+
+	clause, args := db.toSQL(query)
+	clause += returning
+
+	var ids []int64
+	event := &QueryEvent{Operation: operationName, Table: queryTable(query), Clause: clause, Args: args}
+	if err := db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		rows, err := db.QueryExecer(ctx).QueryContext(ctx, clause, args...)
+		if err != nil {
+			return -1, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return -1, err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return -1, err
+		}
+		markWrite(ctx)
+		return int64(len(ids)), nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	if len(ids) != count {
+		return nil, nil, fmt.Errorf("inserted %d rows but got %d ids", count, len(ids))
+	}
+	return ids, insertResult{id: ids[len(ids)-1], rows: int64(len(ids))}, nil
 }
 
 // InsertRows inserts multiple rows into the database, chunksize rows at a time.
@@ -474,6 +739,10 @@ func (db *DB) InsertRow(ctx context.Context, row interface{}) (sql.Result, error
 //   user2 := &User{Name: "fan"}
 //   if err := db.InsertRows(ctx, [](*User){user1, user2}, 100); err != nil {
 //
+// Every chunk is inserted with a single statement, in one transaction. Like
+// InsertRow, InsertRows sets the auto-increment primary key column of each
+// row to its id, taking the ids of a chunk from that chunk's statement. Unlike
+// InsertRow, it does not read back readonly and default columns.
 func (db *DB) InsertRows(ctx context.Context, rows interface{}, chunkSize int) error {
 	val := reflect.ValueOf(rows)
 	kind := val.Kind()
@@ -486,7 +755,7 @@ func (db *DB) InsertRows(ctx context.Context, rows interface{}, chunkSize int) e
 		rowsData[i] = val.Index(i).Interface()
 	}
 
-	var tx *sql.Tx
+	var tx Tx
 	if !db.HasTx(ctx) {
 		var err error
 		ctx, tx, err = db.WithTx(ctx)
@@ -514,10 +783,21 @@ func (db *DB) InsertRows(ctx context.Context, rows interface{}, chunkSize int) e
 				return err
 			}
 		}
-		_, err = db.execWithTrace(ctx, query, "InsertRows")
+		table := db.Schema.ByName[query.Table]
+		column := table.insertIdColumn()
+		if column == nil {
+			if _, err := db.execWithTrace(ctx, query, "InsertRows"); err != nil {
+				return err
+			}
+			continue
+		}
+		ids, _, err := db.insertIds(ctx, query, column, len(slice), "InsertRows")
 		if err != nil {
 			return err
 		}
+		if err := table.setAutoIncrementIds(slice, ids); err != nil {
+			return err
+		}
 	}
 
 	if tx != nil {
@@ -537,6 +817,17 @@ func (db *DB) InsertRows(ctx context.Context, rows interface{}, chunkSize int) e
 //   if err := db.UpsertRows(ctx, [](*User){user1, user2}, 100); err != nil {
 //
 func (db *DB) UpsertRows(ctx context.Context, rows interface{}, chunkSize int) error {
+	return db.UpsertRowsWithOptions(ctx, rows, chunkSize, nil)
+}
+
+// UpsertRowsWithOptions is UpsertRows with options that choose how conflicting
+// rows are updated, for example:
+//
+//   err := db.UpsertRowsWithOptions(ctx, counters, 100, &UpsertOptions{
+//     KeepColumns: []string{"created_at"},
+//     Expressions: map[string]string{"count": "count + VALUES(count)"},
+//   })
+func (db *DB) UpsertRowsWithOptions(ctx context.Context, rows interface{}, chunkSize int, options *UpsertOptions) error {
 	val := reflect.ValueOf(rows)
 	kind := val.Kind()
 	if kind != reflect.Slice && kind != reflect.Array {
@@ -548,7 +839,7 @@ func (db *DB) UpsertRows(ctx context.Context, rows interface{}, chunkSize int) e
 		rowsData[i] = val.Index(i).Interface()
 	}
 
-	var tx *sql.Tx
+	var tx Tx
 	if !db.HasTx(ctx) {
 		var err error
 		ctx, tx, err = db.WithTx(ctx)
@@ -565,7 +856,7 @@ func (db *DB) UpsertRows(ctx context.Context, rows interface{}, chunkSize int) e
 		}
 		slice := rowsData[j : j+sliceLength]
 
-		query, err := db.Schema.MakeBatchUpsertRow(slice)
+		query, err := db.Schema.MakeBatchUpsertRowWithOptions(slice, options)
 		if err != nil {
 			return err
 		}
@@ -596,7 +887,14 @@ func (db *DB) UpsertRows(ctx context.Context, rows interface{}, chunkSize int) e
 //   if err := db.UpsertRow(ctx, user); err != nil {
 //
 func (db *DB) UpsertRow(ctx context.Context, row interface{}) (sql.Result, error) {
-	query, err := db.Schema.MakeUpsertRow(row)
+	return db.UpsertRowWithOptions(ctx, row, nil)
+}
+
+// UpsertRowWithOptions is UpsertRow with options that choose how a conflicting
+// row is updated. Like InsertRow, it reads the values of readonly and default
+// columns back into row.
+func (db *DB) UpsertRowWithOptions(ctx context.Context, row interface{}, options *UpsertOptions) (sql.Result, error) {
+	query, err := db.Schema.MakeUpsertRowWithOptions(row, options)
 	if err != nil {
 		return nil, err
 	}
@@ -605,9 +903,21 @@ func (db *DB) UpsertRow(ctx context.Context, row interface{}) (sql.Result, error
 		return nil, err
 	}
 
-	return db.execWithTrace(ctx, query, "UpsertRow")
+	res, err := db.execWithTrace(ctx, query, "UpsertRow")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.readServerFilled(ctx, db.Schema.ByName[query.Table], row, res, "UpsertRow"); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
+// ErrStaleRow is returned by UpdateRow and DeleteRow when a row with a version
+// column was modified since it was read, and by UpdateRowWhere when the row
+// does not match the filter.
+var ErrStaleRow = errors.New("sqlgen: row is stale or does not exist")
+
 // UpdateRow updates a single row in the database, identified by the row's primary key
 //
 // row should be a pointer to a struct, for example:
@@ -615,8 +925,71 @@ func (db *DB) UpsertRow(ctx context.Context, row interface{}) (sql.Result, error
 //   user := &User{Id; 10, Name: "bar"}
 //   if err := db.UpdateRow(ctx, user); err != nil {
 //
+// If row has a version column, for example
+//
+//   Version int64 `sql:",version"`
+//
+// UpdateRow only updates the row if its version in the database matches row,
+// and increments the version in both. Otherwise, it returns ErrStaleRow.
 func (db *DB) UpdateRow(ctx context.Context, row interface{}) error {
-	query, err := db.Schema.MakeUpdateRow(row)
+	return db.updateRow(ctx, row, nil, nil, "UpdateRow")
+}
+
+// UpdateRowWhere updates a single row in the database, identified by the row's
+// primary key, if the row also matches filter. If no row was updated, it
+// returns ErrStaleRow. For example, to only update a pending order:
+//
+//   order := &Order{Id: 10, State: "paid"}
+//   err := db.UpdateRowWhere(ctx, order, Filter{"state": "pending"})
+//   if err == sqlgen.ErrStaleRow { ... }
+//
+// MySQL counts changed rows rather than matched rows unless the connection sets
+// clientFoundRows=true, so an update that does not change any values also
+// returns ErrStaleRow unless row has a version column.
+func (db *DB) UpdateRowWhere(ctx context.Context, row interface{}, filter Filter) error {
+	if filter == nil {
+		filter = Filter{}
+	}
+	return db.updateRow(ctx, row, filter, nil, "UpdateRow")
+}
+
+// UpdateColumns updates only the given columns of a single row in the
+// database, identified by the row's primary key, leaving concurrent writes to
+// other columns intact. For example:
+//
+//   user := &User{Id: 10, Name: "bar"}
+//   if err := db.UpdateColumns(ctx, user, "name"); err != nil {
+//
+// Like UpdateRow, it checks and increments the row's version column.
+func (db *DB) UpdateColumns(ctx context.Context, row interface{}, columns ...string) error {
+	if columns == nil {
+		columns = []string{}
+	}
+	return db.updateRow(ctx, row, nil, columns, "UpdateRow")
+}
+
+// UpdateRowFrom updates the columns that differ between before and after, two
+// copies of the same row, by writing them from after. If no columns differ,
+// it does not query the database. For example:
+//
+//   before := *user
+//   user.Name = "bar"
+//   if err := db.UpdateRowFrom(ctx, &before, user); err != nil {
+//
+// Like UpdateRow, it checks and increments after's version column.
+func (db *DB) UpdateRowFrom(ctx context.Context, before, after interface{}) error {
+	columns, err := db.Schema.changedColumns(before, after)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	return db.updateRow(ctx, after, nil, columns, "UpdateRow")
+}
+
+func (db *DB) updateRow(ctx context.Context, row interface{}, filter Filter, columns []string, operationName string) error {
+	query, err := db.Schema.makeUpdateRow(row, filter, columns)
 	if err != nil {
 		return err
 	}
@@ -629,8 +1002,23 @@ func (db *DB) UpdateRow(ctx context.Context, row interface{}) error {
 		return err
 	}
 
-	_, err = db.execWithTrace(ctx, query, "UpsertRow")
-	return err
+	res, err := db.execWithTrace(ctx, query, operationName)
+	if err != nil {
+		return err
+	}
+
+	version := db.Schema.ByName[query.Table].versionColumn()
+	if version == nil && filter == nil {
+		return nil
+	}
+	if err := checkRowAffected(res); err != nil {
+		return err
+	}
+	if version != nil {
+		field := reflect.ValueOf(row).Elem().FieldByIndex(version.Index)
+		field.SetInt(field.Int() + 1)
+	}
+	return nil
 }
 
 // DeleteRow deletes a single row from the database, identified by the row's primary key
@@ -640,7 +1028,31 @@ func (db *DB) UpdateRow(ctx context.Context, row interface{}) error {
 //   user := &User{Id; 10}
 //   if err := db.DeleteRow(ctx, user); err != nil {
 //
+// If row's table has a SoftDelete column, DeleteRow sets it to the current
+// time in the database instead of deleting the row, and then in row.
+//
+// If row has a version column, DeleteRow only deletes the row if its version
+// in the database matches row. Otherwise, it returns ErrStaleRow.
 func (db *DB) DeleteRow(ctx context.Context, row interface{}) error {
+	deleted, column, err := db.Schema.softDeleteRow(row)
+	if err == errNotSoftDeleted {
+		return db.HardDelete(ctx, row)
+	} else if err != nil {
+		return err
+	}
+	if err := db.updateRow(ctx, deleted, nil, []string{column}, "DeleteRow"); err != nil {
+		return err
+	}
+	reflect.ValueOf(row).Elem().Set(reflect.ValueOf(deleted).Elem())
+	return nil
+}
+
+// HardDelete deletes a single row from the database, identified by the row's
+// primary key, even if its table has a SoftDelete column.
+//
+// If row has a version column, HardDelete only deletes the row if its version
+// in the database matches row. Otherwise, it returns ErrStaleRow.
+func (db *DB) HardDelete(ctx context.Context, row interface{}) error {
 	query, err := db.Schema.MakeDeleteRow(row)
 	if err != nil {
 		return err
@@ -650,8 +1062,26 @@ func (db *DB) DeleteRow(ctx context.Context, row interface{}) error {
 		return err
 	}
 
-	_, err = db.execWithTrace(ctx, query, "DeleteRow")
-	return err
+	res, err := db.execWithTrace(ctx, query, "DeleteRow")
+	if err != nil {
+		return err
+	}
+	if db.Schema.ByName[query.Table].versionColumn() != nil {
+		return checkRowAffected(res)
+	}
+	return nil
+}
+
+// checkRowAffected returns ErrStaleRow if res did not affect any rows.
+func checkRowAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrStaleRow
+	}
+	return nil
 }
 
 // txKey is used as a key for a context.Context to hold a transaction.
@@ -663,15 +1093,19 @@ type txKey struct {
 
 // WithTx begins a transaction and returns a derived Context that contains
 // that transaction. It also returns the transaction value itself, for the
-// caller to manipulate (e.g., Commit).
-// It is an error to invoke this method on a Context that already contains
-// a transaction for this DB.
+// caller to manipulate (e.g., Commit), which is a *sql.Tx.
+// If the Context already contains a transaction for this DB, WithTx nests a
+// savepoint in it instead: Commit releases the savepoint, and Rollback undoes
+// only the changes made since WithTx.
 // On error WithTx returns a non-nil Context, so that the caller can
 // still easily use its Context (e.g., to log the error).
-func (db *DB) WithTx(ctx context.Context) (context.Context, *sql.Tx, error) {
-	maybeTx := ctx.Value(txKey{db: db.Conn})
-	if maybeTx != nil {
-		return ctx, nil, errors.New("already in a tx")
+func (db *DB) WithTx(ctx context.Context) (context.Context, Tx, error) {
+	if db.HasTx(ctx) {
+		savepoint, err := db.WithSavepoint(ctx)
+		if err != nil {
+			return ctx, nil, err
+		}
+		return ctx, &savepointTx{Tx: savepoint.tx, ctx: ctx, savepoint: savepoint}, nil
 	}
 
 	tx, err := db.Conn.BeginTx(ctx, nil)
@@ -0,0 +1,321 @@
+package sqlgen
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// columnKind classifies the values stored in a column.
+type columnKind int
+
+const (
+	kindInt columnKind = iota
+	kindFloat
+	kindBool
+	kindString
+	kindBytes
+	kindTime
+	// kindText is long text, such as JSON.
+	kindText
+)
+
+// ddlDialect is a Dialect that can generate and describe tables.
+type ddlDialect interface {
+	// columnType returns the type of a column storing values of kind.
+	columnType(kind columnKind, primary bool) string
+	// autoIncrementColumn returns the definition of an auto-increment
+	// primary key column.
+	autoIncrementColumn(name string) string
+	// describeTable returns the columns of a table in the database, or no
+	// columns if the table does not exist.
+	describeTable(ctx context.Context, q QueryExecer, table string) ([]dbColumn, error)
+}
+
+// dbColumn describes a column in the database.
+type dbColumn struct {
+	Name     string
+	DataType string
+	Nullable bool
+	Primary  bool
+}
+
+// CreateTableStatements returns CREATE TABLE statements for all registered
+// tables in dialect, ordered by table name. Column types are derived from the
+// values sqlgen writes for each field, so that rows round-trip through the
+// generated tables.
+func (s *Schema) CreateTableStatements(dialect Dialect) ([]string, error) {
+	ddl, ok := dialect.(ddlDialect)
+	if !ok {
+		return nil, errors.New("dialect does not support generating tables")
+	}
+
+	names := s.tableNames()
+	statements := make([]string, 0, len(names))
+	for _, name := range names {
+		statement, err := createTable(ddl, s.ByName[name])
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// tableNames returns the names of all registered tables in order.
+func (s *Schema) tableNames() []string {
+	names := make([]string, 0, len(s.ByName))
+	for name := range s.ByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createTable builds a CREATE TABLE statement for table.
+func createTable(dialect ddlDialect, table *Table) (string, error) {
+	primaryKey := table.primaryKey()
+	autoIncrement := table.PrimaryKeyType == AutoIncrement && len(primaryKey) == 1
+
+	var buffer bytes.Buffer
+	buffer.WriteString("CREATE TABLE ")
+	buffer.WriteString(table.Name)
+	buffer.WriteString(" (\n")
+	for i, column := range table.Columns {
+		if i > 0 {
+			buffer.WriteString(",\n")
+		}
+		buffer.WriteString("  ")
+
+		if autoIncrement && column.Primary {
+			buffer.WriteString(dialect.autoIncrementColumn(column.Name))
+			continue
+		}
+
+		kind, nullable, err := columnKindOf(column)
+		if err != nil {
+			return "", err
+		}
+		buffer.WriteString(column.Name)
+		buffer.WriteString(" ")
+		buffer.WriteString(dialect.columnType(kind, column.Primary))
+		if !nullable {
+			buffer.WriteString(" NOT NULL")
+		}
+	}
+	if !autoIncrement && len(primaryKey) > 0 {
+		buffer.WriteString(",\n  PRIMARY KEY (")
+		buffer.WriteString(strings.Join(primaryKey, ", "))
+		buffer.WriteString(")")
+	}
+	buffer.WriteString("\n)")
+
+	return buffer.String(), nil
+}
+
+// columnKindOf returns the kind of values sqlgen writes for column, and
+// whether it writes NULLs.
+func columnKindOf(column *Column) (columnKind, bool, error) {
+	d := column.Descriptor
+
+	val := reflect.New(d.Type)
+	if !d.Ptr {
+		val = val.Elem()
+	}
+	value, err := d.Valuer(val).Value()
+	if err != nil {
+		return 0, false, err
+	}
+
+	nullable := d.Ptr || d.Tags.Contains("implicitnull") || value == nil
+	if d.Tags.Contains("json") {
+		// JSON is text, even though it is written as []byte.
+		return kindText, nullable, nil
+	}
+	if value == nil {
+		// The zero value is written as NULL, so fall back on the field's kind.
+		return reflectKind(d.Kind), nullable, nil
+	}
+	return driverValueKind(value), nullable, nil
+}
+
+func driverValueKind(value driver.Value) columnKind {
+	switch value.(type) {
+	case int64:
+		return kindInt
+	case float64:
+		return kindFloat
+	case bool:
+		return kindBool
+	case []byte:
+		return kindBytes
+	case time.Time:
+		return kindTime
+	default:
+		return kindString
+	}
+}
+
+func reflectKind(kind reflect.Kind) columnKind {
+	switch kind {
+	case reflect.Bool:
+		return kindBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return kindInt
+	case reflect.Float32, reflect.Float64:
+		return kindFloat
+	case reflect.Slice:
+		return kindBytes
+	default:
+		return kindString
+	}
+}
+
+// dataTypeKind classifies a column's data type as reported by the database,
+// for example "bigint", "character varying" or "VARCHAR(255)". It returns
+// false for unknown types.
+func dataTypeKind(dataType string) (columnKind, bool) {
+	dataType = strings.ToLower(dataType)
+	if i := strings.Index(dataType, "("); i >= 0 {
+		dataType = dataType[:i]
+	}
+	dataType = strings.TrimSpace(dataType)
+
+	switch {
+	case dataType == "boolean" || dataType == "bool":
+		return kindBool, true
+	case strings.Contains(dataType, "int") || dataType == "bit":
+		return kindInt, true
+	case dataType == "real" || dataType == "decimal" || dataType == "numeric" ||
+		strings.HasPrefix(dataType, "float") || strings.HasPrefix(dataType, "double"):
+		return kindFloat, true
+	case strings.HasPrefix(dataType, "timestamp") || dataType == "date" || dataType == "datetime":
+		return kindTime, true
+	case strings.Contains(dataType, "blob") || strings.Contains(dataType, "binary") || dataType == "bytea":
+		return kindBytes, true
+	case strings.Contains(dataType, "char") || strings.Contains(dataType, "text") || strings.Contains(dataType, "clob") ||
+		strings.HasPrefix(dataType, "json") || dataType == "enum" || dataType == "set" || dataType == "uuid":
+		return kindString, true
+	}
+	return 0, false
+}
+
+// kindsCompatible returns true if values of kind field can be written to and
+// scanned from a column of kind column.
+func kindsCompatible(field, column columnKind) bool {
+	switch field {
+	case kindInt, kindBool:
+		return column == kindInt || column == kindBool
+	case kindFloat:
+		return column == kindFloat || column == kindInt
+	case kindString, kindText, kindBytes:
+		return column == kindString || column == kindText || column == kindBytes
+	default:
+		return field == column
+	}
+}
+
+// A SchemaMismatch describes a difference between a registered table and the
+// database.
+type SchemaMismatch struct {
+	Table   string
+	Column  string
+	Problem string
+}
+
+func (m SchemaMismatch) String() string {
+	if m.Column == "" {
+		return fmt.Sprintf("%s: %s", m.Table, m.Problem)
+	}
+	return fmt.Sprintf("%s.%s: %s", m.Table, m.Column, m.Problem)
+}
+
+// SchemaError is returned by VerifySchema when registered tables do not
+// match the database.
+type SchemaError struct {
+	Mismatches []SchemaMismatch
+}
+
+func (e *SchemaError) Error() string {
+	problems := make([]string, 0, len(e.Mismatches))
+	for _, mismatch := range e.Mismatches {
+		problems = append(problems, mismatch.String())
+	}
+	return "sqlgen: schema does not match database: " + strings.Join(problems, "; ")
+}
+
+// VerifySchema compares the registered tables with the database, and returns a
+// *SchemaError listing missing tables and columns, incompatible column types,
+// columns that are NOT NULL but written as NULL (for example by implicitnull
+// fields), and missing primary keys. Call it at startup to catch mismatches
+// before they cause errors at runtime.
+func (db *DB) VerifySchema(ctx context.Context) error {
+	ddl, ok := db.dialect.(ddlDialect)
+	if !ok {
+		return errors.New("dialect does not support describing tables")
+	}
+
+	var mismatches []SchemaMismatch
+	for _, name := range db.Schema.tableNames() {
+		table := db.Schema.ByName[name]
+		columns, err := ddl.describeTable(ctx, db.QueryExecer(ctx), table.Name)
+		if err != nil {
+			return err
+		}
+		mismatches = append(mismatches, verifyTable(table, columns)...)
+	}
+
+	if len(mismatches) > 0 {
+		return &SchemaError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// verifyTable compares a registered table with the columns in the database.
+func verifyTable(table *Table, columns []dbColumn) []SchemaMismatch {
+	if len(columns) == 0 {
+		return []SchemaMismatch{{Table: table.Name, Problem: "table does not exist"}}
+	}
+
+	byName := make(map[string]dbColumn, len(columns))
+	for _, column := range columns {
+		byName[strings.ToLower(column.Name)] = column
+	}
+
+	var mismatches []SchemaMismatch
+	for _, column := range table.Columns {
+		dbColumn, ok := byName[strings.ToLower(column.Name)]
+		if !ok {
+			mismatches = append(mismatches, SchemaMismatch{Table: table.Name, Column: column.Name, Problem: "column does not exist"})
+			continue
+		}
+
+		if column.Primary && !dbColumn.Primary {
+			mismatches = append(mismatches, SchemaMismatch{Table: table.Name, Column: column.Name, Problem: "column is not part of the primary key"})
+		}
+
+		kind, nullable, err := columnKindOf(column)
+		if err != nil {
+			mismatches = append(mismatches, SchemaMismatch{Table: table.Name, Column: column.Name, Problem: err.Error()})
+			continue
+		}
+		if dbKind, ok := dataTypeKind(dbColumn.DataType); ok && !kindsCompatible(kind, dbKind) {
+			mismatches = append(mismatches, SchemaMismatch{
+				Table:   table.Name,
+				Column:  column.Name,
+				Problem: fmt.Sprintf("field of type %s cannot be stored in column of type %s", column.Descriptor.Type, dbColumn.DataType),
+			})
+		}
+		if nullable && !dbColumn.Nullable && !column.ReadOnly && !(column.Primary && table.PrimaryKeyType == AutoIncrement) {
+			mismatches = append(mismatches, SchemaMismatch{Table: table.Name, Column: column.Name, Problem: "field can be written as NULL but column is NOT NULL"})
+		}
+	}
+	return mismatches
+}
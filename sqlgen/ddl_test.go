@@ -0,0 +1,116 @@
+package sqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTableStatements(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("complex", AutoIncrement, Complex{})
+	schema.MustRegisterType("just_ids", UniqueId, JustId{})
+
+	statements, err := schema.CreateTableStatements(MySQL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE complex (\n" +
+			"  id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,\n" +
+			"  name VARCHAR(255) NOT NULL,\n" +
+			"  text BLOB,\n" +
+			"  blob BLOB,\n" +
+			"  mappings TEXT,\n" +
+			"  implicit_null VARCHAR(255)\n" +
+			")",
+		"CREATE TABLE just_ids (\n" +
+			"  id BIGINT NOT NULL,\n" +
+			"  PRIMARY KEY (id)\n" +
+			")",
+	}, statements)
+
+	statements, err = schema.CreateTableStatements(PostgreSQL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE complex (\n" +
+			"  id BIGSERIAL PRIMARY KEY,\n" +
+			"  name TEXT NOT NULL,\n" +
+			"  text BYTEA,\n" +
+			"  blob BYTEA,\n" +
+			"  mappings TEXT,\n" +
+			"  implicit_null TEXT\n" +
+			")",
+		"CREATE TABLE just_ids (\n" +
+			"  id BIGINT NOT NULL,\n" +
+			"  PRIMARY KEY (id)\n" +
+			")",
+	}, statements)
+}
+
+func TestVerifyTable(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("users", AutoIncrement, User{})
+	table := schema.ByName["users"]
+
+	assert.Equal(t, []SchemaMismatch{{Table: "users", Problem: "table does not exist"}}, verifyTable(table, nil))
+
+	assert.Empty(t, verifyTable(table, []dbColumn{
+		{Name: "id", DataType: "bigint", Primary: true},
+		{Name: "name", DataType: "varchar", Nullable: true},
+		{Name: "uuid", DataType: "varchar", Nullable: true},
+		{Name: "mood", DataType: "varchar", Nullable: true},
+		{Name: "proto", DataType: "blob", Nullable: true},
+		{Name: "simple_proto", DataType: "blob", Nullable: true},
+		{Name: "implicit_null", DataType: "varchar", Nullable: true},
+		{Name: "extra", DataType: "int"},
+	}))
+
+	assert.Equal(t, []SchemaMismatch{
+		{Table: "users", Column: "id", Problem: "column is not part of the primary key"},
+		{Table: "users", Column: "name", Problem: "field of type string cannot be stored in column of type datetime"},
+		{Table: "users", Column: "mood", Problem: "field can be written as NULL but column is NOT NULL"},
+		{Table: "users", Column: "simple_proto", Problem: "column does not exist"},
+		{Table: "users", Column: "implicit_null", Problem: "field can be written as NULL but column is NOT NULL"},
+	}, verifyTable(table, []dbColumn{
+		{Name: "id", DataType: "bigint"},
+		{Name: "name", DataType: "datetime"},
+		{Name: "uuid", DataType: "varbinary"},
+		{Name: "mood", DataType: "varchar"},
+		{Name: "proto", DataType: "mediumblob"},
+		{Name: "implicit_null", DataType: "varchar"},
+	}))
+}
+
+func TestSQLiteVerifySchema(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	require.NoError(t, db.VerifySchema(context.Background()))
+
+	testDb, err := testfixtures.NewSQLiteTestDatabase(`
+		CREATE TABLE users (
+			id INTEGER NOT NULL,
+			name TEXT,
+			uuid BLOB,
+			mood BLOB,
+			proto BLOB,
+			implicit_null TEXT NOT NULL
+		)
+	`)
+	require.NoError(t, err)
+	defer testDb.Close()
+
+	schema := NewSchema()
+	schema.MustRegisterType("users", AutoIncrement, User{})
+	schema.MustRegisterType("owls", UniqueId, Owl{})
+	err = NewDBWithDialect(testDb.DB, schema, SQLite).VerifySchema(context.Background())
+	require.IsType(t, &SchemaError{}, err)
+	assert.Equal(t, []SchemaMismatch{
+		{Table: "owls", Problem: "table does not exist"},
+		{Table: "users", Column: "id", Problem: "column is not part of the primary key"},
+		{Table: "users", Column: "simple_proto", Problem: "column does not exist"},
+		{Table: "users", Column: "implicit_null", Problem: "field can be written as NULL but column is NOT NULL"},
+	}, err.(*SchemaError).Mismatches)
+	assert.Contains(t, err.Error(), "users.simple_proto: column does not exist")
+}
@@ -0,0 +1,187 @@
+package sqlgen
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/samsarahq/go/oops"
+)
+
+// A Dialect adapts the statements built by sqlgen to a database. Queries are
+// built with MySQL syntax and ? placeholders (see SQLQuery), and a DB rewrites
+// them with its Dialect before running them.
+type Dialect interface {
+	// Rebind rewrites a statement built with ? placeholders, and its
+	// arguments, into the dialect's syntax.
+	Rebind(clause string, args []interface{}) (string, []interface{})
+
+	// UpsertClause returns the clause appended to an INSERT statement that
+	// updates existing rows that conflict on conflictColumns with set. If set
+	// is empty, conflicting rows are left unchanged.
+	UpsertClause(conflictColumns []string, set []UpsertSet) string
+
+	// ReturningClause returns the clause appended to an INSERT statement to
+	// return an auto-increment column, or "" if the dialect reports inserted
+	// ids with sql.Result.LastInsertId.
+	ReturningClause(column string) string
+
+	// CheckIndex explains the rebound statement clause, and returns false and
+	// a description of the plan if the statement does not use an index.
+	CheckIndex(ctx context.Context, q QueryExecer, clause string, args []interface{}) (string, bool, error)
+}
+
+// MySQL is the default Dialect of a DB.
+var MySQL Dialect = mysqlDialect{}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Rebind(clause string, args []interface{}) (string, []interface{}) {
+	return clause, args
+}
+
+func (mysqlDialect) UpsertClause(conflictColumns []string, set []UpsertSet) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(" ON DUPLICATE KEY UPDATE ")
+	if len(set) == 0 {
+		// Upserts that ignore conflicts use INSERT IGNORE instead, but
+		// assigning a column to itself also leaves the row unchanged.
+		buffer.WriteString(conflictColumns[0])
+		buffer.WriteString("=")
+		buffer.WriteString(conflictColumns[0])
+		return buffer.String()
+	}
+	for i, assignment := range set {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(assignment.Column)
+		buffer.WriteString("=")
+		if assignment.Expression != "" {
+			buffer.WriteString(assignment.Expression)
+		} else {
+			buffer.WriteString("VALUES(")
+			buffer.WriteString(assignment.Column)
+			buffer.WriteString(")")
+		}
+	}
+	return buffer.String()
+}
+
+func (mysqlDialect) insertIgnore() {}
+
+func (mysqlDialect) ReturningClause(column string) string {
+	return ""
+}
+
+func (mysqlDialect) CheckIndex(ctx context.Context, q QueryExecer, clause string, args []interface{}) (string, bool, error) {
+	res, err := q.QueryContext(ctx, "EXPLAIN "+clause, args...)
+	if err != nil {
+		return "", false, oops.Wrapf(err, "Failed to run explain on the query")
+	}
+	defer res.Close()
+
+	explainRes, err := parseExplainResults(res)
+	if err != nil {
+		return "", false, oops.Wrapf(err, "failed to parse explain results")
+	}
+
+	for _, explain := range explainRes {
+		// The query is ok if it has an index, a possible index, or is hitting const tables and
+		// finding no rows (this last case returns "Impossible WHERE...")
+		if explain.Key == nil && explain.PossibleKeys == nil && (explain.Extra == nil || !strings.HasPrefix(*explain.Extra, "Impossible WHERE")) {
+			explainJSON, _ := json.Marshal(explain)
+			return string(explainJSON), false, nil
+		}
+	}
+	return "", true, nil
+}
+
+// rebindPlaceholders replaces every ? placeholder outside of quoted strings in
+// clause with placeholder(n), where n counts placeholders from 1. If
+// nullLiterals is set, `IS ?` placeholders bound to nil are replaced with
+// `IS NULL` and their arguments dropped.
+func rebindPlaceholders(clause string, args []interface{}, nullLiterals bool, placeholder func(n int) string) (string, []interface{}) {
+	var buffer bytes.Buffer
+	rebound := make([]interface{}, 0, len(args))
+
+	arg := 0
+	var quote rune
+	for _, r := range clause {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '?' && arg < len(args):
+			value := args[arg]
+			arg++
+			if nullLiterals && value == nil && bytes.HasSuffix(buffer.Bytes(), []byte(" IS ")) {
+				buffer.WriteString("NULL")
+				continue
+			}
+			rebound = append(rebound, value)
+			buffer.WriteString(placeholder(len(rebound)))
+			continue
+		}
+		buffer.WriteRune(r)
+	}
+	return buffer.String(), append(rebound, args[arg:]...)
+}
+
+func (mysqlDialect) columnType(kind columnKind, primary bool) string {
+	switch kind {
+	case kindInt:
+		return "BIGINT"
+	case kindFloat:
+		return "DOUBLE"
+	case kindBool:
+		return "BOOLEAN"
+	case kindBytes:
+		if primary {
+			return "VARBINARY(255)"
+		}
+		return "BLOB"
+	case kindTime:
+		return "DATETIME(6)"
+	case kindText:
+		return "TEXT"
+	default:
+		return "VARCHAR(255)"
+	}
+}
+
+func (mysqlDialect) autoIncrementColumn(name string) string {
+	return name + " BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY"
+}
+
+func (mysqlDialect) describeTable(ctx context.Context, q QueryExecer, table string) ([]dbColumn, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT column_name, data_type, is_nullable = 'YES', column_key = 'PRI'
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ?
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	return scanDBColumns(rows)
+}
+
+// scanDBColumns scans rows of name, data type, nullable and primary columns.
+func scanDBColumns(rows *sql.Rows) ([]dbColumn, error) {
+	defer rows.Close()
+
+	var columns []dbColumn
+	for rows.Next() {
+		var column dbColumn
+		if err := rows.Scan(&column.Name, &column.DataType, &column.Nullable, &column.Primary); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,66 @@
+package sqlgen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresRebind(t *testing.T) {
+	clause, args := PostgreSQL.Rebind("a = ? AND b IS ? AND c IS ? AND d = '?' AND e IN (?, ?)", []interface{}{1, nil, 2, 3, 4})
+	assert.Equal(t, "a = $1 AND b IS NULL AND c IS $2 AND d = '?' AND e IN ($3, $4)", clause)
+	assert.Equal(t, []interface{}{1, 2, 3, 4}, args)
+
+	clause, args = MySQL.Rebind("a = ? AND b IS ?", []interface{}{1, nil})
+	assert.Equal(t, "a = ? AND b IS ?", clause)
+	assert.Equal(t, []interface{}{1, nil}, args)
+}
+
+func TestDialectQueries(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("users", UniqueId, user{}))
+	db := NewDBWithDialect(nil, s, PostgreSQL)
+	assert.Equal(t, PostgreSQL, db.Dialect())
+	assert.Equal(t, MySQL, NewDB(nil, s).Dialect())
+
+	upsert, err := s.MakeUpsertRow(&user{Id: 1, Name: "bob"})
+	require.NoError(t, err)
+	clause, args := db.toSQL(upsert)
+	assert.Equal(t, "INSERT INTO users (id, name, age, optional, uuid) VALUES ($1, $2, $3, $4, $5) "+
+		"ON CONFLICT (id) DO UPDATE SET id = EXCLUDED.id, name = EXCLUDED.name, age = EXCLUDED.age, optional = EXCLUDED.optional, uuid = EXCLUDED.uuid", clause)
+	assert.Len(t, args, 5)
+
+	batchUpsert, err := s.MakeBatchUpsertRow([]interface{}{&user{Id: 1}, &user{Id: 2}})
+	require.NoError(t, err)
+	clause, _ = db.toSQL(batchUpsert)
+	assert.Equal(t, "INSERT INTO users (id, name, age, optional, uuid) VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10) "+
+		"ON CONFLICT (id) DO UPDATE SET id = EXCLUDED.id, name = EXCLUDED.name, age = EXCLUDED.age, optional = EXCLUDED.optional, uuid = EXCLUDED.uuid", clause)
+
+	var users []*user
+	query, err := s.MakeSelect(&users, Filter{"name": "bob", "optional": nil, "age": Gt(10)}, &SelectOptions{Limit: 5})
+	require.NoError(t, err)
+	selectQuery, err := query.MakeSelectQuery()
+	require.NoError(t, err)
+	clause, args = db.toSQL(selectQuery)
+	assert.Equal(t, "SELECT id, name, age, optional, uuid FROM users WHERE name = $1 AND age > $2 AND optional IS NULL LIMIT 5", clause)
+	assert.Equal(t, []interface{}{"bob", int64(10)}, args)
+}
+
+func TestParsePostgresExplain(t *testing.T) {
+	scan, err := parsePostgresExplain([]byte(`[{"Plan": {"Node Type": "Limit", "Plans": [
+		{"Node Type": "Index Scan", "Relation Name": "users", "Index Name": "users_pkey"}
+	]}}]`))
+	require.NoError(t, err)
+	assert.Nil(t, scan)
+
+	scan, err = parsePostgresExplain([]byte(`[{"Plan": {"Node Type": "Limit", "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "users", "Filter": "(name = 'bob'::text)"}
+	]}}]`))
+	require.NoError(t, err)
+	require.NotNil(t, scan)
+	assert.Equal(t, "users", scan.RelationName)
+
+	_, err = parsePostgresExplain([]byte(`not json`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,481 @@
+package sqlgen
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type conditionOp int
+
+const (
+	opEq conditionOp = iota
+	opIn
+	opGt
+	opGte
+	opLt
+	opLte
+	opBetween
+	opLike
+	opIsNull
+	opNot
+	opOr
+)
+
+// A Condition is a Filter value that matches a column with an operator other
+// than equality, for example
+//
+//   Filter{"age": sqlgen.Gte(18), "status": sqlgen.In("active", "trial")}
+//
+// An Or condition matches rows that match any of its filters. It is not tied
+// to a column, and is stored under a key that is not a column name:
+//
+//   Filter{"$or": sqlgen.Or(Filter{"owner_id": 10}, Filter{"public": true})}
+//
+// The same holds for a negated Or, such as sqlgen.Not(sqlgen.Or(...)).
+//
+// Conditions are supported by Query, QueryRow, Count and MakeTester. Queries
+// with conditions are not batched.
+type Condition struct {
+	op      conditionOp
+	values  []interface{}
+	inner   *Condition
+	filters []Filter
+
+	// wheres holds the bound filters of an Or condition, and like and
+	// likeFold the case-sensitive and case-insensitive compiled patterns of a
+	// bound Like condition.
+	wheres   []*SimpleWhere
+	like     *regexp.Regexp
+	likeFold *regexp.Regexp
+}
+
+// In matches rows where the column equals any of values.
+func In(values ...interface{}) *Condition {
+	return &Condition{op: opIn, values: values}
+}
+
+// Gt matches rows where the column is greater than value.
+func Gt(value interface{}) *Condition {
+	return &Condition{op: opGt, values: []interface{}{value}}
+}
+
+// Gte matches rows where the column is greater than or equal to value.
+func Gte(value interface{}) *Condition {
+	return &Condition{op: opGte, values: []interface{}{value}}
+}
+
+// Lt matches rows where the column is less than value.
+func Lt(value interface{}) *Condition {
+	return &Condition{op: opLt, values: []interface{}{value}}
+}
+
+// Lte matches rows where the column is less than or equal to value.
+func Lte(value interface{}) *Condition {
+	return &Condition{op: opLte, values: []interface{}{value}}
+}
+
+// Between matches rows where the column is between low and high, inclusive.
+func Between(low, high interface{}) *Condition {
+	return &Condition{op: opBetween, values: []interface{}{low, high}}
+}
+
+// Like matches rows where the column matches a SQL LIKE pattern.
+func Like(pattern string) *Condition {
+	return &Condition{op: opLike, values: []interface{}{pattern}}
+}
+
+// IsNull matches rows where the column is NULL.
+func IsNull() *Condition {
+	return &Condition{op: opIsNull}
+}
+
+// Not matches rows that do not match value, which is either a Condition or a
+// value compared for equality. Not(nil) matches rows where the column is not
+// NULL.
+func Not(value interface{}) *Condition {
+	inner, ok := value.(*Condition)
+	if !ok {
+		inner = &Condition{op: opEq, values: []interface{}{value}}
+	}
+	return &Condition{op: opNot, inner: inner}
+}
+
+// Or matches rows that match any of filters.
+func Or(filters ...Filter) *Condition {
+	return &Condition{op: opOr, filters: filters}
+}
+
+// or returns the Or condition that c is or negates, if any. Such conditions
+// are not tied to a column.
+func (c *Condition) or() *Condition {
+	for c != nil && c.op == opNot {
+		c = c.inner
+	}
+	if c != nil && c.op == opOr {
+		return c
+	}
+	return nil
+}
+
+// isSimpleFilter returns true if filter only compares columns for equality
+// with non-NULL values.
+func isSimpleFilter(filter Filter) bool {
+	for _, value := range filter {
+		if _, ok := value.(*Condition); ok || value == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// bind converts the condition's operands to driver values for column, so the
+// condition can be rendered as SQL and compared against rows.
+func (c *Condition) bind(table *Table, column *Column) (*Condition, error) {
+	switch c.op {
+	case opOr:
+		wheres := make([]*SimpleWhere, 0, len(c.filters))
+		for _, filter := range c.filters {
+			where, err := makeWhere(table, filter)
+			if err != nil {
+				return nil, err
+			}
+			wheres = append(wheres, where)
+		}
+		return &Condition{op: opOr, filters: c.filters, wheres: wheres}, nil
+
+	case opNot:
+		inner, err := c.inner.bind(table, column)
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{op: opNot, inner: inner}, nil
+
+	case opLike:
+		pattern := c.values[0].(string)
+		return &Condition{op: opLike, values: c.values, like: likePattern(pattern, false), likeFold: likePattern(pattern, true)}, nil
+	}
+
+	values := make([]interface{}, 0, len(c.values))
+	for _, value := range c.values {
+		v, err := column.Descriptor.Valuer(reflect.ValueOf(value)).Value()
+		if err != nil {
+			return nil, fmt.Errorf("sqlgen: filter error for `%s`.`%s`: %v", table.Name, column.Name, err)
+		}
+		values = append(values, v)
+	}
+	return &Condition{op: c.op, values: values}, nil
+}
+
+// writeSQL writes the SQL for a bound condition on column to buffer and
+// returns its arguments.
+func (c *Condition) writeSQL(buffer *bytes.Buffer, column string) []interface{} {
+	switch c.op {
+	case opEq:
+		buffer.WriteString(column)
+		if c.values[0] == nil {
+			buffer.WriteString(" IS NULL")
+			return nil
+		}
+		buffer.WriteString(" = ?")
+
+	case opIn:
+		if len(c.values) == 0 {
+			buffer.WriteString("1 = 0")
+			return nil
+		}
+		buffer.WriteString(column)
+		buffer.WriteString(" IN (")
+		for i := range c.values {
+			if i > 0 {
+				buffer.WriteString(", ")
+			}
+			buffer.WriteString("?")
+		}
+		buffer.WriteString(")")
+
+	case opGt, opGte, opLt, opLte:
+		buffer.WriteString(column)
+		buffer.WriteString(map[conditionOp]string{opGt: " > ?", opGte: " >= ?", opLt: " < ?", opLte: " <= ?"}[c.op])
+
+	case opBetween:
+		buffer.WriteString(column)
+		buffer.WriteString(" BETWEEN ? AND ?")
+
+	case opLike:
+		buffer.WriteString(column)
+		buffer.WriteString(" LIKE ?")
+
+	case opIsNull:
+		buffer.WriteString(column)
+		buffer.WriteString(" IS NULL")
+
+	case opNot:
+		switch inner := c.inner; {
+		case inner.op == opIsNull || (inner.op == opEq && inner.values[0] == nil):
+			buffer.WriteString(column)
+			buffer.WriteString(" IS NOT NULL")
+			return nil
+		case inner.op == opEq:
+			buffer.WriteString(column)
+			buffer.WriteString(" != ?")
+			return inner.values
+		case inner.op == opIn && len(inner.values) > 0:
+			buffer.WriteString(column)
+			buffer.WriteString(" NOT IN (")
+			for i := range inner.values {
+				if i > 0 {
+					buffer.WriteString(", ")
+				}
+				buffer.WriteString("?")
+			}
+			buffer.WriteString(")")
+			return inner.values
+		}
+		buffer.WriteString("NOT (")
+		values := c.inner.writeSQL(buffer, column)
+		buffer.WriteString(")")
+		return values
+
+	case opOr:
+		if len(c.wheres) == 0 {
+			buffer.WriteString("1 = 0")
+			return nil
+		}
+		var values []interface{}
+		buffer.WriteString("(")
+		for i, where := range c.wheres {
+			if i > 0 {
+				buffer.WriteString(" OR ")
+			}
+			clause, whereValues := where.ToSQL()
+			if clause == "" {
+				// An empty filter matches every row.
+				clause = "1 = 1"
+			}
+			buffer.WriteString("(")
+			buffer.WriteString(clause)
+			buffer.WriteString(")")
+			values = append(values, whereValues...)
+		}
+		buffer.WriteString(")")
+		return values
+	}
+
+	return c.values
+}
+
+// testStrict tests a bound condition against a column's driver value. Tests
+// err on the side of matching, so that testers never miss a changed row: since
+// the column's collation is unknown, strings are ordered and LIKE patterns
+// matched both byte-wise and case-insensitively, and the condition matches if
+// it does under either collation. If strict is set, it only matches if it does
+// under both. Not tests its inner condition the other way, so it matches where
+// the inner condition fails under either collation, and NULL values that SQL
+// would leave unmatched. Equality is still tested byte-wise.
+func (c *Condition) testStrict(value driver.Value, row interface{}, testers []*tester, strict bool) bool {
+	switch c.op {
+	case opNot:
+		return !c.inner.testStrict(value, row, testers, !strict)
+
+	case opOr:
+		for _, t := range testers {
+			if t.test(row, strict) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if strict {
+		return c.testCollation(value, false) && c.testCollation(value, true)
+	}
+	return c.testCollation(value, false) || c.testCollation(value, true)
+}
+
+// testCollation tests a bound condition on a single column with strings
+// ordered and LIKE patterns matched byte-wise, or case-insensitively if fold is
+// set.
+func (c *Condition) testCollation(value driver.Value, fold bool) bool {
+	switch c.op {
+	case opEq:
+		return driverValuesEqual(c.values[0], value)
+
+	case opIn:
+		for _, v := range c.values {
+			if driverValuesEqual(v, value) {
+				return true
+			}
+		}
+		return false
+
+	case opGt:
+		return testOrder(value, c.values[0], fold, func(cmp int) bool { return cmp > 0 })
+	case opGte:
+		return testOrder(value, c.values[0], fold, func(cmp int) bool { return cmp >= 0 })
+	case opLt:
+		return testOrder(value, c.values[0], fold, func(cmp int) bool { return cmp < 0 })
+	case opLte:
+		return testOrder(value, c.values[0], fold, func(cmp int) bool { return cmp <= 0 })
+
+	case opBetween:
+		return testOrder(value, c.values[0], fold, func(cmp int) bool { return cmp >= 0 }) &&
+			testOrder(value, c.values[1], fold, func(cmp int) bool { return cmp <= 0 })
+
+	case opLike:
+		var s string
+		switch value := value.(type) {
+		case string:
+			s = value
+		case []byte:
+			s = string(value)
+		default:
+			return false
+		}
+		if fold {
+			return c.likeFold.MatchString(s)
+		}
+		return c.like.MatchString(s)
+
+	case opIsNull:
+		return value == nil
+	}
+
+	return false
+}
+
+// likePattern converts a SQL LIKE pattern into an equivalent regular
+// expression, which ignores case if fold is set.
+func likePattern(pattern string, fold bool) *regexp.Regexp {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?is)^")
+	} else {
+		b.WriteString("(?s)^")
+	}
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// testOrder returns true if value compares to bound as match requires. If fold
+// is set, strings are compared case-insensitively.
+func testOrder(value, bound driver.Value, fold bool, match func(cmp int) bool) bool {
+	if fold {
+		s1, ok1 := driverString(value)
+		s2, ok2 := driverString(bound)
+		if ok1 && ok2 {
+			return match(strings.Compare(strings.ToLower(s1), strings.ToLower(s2)))
+		}
+	}
+	cmp, ok := compareDriverValues(value, bound)
+	if !ok {
+		return false
+	}
+	return match(cmp)
+}
+
+func driverString(v driver.Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// compareDriverValues orders two driver.Values of compatible types. It returns
+// false if the values cannot be compared, for example because either is NULL.
+func compareDriverValues(dv1, dv2 driver.Value) (int, bool) {
+	switch v1 := dv1.(type) {
+	case int64:
+		switch v2 := dv2.(type) {
+		case int64:
+			switch {
+			case v1 < v2:
+				return -1, true
+			case v1 > v2:
+				return 1, true
+			default:
+				return 0, true
+			}
+		case float64:
+			return compareFloats(float64(v1), v2), true
+		}
+	case float64:
+		switch v2 := dv2.(type) {
+		case int64:
+			return compareFloats(v1, float64(v2)), true
+		case float64:
+			return compareFloats(v1, v2), true
+		}
+	case string:
+		switch v2 := dv2.(type) {
+		case string:
+			return strings.Compare(v1, v2), true
+		case []byte:
+			return strings.Compare(v1, string(v2)), true
+		}
+	case []byte:
+		switch v2 := dv2.(type) {
+		case []byte:
+			return bytes.Compare(v1, v2), true
+		case string:
+			return strings.Compare(string(v1), v2), true
+		}
+	case time.Time:
+		if v2, ok := dv2.(time.Time); ok {
+			switch {
+			case v1.Before(v2):
+				return -1, true
+			case v1.After(v2):
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case bool:
+		if v2, ok := dv2.(bool); ok {
+			switch {
+			case v1 == v2:
+				return 0, true
+			case v2:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func compareFloats(f1, f2 float64) int {
+	switch {
+	case f1 < f2:
+		return -1
+	case f1 > f2:
+		return 1
+	default:
+		return 0
+	}
+}
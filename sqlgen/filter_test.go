@@ -0,0 +1,196 @@
+package sqlgen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionWhere(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("users", AutoIncrement, user{}))
+	table := s.ByName["users"]
+
+	cases := []struct {
+		Description string
+		Filter      Filter
+		Clause      string
+		Args        []interface{}
+	}{
+		{
+			Description: "in",
+			Filter:      Filter{"id": In(1, 2, int32(3))},
+			Clause:      "id IN (?, ?, ?)",
+			Args:        []interface{}{int64(1), int64(2), int64(3)},
+		},
+		{
+			Description: "empty in",
+			Filter:      Filter{"id": In()},
+			Clause:      "1 = 0",
+			Args:        []interface{}{},
+		},
+		{
+			Description: "range",
+			Filter:      Filter{"id": Gt(1), "age": Lte(30)},
+			Clause:      "id > ? AND age <= ?",
+			Args:        []interface{}{int64(1), int64(30)},
+		},
+		{
+			Description: "between and equality",
+			Filter:      Filter{"name": "bob", "age": Between(18, 65)},
+			Clause:      "name = ? AND age BETWEEN ? AND ?",
+			Args:        []interface{}{"bob", int64(18), int64(65)},
+		},
+		{
+			Description: "like",
+			Filter:      Filter{"name": Like("b%")},
+			Clause:      "name LIKE ?",
+			Args:        []interface{}{"b%"},
+		},
+		{
+			Description: "null",
+			Filter:      Filter{"optional": IsNull(), "age": Not(nil)},
+			Clause:      "age IS NOT NULL AND optional IS NULL",
+			Args:        []interface{}{},
+		},
+		{
+			Description: "not",
+			Filter:      Filter{"name": Not("bob"), "id": Not(In(1, 2))},
+			Clause:      "id NOT IN (?, ?) AND name != ?",
+			Args:        []interface{}{int64(1), int64(2), "bob"},
+		},
+		{
+			Description: "or",
+			Filter: Filter{
+				"age": Gte(18),
+				"$or": Or(Filter{"name": "bob"}, Filter{"id": 1, "optional": nil}),
+			},
+			Clause: "age >= ? AND ((name = ?) OR (id = ? AND optional IS ?))",
+			Args:   []interface{}{int64(18), "bob", int64(1), nil},
+		},
+		{
+			Description: "not or",
+			Filter:      Filter{"$not": Not(Or(Filter{"name": "bob"}, Filter{"id": 1}))},
+			Clause:      "NOT (((name = ?) OR (id = ?)))",
+			Args:        []interface{}{"bob", int64(1)},
+		},
+		{
+			Description: "empty or",
+			Filter:      Filter{"$or": Or()},
+			Clause:      "1 = 0",
+			Args:        []interface{}{},
+		},
+	}
+
+	for _, c := range cases {
+		where, err := makeWhere(table, c.Filter)
+		require.NoError(t, err, c.Description)
+		clause, args := where.ToSQL()
+		assert.Equal(t, c.Clause, clause, c.Description)
+		assert.Equal(t, c.Args, args, c.Description)
+	}
+
+	_, err := makeWhere(table, Filter{"foo": Gt(1)})
+	assert.Error(t, err)
+	_, err = makeWhere(table, Filter{"$or": Or(Filter{"foo": 1})})
+	assert.Error(t, err)
+}
+
+func TestConditionTester(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("users", AutoIncrement, user{}))
+
+	foo := "foo"
+
+	cases := []struct {
+		Description string
+		Filter      Filter
+		User        *user
+		Expected    bool
+	}{
+		{Description: "in match", Filter: Filter{"id": In(1, 2)}, User: &user{Id: 2}, Expected: true},
+		{Description: "in fail", Filter: Filter{"id": In(1, 2)}, User: &user{Id: 3}, Expected: false},
+		{Description: "gt match", Filter: Filter{"age": Gt(18)}, User: &user{Age: 19}, Expected: true},
+		{Description: "gt fail", Filter: Filter{"age": Gt(18)}, User: &user{Age: 18}, Expected: false},
+		{Description: "gte match", Filter: Filter{"age": Gte(18)}, User: &user{Age: 18}, Expected: true},
+		{Description: "lt fail", Filter: Filter{"age": Lt(18)}, User: &user{Age: 18}, Expected: false},
+		{Description: "between match", Filter: Filter{"age": Between(18, 65)}, User: &user{Age: 65}, Expected: true},
+		{Description: "between fail", Filter: Filter{"age": Between(18, 65)}, User: &user{Age: 66}, Expected: false},
+		{Description: "string range", Filter: Filter{"name": Lt("bob")}, User: &user{Name: "alice"}, Expected: true},
+		{Description: "string range ignores case", Filter: Filter{"name": Gt("alice")}, User: &user{Name: "Bob"}, Expected: true},
+		{Description: "string range fail", Filter: Filter{"name": Lt("alice")}, User: &user{Name: "bob"}, Expected: false},
+		{Description: "large int gt", Filter: Filter{"id": Gt(int64(1) << 53)}, User: &user{Id: 1<<53 + 1}, Expected: true},
+		{Description: "large int between", Filter: Filter{"id": Between(1<<53+1, 1<<53+2)}, User: &user{Id: 1 << 53}, Expected: false},
+		{Description: "null never compares", Filter: Filter{"optional": Gt("a")}, User: &user{}, Expected: false},
+		{Description: "like match", Filter: Filter{"name": Like("B_b%")}, User: &user{Name: "bobby"}, Expected: true},
+		{Description: "like fail", Filter: Filter{"name": Like("b_b")}, User: &user{Name: "bobby"}, Expected: false},
+		{Description: "like escape", Filter: Filter{"name": Like(`100\%`)}, User: &user{Name: "1000"}, Expected: false},
+		{Description: "not range matches either collation", Filter: Filter{"name": Not(Gte("b"))}, User: &user{Name: "B"}, Expected: true},
+		{Description: "not range fail", Filter: Filter{"name": Not(Gte("b"))}, User: &user{Name: "c"}, Expected: false},
+		{Description: "not between matches either collation", Filter: Filter{"name": Not(Between("b", "c"))}, User: &user{Name: "Bob"}, Expected: true},
+		{Description: "not like matches either collation", Filter: Filter{"name": Not(Like("b%"))}, User: &user{Name: "Bob"}, Expected: true},
+		{Description: "not like fail", Filter: Filter{"name": Not(Like("b%"))}, User: &user{Name: "bob"}, Expected: false},
+		{Description: "is null match", Filter: Filter{"optional": IsNull()}, User: &user{}, Expected: true},
+		{Description: "is null fail", Filter: Filter{"optional": IsNull()}, User: &user{Optional: &foo}, Expected: false},
+		{Description: "not match", Filter: Filter{"optional": Not(nil)}, User: &user{Optional: &foo}, Expected: true},
+		{Description: "not fail", Filter: Filter{"id": Not(In(1, 2))}, User: &user{Id: 1}, Expected: false},
+		{
+			Description: "or match",
+			Filter:      Filter{"age": Gte(18), "$or": Or(Filter{"name": "bob"}, Filter{"id": 1})},
+			User:        &user{Id: 1, Name: "alice", Age: 20},
+			Expected:    true,
+		},
+		{
+			Description: "or fail",
+			Filter:      Filter{"age": Gte(18), "$or": Or(Filter{"name": "bob"}, Filter{"id": 1})},
+			User:        &user{Id: 2, Name: "alice", Age: 20},
+			Expected:    false,
+		},
+		{
+			Description: "not or match",
+			Filter:      Filter{"$not": Not(Or(Filter{"name": "bob"}, Filter{"id": 1}))},
+			User:        &user{Id: 2, Name: "alice"},
+			Expected:    true,
+		},
+		{
+			Description: "not or fail",
+			Filter:      Filter{"$not": Not(Or(Filter{"name": "bob"}, Filter{"id": 1}))},
+			User:        &user{Id: 1, Name: "alice"},
+			Expected:    false,
+		},
+		{
+			Description: "not or matches either collation",
+			Filter:      Filter{"$not": Not(Or(Filter{"name": Like("b%")}, Filter{"id": 1}))},
+			User:        &user{Id: 2, Name: "Bob"},
+			Expected:    true,
+		},
+		{
+			Description: "not or range matches either collation",
+			Filter:      Filter{"$not": Not(Or(Filter{"name": Gte("b")}, Filter{"id": 1}))},
+			User:        &user{Id: 2, Name: "Bob"},
+			Expected:    true,
+		},
+		{
+			Description: "not or fails under both collations",
+			Filter:      Filter{"$not": Not(Or(Filter{"name": Like("b%")}, Filter{"id": 1}))},
+			User:        &user{Id: 2, Name: "bob"},
+			Expected:    false,
+		},
+		{
+			Description: "not not or matches either collation",
+			Filter:      Filter{"$not": Not(Not(Or(Filter{"name": Like("b%")}, Filter{"id": 1})))},
+			User:        &user{Id: 2, Name: "Bob"},
+			Expected:    true,
+		},
+	}
+
+	for _, c := range cases {
+		tester, err := s.MakeTester("users", c.Filter)
+		require.NoError(t, err, c.Description)
+		assert.Equal(t, c.Expected, tester.Test(c.User), c.Description)
+	}
+
+	_, err := s.MakeTester("users", Filter{"$or": Or(Filter{"foo": 1})})
+	assert.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package sqlgen
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samson-crypto/thunder/logger"
+)
+
+// A QueryEvent describes a statement run by a DB.
+type QueryEvent struct {
+	// Operation is the DB method that ran the statement, such as "Query" or
+	// "InsertRow".
+	Operation string
+	Table     string
+	Clause    string
+	Args      []interface{}
+	// Batched is true if the statement combined several queries through
+	// batching.
+	Batched bool
+
+	// Rows is the number of rows returned by a query or affected by a write,
+	// or -1 if unknown. Rows, Duration and Err are set after the statement
+	// runs.
+	Rows     int64
+	Duration time.Duration
+	Err      error
+}
+
+// A QueryHook is called before and after every statement a DB runs.
+type QueryHook interface {
+	// BeforeQuery is called before a statement runs, and returns the context
+	// to run the statement and call AfterQuery with.
+	BeforeQuery(ctx context.Context, event *QueryEvent) context.Context
+	// AfterQuery is called after a statement runs. For Iterate, it is called
+	// after all rows have been passed to the callback.
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+// WithQueryHook returns a copy of the DB that calls hook before and after
+// every statement. Hooks added later run before earlier hooks, and after them
+// once the statement has run.
+func (db *DB) WithQueryHook(hook QueryHook) (*DB, error) {
+	if hook == nil {
+		return nil, errors.New("hook must not be nil")
+	}
+
+	dbCopy := db.clone()
+	dbCopy.hooks = append(append([]QueryHook{}, db.hooks...), hook)
+	return dbCopy, nil
+}
+
+// instrument runs a statement with run between the DB's hooks. run returns
+// the number of rows the statement returned or affected.
+func (db *DB) instrument(ctx context.Context, event *QueryEvent, run func(ctx context.Context) (int64, error)) error {
+	if len(db.hooks) == 0 {
+		_, err := run(ctx)
+		return err
+	}
+
+	for i := len(db.hooks) - 1; i >= 0; i-- {
+		ctx = db.hooks[i].BeforeQuery(ctx, event)
+	}
+	start := time.Now()
+	event.Rows, event.Err = run(ctx)
+	event.Duration = time.Since(start)
+	for _, hook := range db.hooks {
+		hook.AfterQuery(ctx, event)
+	}
+	return event.Err
+}
+
+// queryTable returns the table a query reads or writes.
+func queryTable(query SQLQuery) string {
+	switch q := query.(type) {
+	case *SelectQuery:
+		return q.Table
+	case *countQuery:
+		return q.Table
+	case *AggregateQuery:
+		return q.Table
+	case *InsertQuery:
+		return q.Table
+	case *BatchInsertQuery:
+		return q.Table
+	case *UpsertQuery:
+		return q.Table
+	case *BatchUpsertQuery:
+		return q.Table
+	case *UpdateQuery:
+		return q.Table
+	case *DeleteQuery:
+		return q.Table
+	default:
+		return ""
+	}
+}
+
+// slowQueryLogger is a QueryHook that logs slow statements.
+type slowQueryLogger struct {
+	logger    logger.Logger
+	threshold time.Duration
+}
+
+// SlowQueryLogger returns a QueryHook that logs a warning for every statement
+// that takes at least threshold. Arguments are not logged, as they may hold
+// sensitive data.
+func SlowQueryLogger(l logger.Logger, threshold time.Duration) QueryHook {
+	return &slowQueryLogger{logger: l, threshold: threshold}
+}
+
+func (l *slowQueryLogger) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (l *slowQueryLogger) AfterQuery(ctx context.Context, event *QueryEvent) {
+	if event.Duration < l.threshold {
+		return
+	}
+	l.logger.Warn("sqlgen: slow query",
+		"operation", event.Operation,
+		"table", event.Table,
+		"duration", event.Duration,
+		"rows", event.Rows,
+		"batched", event.Batched,
+		"clause", event.Clause,
+	)
+}
+
+// TableStats are the latency metrics of statements on a table.
+type TableStats struct {
+	Table   string
+	Queries int64
+	Errors  int64
+	Rows    int64
+
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+}
+
+// MeanDuration returns the mean duration of the statements.
+func (s TableStats) MeanDuration() time.Duration {
+	if s.Queries == 0 {
+		return 0
+	}
+	return s.TotalDuration / time.Duration(s.Queries)
+}
+
+// TableMetrics is a QueryHook that collects latency metrics per table.
+type TableMetrics struct {
+	mu     sync.Mutex
+	tables map[string]*TableStats
+}
+
+// NewTableMetrics creates an empty TableMetrics.
+func NewTableMetrics() *TableMetrics {
+	return &TableMetrics{tables: make(map[string]*TableStats)}
+}
+
+func (m *TableMetrics) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (m *TableMetrics) AfterQuery(ctx context.Context, event *QueryEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.tables[event.Table]
+	if !ok {
+		stats = &TableStats{Table: event.Table}
+		m.tables[event.Table] = stats
+	}
+	stats.Queries++
+	if event.Err != nil {
+		stats.Errors++
+	}
+	if event.Rows > 0 {
+		stats.Rows += event.Rows
+	}
+	stats.TotalDuration += event.Duration
+	if event.Duration > stats.MaxDuration {
+		stats.MaxDuration = event.Duration
+	}
+}
+
+// Stats returns the metrics collected so far, ordered by table.
+func (m *TableMetrics) Stats() []TableStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]TableStats, 0, len(m.tables))
+	for _, s := range m.tables {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Table < stats[j].Table })
+	return stats
+}
+
+// Reset clears the metrics collected so far.
+func (m *TableMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tables = make(map[string]*TableStats)
+}
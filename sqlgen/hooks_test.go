@@ -0,0 +1,147 @@
+package sqlgen
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHook struct {
+	mu     sync.Mutex
+	before []QueryEvent
+	after  []QueryEvent
+}
+
+type hookKey struct{}
+
+func (h *recordingHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.before = append(h.before, *event)
+	return context.WithValue(ctx, hookKey{}, event.Operation)
+}
+
+func (h *recordingHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ctx.Value(hookKey{}) != event.Operation {
+		panic("missing context from BeforeQuery")
+	}
+	h.after = append(h.after, *event)
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debug(msg string, tags ...interface{}) {}
+func (l *recordingLogger) Info(msg string, tags ...interface{})  {}
+func (l *recordingLogger) Warn(msg string, tags ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(append([]interface{}{msg}, tags...)...))
+}
+func (l *recordingLogger) Error(msg string, tags ...interface{}) {}
+
+func TestSQLiteQueryHooks(t *testing.T) {
+	testDb, plainDb := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	hook := &recordingHook{}
+	db, err := plainDb.WithQueryHook(hook)
+	require.NoError(t, err)
+	_, err = plainDb.WithQueryHook(nil)
+	assert.Error(t, err)
+
+	_, err = db.InsertRow(ctx, &User{Name: "Bob"})
+	require.NoError(t, err)
+	require.NoError(t, db.InsertRows(ctx, []*User{{Name: "Alice"}, {Name: "Carol"}}, 10))
+	var users []*User
+	require.NoError(t, db.Query(ctx, &users, Filter{"name": Not("Bob")}, nil))
+	_, err = db.Count(ctx, &User{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateRow(ctx, &User{Id: 1, Name: "Dave"}))
+	require.NoError(t, db.Iterate(ctx, &User{}, nil, nil, func(row interface{}) error { return nil }))
+	assert.Error(t, db.Query(ctx, &users, nil, &SelectOptions{Where: "missing = 1"}))
+
+	var operations []string
+	for _, event := range hook.after {
+		operations = append(operations, event.Operation)
+		assert.Equal(t, "users", event.Table)
+		assert.False(t, event.Batched)
+	}
+	assert.Equal(t, []string{"InsertRow", "InsertRows", "Query", "Count", "UpdateRow", "Iterate", "Query"}, operations)
+	assert.Len(t, hook.before, len(hook.after))
+	assert.Equal(t, int64(2), hook.after[1].Rows)
+	assert.Equal(t, int64(2), hook.after[2].Rows)
+	assert.Equal(t, "SELECT id, name, uuid, mood, proto, simple_proto, implicit_null FROM users WHERE name != ?", hook.after[2].Clause)
+	assert.Equal(t, []interface{}{"Bob"}, hook.after[2].Args)
+	assert.Equal(t, int64(3), hook.after[5].Rows)
+	assert.Error(t, hook.after[6].Err)
+
+	// Queries without hooks are not recorded.
+	require.NoError(t, plainDb.Query(ctx, &users, nil, nil))
+	assert.Len(t, hook.after, 7)
+
+	// Batched queries run through the hooks once.
+	hook.after = nil
+	batchCtx := batch.WithBatching(ctx)
+	var wg sync.WaitGroup
+	for _, name := range []string{"Dave", "Alice"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			var users []*User
+			assert.NoError(t, db.Query(batchCtx, &users, Filter{"name": name}, nil))
+			assert.Len(t, users, 1)
+		}(name)
+	}
+	wg.Wait()
+	require.NotEmpty(t, hook.after)
+	for _, event := range hook.after {
+		assert.True(t, event.Batched)
+	}
+}
+
+func TestSQLiteSlowQueryLoggerAndMetrics(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	logger := &recordingLogger{}
+	metrics := NewTableMetrics()
+	db, err := db.WithQueryHook(SlowQueryLogger(logger, time.Hour))
+	require.NoError(t, err)
+	db, err = db.WithQueryHook(metrics)
+	require.NoError(t, err)
+
+	_, err = db.InsertRow(ctx, &User{Name: "Bob"})
+	require.NoError(t, err)
+	var owls []*Owl
+	require.NoError(t, db.Query(ctx, &owls, nil, nil))
+	require.NoError(t, db.Query(ctx, &owls, nil, nil))
+	assert.Empty(t, logger.messages)
+
+	stats := metrics.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "owls", stats[0].Table)
+	assert.Equal(t, int64(2), stats[0].Queries)
+	assert.Equal(t, "users", stats[1].Table)
+	assert.Equal(t, int64(1), stats[1].Rows)
+	assert.True(t, stats[1].MaxDuration > 0)
+	assert.Equal(t, stats[1].TotalDuration, stats[1].MeanDuration())
+
+	metrics.Reset()
+	assert.Empty(t, metrics.Stats())
+
+	slow := SlowQueryLogger(logger, time.Millisecond)
+	slow.AfterQuery(ctx, &QueryEvent{Operation: "Query", Table: "users", Clause: "SELECT 1", Args: []interface{}{"secret"}, Duration: time.Second})
+	require.Len(t, logger.messages, 1)
+	assert.Contains(t, logger.messages[0], "SELECT 1")
+	assert.NotContains(t, logger.messages[0], "secret")
+}
@@ -0,0 +1,325 @@
+package sqlgen
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An IndexMiss describes a query that reads a table without an index.
+type IndexMiss struct {
+	// Shape is the query's clause with literals and placeholder lists
+	// normalized, shared by all queries that differ only in their values.
+	Shape  string
+	Table  string
+	Clause string
+	// Plan is the database's explanation of the missed index.
+	Plan string
+	// CallSite is the file, line and function outside of sqlgen that ran the
+	// query.
+	CallSite string
+}
+
+// An IndexMissSink records queries that miss indexes.
+type IndexMissSink interface {
+	RecordIndexMiss(ctx context.Context, miss IndexMiss)
+}
+
+// IndexCheckOptions configures WithIndexCheck.
+type IndexCheckOptions struct {
+	// Sink records sampled queries that miss indexes.
+	Sink IndexMissSink
+	// SampleRate is the fraction of queries to check, greater than 0 and at
+	// most 1.
+	SampleRate float64
+	// CacheSize is the number of query shapes whose EXPLAIN results are
+	// remembered, and defaults to DefaultIndexCheckCacheSize. Once full, the
+	// least recently used shape is explained again when it is next sampled.
+	CacheSize int
+}
+
+// DefaultIndexCheckCacheSize is the CacheSize of IndexCheckOptions that do not
+// set one.
+const DefaultIndexCheckCacheSize = 1000
+
+// indexChecker checks sampled queries for missed indexes, running EXPLAIN
+// once per query shape.
+type indexChecker struct {
+	options IndexCheckOptions
+
+	// verdicts caches the result of CheckIndex by query shape.
+	verdicts *verdictCache
+}
+
+type indexVerdict struct {
+	ok   bool
+	plan string
+}
+
+// verdictCache is an LRU cache of indexVerdicts by query shape, so that
+// services with many distinct query shapes do not grow it without bound.
+type verdictCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *verdictCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type verdictCacheEntry struct {
+	shape   string
+	verdict indexVerdict
+}
+
+func newVerdictCache(size int) *verdictCache {
+	return &verdictCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *verdictCache) get(shape string) (indexVerdict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[shape]
+	if !ok {
+		return indexVerdict{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*verdictCacheEntry).verdict, true
+}
+
+func (c *verdictCache) add(shape string, verdict indexVerdict) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[shape]; ok {
+		elem.Value.(*verdictCacheEntry).verdict = verdict
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[shape] = c.order.PushFront(&verdictCacheEntry{shape: shape, verdict: verdict})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*verdictCacheEntry).shape)
+	}
+}
+
+// WithIndexCheck returns a copy of the DB that checks a sample of its queries
+// for missed indexes, and records misses to options.Sink instead of panicking
+// like WithPanicOnNoIndex. Queries are explained once per shape, so the check
+// is cheap enough to run in production. Queries that allow no index, such as
+// FullScanQuery, are not checked.
+func (db *DB) WithIndexCheck(options IndexCheckOptions) (*DB, error) {
+	if db.indexChecker != nil {
+		return nil, errors.New("already has index check")
+	}
+	if options.Sink == nil {
+		return nil, errors.New("index check requires a sink")
+	}
+	if options.SampleRate <= 0 || options.SampleRate > 1 {
+		return nil, fmt.Errorf("index check sample rate %v must be in (0, 1]", options.SampleRate)
+	}
+	if options.CacheSize < 0 {
+		return nil, fmt.Errorf("index check cache size %d must not be negative", options.CacheSize)
+	}
+	if options.CacheSize == 0 {
+		options.CacheSize = DefaultIndexCheckCacheSize
+	}
+
+	dbCopy := db.clone()
+	dbCopy.indexChecker = &indexChecker{options: options, verdicts: newVerdictCache(options.CacheSize)}
+	return dbCopy, nil
+}
+
+// checkIndex checks that a query on table uses an index, panicking with
+// WithPanicOnNoIndex and recording a miss with WithIndexCheck.
+func (db *DB) checkIndex(ctx context.Context, table string, clause string, args []interface{}) error {
+	if db.panicOnNoIndex {
+		if err := db.runExplainQuery(ctx, clause, args); err != nil {
+			return err
+		}
+	}
+	if db.indexChecker != nil {
+		db.indexChecker.check(ctx, db, table, clause, args)
+	}
+	return nil
+}
+
+// check records a miss if a sampled query misses an index. Failing to explain
+// the query does not fail the query.
+func (c *indexChecker) check(ctx context.Context, db *DB, table string, clause string, args []interface{}) {
+	if c.options.SampleRate < 1 && rand.Float64() >= c.options.SampleRate {
+		return
+	}
+
+	shape := queryShape(clause)
+	verdict, ok := c.verdicts.get(shape)
+	if !ok {
+		plan, ok, err := db.dialect.CheckIndex(ctx, db.QueryExecer(ctx), clause, args)
+		if err != nil {
+			return
+		}
+		verdict = indexVerdict{ok: ok, plan: plan}
+		c.verdicts.add(shape, verdict)
+	}
+	if verdict.ok {
+		return
+	}
+
+	c.options.Sink.RecordIndexMiss(ctx, IndexMiss{
+		Shape:    shape,
+		Table:    table,
+		Clause:   clause,
+		Plan:     verdict.plan,
+		CallSite: callSite(),
+	})
+}
+
+var (
+	shapeStrings      = regexp.MustCompile(`'(?:[^']|'')*'`)
+	shapeNumbers      = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	shapePlaceholders = regexp.MustCompile(`\$\d+`)
+	shapeLists        = regexp.MustCompile(`\(\?(?:, ?\?)*\)`)
+	shapeTupleLists   = regexp.MustCompile(`\(\(\.\.\.\)(?:, ?\(\.\.\.\))*\)`)
+)
+
+// queryShape normalizes a clause so that queries that only differ in their
+// values, or in the length of IN lists of values or tuples, have the same
+// shape.
+func queryShape(clause string) string {
+	shape := shapePlaceholders.ReplaceAllString(clause, "?")
+	shape = shapeStrings.ReplaceAllString(shape, "?")
+	shape = shapeNumbers.ReplaceAllString(shape, "?")
+	shape = shapeLists.ReplaceAllString(shape, "(...)")
+	return shapeTupleLists.ReplaceAllString(shape, "(...)")
+}
+
+// internalPackages are the packages whose frames callSite skips.
+var internalPackages = []string{
+	"github.com/samson-crypto/thunder/sqlgen.",
+	"github.com/samson-crypto/thunder/livesql.",
+	"github.com/samson-crypto/thunder/batch.",
+	"github.com/samson-crypto/thunder/reactive.",
+}
+
+// callSite returns the first frame on the stack outside of sqlgen and the
+// packages that wrap it, or "unknown".
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !isInternalFrame(frame) {
+			return fmt.Sprintf("%s:%d %s", frame.File, frame.Line, frame.Function)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+func isInternalFrame(frame runtime.Frame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
+	}
+	if strings.HasPrefix(frame.Function, "runtime.") {
+		return true
+	}
+	for _, pkg := range internalPackages {
+		if strings.HasPrefix(frame.Function, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexReportEntry summarizes the misses of a query shape.
+type IndexReportEntry struct {
+	Shape     string
+	Table     string
+	Plan      string
+	Count     int64
+	CallSites []string
+}
+
+// IndexReport is an IndexMissSink that aggregates misses by query shape.
+type IndexReport struct {
+	mu      sync.Mutex
+	entries map[string]*indexReportEntry
+}
+
+type indexReportEntry struct {
+	IndexReportEntry
+	callSites map[string]bool
+}
+
+// NewIndexReport creates an empty IndexReport.
+func NewIndexReport() *IndexReport {
+	return &IndexReport{entries: make(map[string]*indexReportEntry)}
+}
+
+func (r *IndexReport) RecordIndexMiss(ctx context.Context, miss IndexMiss) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[miss.Shape]
+	if !ok {
+		entry = &indexReportEntry{
+			IndexReportEntry: IndexReportEntry{Shape: miss.Shape, Table: miss.Table, Plan: miss.Plan},
+			callSites:        make(map[string]bool),
+		}
+		r.entries[miss.Shape] = entry
+	}
+	entry.Count++
+	entry.callSites[miss.CallSite] = true
+}
+
+// Entries returns the query shapes that missed indexes, most frequent first.
+func (r *IndexReport) Entries() []IndexReportEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]IndexReportEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		e := entry.IndexReportEntry
+		e.CallSites = make([]string, 0, len(entry.callSites))
+		for callSite := range entry.callSites {
+			e.CallSites = append(e.CallSites, callSite)
+		}
+		sort.Strings(e.CallSites)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Shape < entries[j].Shape
+	})
+	return entries
+}
+
+// String formats the report for humans.
+func (r *IndexReport) String() string {
+	var buffer bytes.Buffer
+	for _, entry := range r.Entries() {
+		fmt.Fprintf(&buffer, "%s (%d queries)\n", entry.Shape, entry.Count)
+		fmt.Fprintf(&buffer, "  table: %s\n", entry.Table)
+		fmt.Fprintf(&buffer, "  plan: %s\n", entry.Plan)
+		for _, callSite := range entry.CallSites {
+			fmt.Fprintf(&buffer, "  at %s\n", callSite)
+		}
+	}
+	return buffer.String()
+}
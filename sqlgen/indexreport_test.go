@@ -0,0 +1,103 @@
+package sqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDialect counts CheckIndex calls.
+type countingDialect struct {
+	Dialect
+	checks int
+}
+
+func (d *countingDialect) CheckIndex(ctx context.Context, q QueryExecer, clause string, args []interface{}) (string, bool, error) {
+	d.checks++
+	return d.Dialect.CheckIndex(ctx, q, clause, args)
+}
+
+func TestQueryShape(t *testing.T) {
+	assert.Equal(t,
+		"SELECT a FROM t1 WHERE b IN (...) AND c = ? AND d = ? LIMIT ?",
+		queryShape("SELECT a FROM t1 WHERE b IN (?, ?, ?) AND c = 'it''s' AND d = ? LIMIT 10"))
+	assert.Equal(t,
+		"SELECT a FROM t WHERE b IN (...) AND c = ?",
+		queryShape("SELECT a FROM t WHERE b IN ($1, $2) AND c = $3"))
+	assert.Equal(t,
+		"SELECT a FROM t WHERE (b, c) IN (...)",
+		queryShape("SELECT a FROM t WHERE (b, c) IN ((?, ?), (?, ?), (?, ?))"))
+	assert.Equal(t,
+		queryShape("SELECT a FROM t WHERE (b, c) IN ((?, ?))"),
+		queryShape("SELECT a FROM t WHERE (b, c) IN ((?, ?), (?, ?))"))
+}
+
+func TestVerdictCache(t *testing.T) {
+	cache := newVerdictCache(2)
+	cache.add("a", indexVerdict{ok: true})
+	cache.add("b", indexVerdict{plan: "SCAN b"})
+	_, ok := cache.get("a")
+	require.True(t, ok)
+
+	// Adding a third shape evicts the least recently used one.
+	cache.add("c", indexVerdict{ok: true})
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+	verdict, ok := cache.get("c")
+	assert.True(t, ok)
+	assert.True(t, verdict.ok)
+	assert.Equal(t, 2, cache.order.Len())
+	assert.Len(t, cache.entries, 2)
+}
+
+func TestSQLiteIndexCheck(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	dialect := &countingDialect{Dialect: SQLite}
+	db = NewDBWithDialect(db.Conn, db.Schema, dialect)
+
+	_, err := db.WithIndexCheck(IndexCheckOptions{SampleRate: 1})
+	assert.Error(t, err)
+	_, err = db.WithIndexCheck(IndexCheckOptions{Sink: NewIndexReport(), SampleRate: 0})
+	assert.Error(t, err)
+	_, err = db.WithIndexCheck(IndexCheckOptions{Sink: NewIndexReport(), SampleRate: 1, CacheSize: -1})
+	assert.Error(t, err)
+
+	report := NewIndexReport()
+	db, err = db.WithIndexCheck(IndexCheckOptions{Sink: report, SampleRate: 1})
+	require.NoError(t, err)
+	_, err = db.WithIndexCheck(IndexCheckOptions{Sink: report, SampleRate: 1})
+	assert.Error(t, err)
+
+	var owls []*Owl
+	for _, genus := range []string{"Tyto", "Strix", "Bubo"} {
+		require.NoError(t, db.Query(ctx, &owls, Filter{"genus": genus}, nil))
+	}
+	require.NoError(t, db.Query(ctx, &owls, Filter{"species": "Tyto alba"}, nil))
+	require.NoError(t, db.FullScanQuery(ctx, &owls, Filter{"family": "Strigidae"}, nil))
+	require.NoError(t, db.Iterate(ctx, &Owl{}, Filter{"genus": "Tyto"}, nil, func(row interface{}) error { return nil }))
+	_, err = db.Count(ctx, &Owl{}, Filter{"genus": "Tyto"})
+	require.NoError(t, err)
+
+	// Each shape is explained once.
+	assert.Equal(t, 3, dialect.checks)
+
+	entries := report.Entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "SELECT COUNT(*) FROM owls WHERE genus = ?", entries[1].Shape)
+	assert.Equal(t, int64(1), entries[1].Count)
+	assert.Equal(t, "SELECT species, common_name, genus, family FROM owls WHERE genus = ?", entries[0].Shape)
+	assert.Equal(t, "owls", entries[0].Table)
+	assert.Equal(t, int64(4), entries[0].Count)
+	assert.Contains(t, entries[0].Plan, "SCAN")
+	require.Len(t, entries[0].CallSites, 2)
+	assert.Contains(t, entries[0].CallSites[0], "indexreport_test.go")
+	assert.Contains(t, entries[0].CallSites[0], "TestSQLiteIndexCheck")
+	assert.Contains(t, report.String(), "(4 queries)")
+}
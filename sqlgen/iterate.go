@@ -0,0 +1,193 @@
+package sqlgen
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/samsarahq/go/oops"
+)
+
+// IterateOptions configures Iterate.
+type IterateOptions struct {
+	// PageSize, if non-zero, reads rows ordered by primary key in pages of
+	// PageSize rows, with a query per page. Otherwise, Iterate reads all rows
+	// with a single query.
+	PageSize int
+
+	// After, if set, starts iterating after the row with this primary key, for
+	// example Filter{"id": 10}. Iterate passes rows in primary key order, so a
+	// failed scan can be resumed after the last row it processed.
+	After Filter
+
+	AllowNoIndex bool
+}
+
+// afterKey is the filter key of Iterate's keyset pagination condition.
+const afterKey = "$sqlgen_after"
+
+// Iterate calls fn with every row matching filter, one row at a time, without
+// loading all rows into memory. If fn returns an error, Iterate stops and
+// returns it.
+//
+// model should be a pointer to a struct, and fn is called with pointers to
+// structs of the same type, for example:
+//
+//   err := db.Iterate(ctx, &User{}, Filter{"org_id": 10}, &IterateOptions{PageSize: 1000},
+//     func(row interface{}) error {
+//       user := row.(*User)
+//       ...
+//     })
+//
+// Without a PageSize, fn runs while the query's result is open, so it must not
+// make queries in the same transaction. With a PageSize, each page is read
+// before fn is called with its rows.
+func (db *DB) Iterate(ctx context.Context, model interface{}, filter Filter, options *IterateOptions, fn func(row interface{}) error) error {
+	typ, err := checkMutateRowTypeShape(reflect.TypeOf(model))
+	if err != nil {
+		return err
+	}
+	table, err := db.Schema.get(typ)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		options = &IterateOptions{}
+	}
+	filter = table.baseTable().scopeFilter(filter)
+	primaryKey := table.primaryKey()
+
+	orderBy := ""
+	if options.PageSize > 0 || options.After != nil {
+		if len(primaryKey) == 0 {
+			return fmt.Errorf("sqlgen: table %s has no primary key to paginate by", table.Name)
+		}
+		orderBy = strings.Join(primaryKey, ", ")
+	}
+
+	after := options.After
+	for {
+		pageFilter := filter
+		if after != nil {
+			condition, err := afterCondition(primaryKey, after)
+			if err != nil {
+				return err
+			}
+			pageFilter = make(Filter, len(filter)+1)
+			for k, v := range filter {
+				pageFilter[k] = v
+			}
+			pageFilter[afterKey] = condition
+		}
+
+		query := &BaseSelectQuery{
+			Table:  table,
+			Filter: pageFilter,
+			Options: &SelectOptions{
+				OrderBy:      orderBy,
+				Limit:        options.PageSize,
+				AllowNoIndex: options.AllowNoIndex,
+			},
+		}
+
+		if options.PageSize == 0 {
+			return db.iterateQuery(ctx, query, fn)
+		}
+
+		var rows []interface{}
+		if err := db.iterateQuery(ctx, query, func(row interface{}) error {
+			rows = append(rows, row)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if len(rows) < options.PageSize {
+			return nil
+		}
+		after = table.primaryKeyFilter(rows[len(rows)-1])
+	}
+}
+
+// afterCondition builds a condition matching rows whose primary key sorts
+// after the key in after: for a key (a, b), a > ? OR (a = ? AND b > ?).
+func afterCondition(primaryKey []string, after Filter) (*Condition, error) {
+	if len(after) != len(primaryKey) {
+		return nil, fmt.Errorf("sqlgen: iterate after %v must specify the primary key %v", after, primaryKey)
+	}
+
+	filters := make([]Filter, 0, len(primaryKey))
+	for i, column := range primaryKey {
+		value, ok := after[column]
+		if !ok {
+			return nil, fmt.Errorf("sqlgen: iterate after %v must specify the primary key %v", after, primaryKey)
+		}
+
+		f := Filter{column: Gt(value)}
+		for _, previous := range primaryKey[:i] {
+			f[previous] = after[previous]
+		}
+		filters = append(filters, f)
+	}
+	return Or(filters...), nil
+}
+
+// primaryKeyFilter returns a filter matching row's primary key.
+func (t *Table) primaryKeyFilter(row interface{}) Filter {
+	filter := make(Filter)
+	struc := reflect.ValueOf(row).Elem()
+	for _, column := range t.Columns {
+		if column.Primary {
+			filter[column.Name] = struc.FieldByIndex(column.Index).Interface()
+		}
+	}
+	return filter
+}
+
+// iterateQuery runs query, and calls fn with each row as it is scanned.
+func (db *DB) iterateQuery(ctx context.Context, query *BaseSelectQuery, fn func(row interface{}) error) error {
+	selectQuery, err := query.MakeSelectQuery()
+	if err != nil {
+		return err
+	}
+
+	if err := db.checkFilterAgainstLimits(ctx, selectQuery, query.Filter, query.Table); err != nil {
+		return err
+	}
+
+	clause, args := db.toSQL(selectQuery)
+
+	if !query.Options.AllowNoIndex {
+		if err := db.checkIndex(ctx, query.Table.Name, clause, args); err != nil {
+			return oops.Wrapf(err, "Failed to run explain query")
+		}
+	}
+
+	event := &QueryEvent{Operation: "Iterate", Table: query.Table.Name, Clause: clause, Args: args}
+	return db.instrument(ctx, event, func(ctx context.Context) (int64, error) {
+		res, err := db.reader(ctx, db.readsFromPrimary(ctx, false)).QueryContext(ctx, clause, args...)
+		if err != nil {
+			return -1, err
+		}
+		defer res.Close()
+
+		var rows int64
+		for res.Next() {
+			row, err := parseQueryRow(query.Table, selectQuery.columns, res)
+			if err != nil {
+				return rows, err
+			}
+			rows++
+			if err := fn(row); err != nil {
+				return rows, err
+			}
+		}
+		return rows, res.Err()
+	})
+}
@@ -0,0 +1,83 @@
+package sqlgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterate(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	var inserted []*User
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		inserted = append(inserted, &User{Name: name})
+	}
+	require.NoError(t, db.InsertRows(ctx, inserted, 100))
+
+	collect := func(filter Filter, options *IterateOptions) ([]string, []int64) {
+		var names []string
+		var ids []int64
+		require.NoError(t, db.Iterate(ctx, &User{}, filter, options, func(row interface{}) error {
+			user := row.(*User)
+			names = append(names, user.Name)
+			ids = append(ids, user.Id)
+			return nil
+		}))
+		return names, ids
+	}
+
+	names, _ := collect(nil, nil)
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d", "e", "f", "g"}, names)
+
+	names, ids := collect(Filter{"name": Not("d")}, &IterateOptions{PageSize: 2})
+	assert.Equal(t, []string{"a", "b", "c", "e", "f", "g"}, names)
+	assert.Equal(t, []int64{1, 2, 3, 5, 6, 7}, ids)
+
+	names, _ = collect(nil, &IterateOptions{PageSize: 7})
+	assert.Len(t, names, 7)
+
+	names, _ = collect(nil, &IterateOptions{PageSize: 3, After: Filter{"id": 4}})
+	assert.Equal(t, []string{"e", "f", "g"}, names)
+
+	names, _ = collect(nil, &IterateOptions{After: Filter{"id": 5}})
+	assert.Equal(t, []string{"f", "g"}, names)
+
+	stop := errors.New("stop")
+	count := 0
+	err := db.Iterate(ctx, &User{}, nil, &IterateOptions{PageSize: 2}, func(row interface{}) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 3, count)
+
+	err = db.Iterate(ctx, &User{}, nil, &IterateOptions{After: Filter{"name": "a"}}, func(row interface{}) error { return nil })
+	assert.Error(t, err)
+	err = db.Iterate(ctx, []*User{}, nil, nil, func(row interface{}) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestAfterCondition(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("users", UniqueId, user{}))
+
+	condition, err := afterCondition([]string{"id", "name"}, Filter{"id": 10, "name": "bob"})
+	require.NoError(t, err)
+	where, err := makeWhere(s.ByName["users"], Filter{"$after": condition})
+	require.NoError(t, err)
+	clause, args := where.ToSQL()
+	assert.Equal(t, "((id > ?) OR (id = ? AND name > ?))", clause)
+	assert.Equal(t, []interface{}{int64(10), int64(10), "bob"}, args)
+
+	_, err = afterCondition([]string{"id", "name"}, Filter{"id": 10})
+	assert.Error(t, err)
+}
@@ -11,31 +11,46 @@ type SimpleWhere struct {
 	Values  []interface{}
 }
 
-// ToSQL builds a `a = ? AND b = ?` clause
+// ToSQL builds a `a = ? AND b = ?` clause. Values that are bound Conditions
+// render their own operators.
 func (w *SimpleWhere) ToSQL() (string, []interface{}) {
 	var buffer bytes.Buffer
+	values := w.Values
 
 	if len(w.Columns) > 0 {
+		values = make([]interface{}, 0, len(w.Values))
 		for i, column := range w.Columns {
 			if i > 0 {
 				buffer.WriteString(" AND ")
 			}
+			if condition, ok := w.Values[i].(*Condition); ok {
+				values = append(values, condition.writeSQL(&buffer, column)...)
+				continue
+			}
 			buffer.WriteString(column)
 			if w.Values[i] == nil {
 				buffer.WriteString(" IS ?")
 			} else {
 				buffer.WriteString(" = ?")
 			}
+			values = append(values, w.Values[i])
 		}
 	}
 
-	return buffer.String(), w.Values
+	return buffer.String(), values
 }
 
+// SQLQuery is a statement built with MySQL syntax and ? placeholders.
 type SQLQuery interface {
 	ToSQL() (string, []interface{})
 }
 
+// dialectQuery is a SQLQuery whose syntax depends on the Dialect beyond what
+// Dialect.Rebind rewrites.
+type dialectQuery interface {
+	toDialectSQL(dialect Dialect) (string, []interface{})
+}
+
 type countQuery struct {
 	Table string
 	Where *SimpleWhere
@@ -58,12 +73,72 @@ func (q *countQuery) ToSQL() (string, []interface{}) {
 	return buffer.String(), whereValues
 }
 
+// AggregateQuery represents a SELECT ... GROUP BY query
+type AggregateQuery struct {
+	Table string
+	// Columns are the selected columns and aggregate expressions.
+	Columns []string
+	Where   string
+	Values  []interface{}
+	GroupBy []string
+	OrderBy string
+	Limit   int
+}
+
+// ToSQL builds a parameterized SELECT a, SUM(b) AS c FROM x ... GROUP BY a
+// statement
+func (q *AggregateQuery) ToSQL() (string, []interface{}) {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("SELECT ")
+	for i, column := range q.Columns {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(column)
+	}
+	buffer.WriteString(" FROM ")
+	buffer.WriteString(q.Table)
+
+	if q.Where != "" {
+		buffer.WriteString(" WHERE ")
+		buffer.WriteString(q.Where)
+	}
+
+	if len(q.GroupBy) > 0 {
+		buffer.WriteString(" GROUP BY ")
+		for i, column := range q.GroupBy {
+			if i > 0 {
+				buffer.WriteString(", ")
+			}
+			buffer.WriteString(column)
+		}
+	}
+
+	if q.OrderBy != "" {
+		buffer.WriteString(" ORDER BY ")
+		buffer.WriteString(q.OrderBy)
+	}
+
+	if q.Limit != 0 {
+		buffer.WriteString(" LIMIT ")
+		fmt.Fprint(&buffer, q.Limit)
+	}
+
+	return buffer.String(), q.Values
+}
+
 // SelectQuery represents a SELECT query
 type SelectQuery struct {
 	Table   string
 	Columns []string
 
 	Options *SelectOptions
+
+	// table and columns are the table and columns rows are parsed into, if the
+	// query was built by a BaseSelectQuery.
+	table   *Table
+	columns []*Column
 }
 
 // ToSQL builds a parameterized SELECT a, b, c FROM x ... statement
@@ -180,15 +255,27 @@ func (q *BatchInsertQuery) ToSQL() (string, []interface{}) {
 
 // UpsertQuery represents a INSERT ... ON DUPLICATE KEY UPDATE query
 type UpsertQuery struct {
-	Table   string
-	Columns []string
-	Values  []interface{}
+	Table      string
+	Columns    []string
+	Values     []interface{}
+	PrimaryKey []string
+
+	// ConflictColumns, if set, replace PrimaryKey as the columns that detect
+	// conflicts.
+	ConflictColumns []string
+	// Set are the assignments made to conflicting rows, or nil to set every
+	// column to its inserted value.
+	Set []UpsertSet
 }
 
 // ToSQL builds a parameterized INSERT INTO x (a, b) VALUES (?, ?) statement
 func (q *UpsertQuery) ToSQL() (string, []interface{}) {
+	return q.toDialectSQL(MySQL)
+}
+
+func (q *UpsertQuery) toDialectSQL(dialect Dialect) (string, []interface{}) {
 	var buffer bytes.Buffer
-	buffer.WriteString("INSERT INTO ")
+	buffer.WriteString(upsertInsert(dialect, q.Set))
 	buffer.WriteString(q.Table)
 
 	buffer.WriteString(" (")
@@ -205,31 +292,35 @@ func (q *UpsertQuery) ToSQL() (string, []interface{}) {
 		}
 		buffer.WriteString("?")
 	}
-	buffer.WriteString(") ON DUPLICATE KEY UPDATE ")
-	for i, column := range q.Columns {
-		if i > 0 {
-			buffer.WriteString(", ")
-		}
-		buffer.WriteString(column)
-		buffer.WriteString("=VALUES(")
-		buffer.WriteString(column)
-		buffer.WriteString(")")
-	}
+	buffer.WriteString(")")
+	buffer.WriteString(upsertClause(dialect, q.PrimaryKey, q.ConflictColumns, q.Columns, q.Set))
 
 	return buffer.String(), q.Values
 }
 
 // BatchUpsertQuery represents a INSERT ... ON DUPLICATE KEY UPDATE query with multiple rows
 type BatchUpsertQuery struct {
-	Table   string
-	Columns []string
-	Values  []interface{}
+	Table      string
+	Columns    []string
+	Values     []interface{}
+	PrimaryKey []string
+
+	// ConflictColumns, if set, replace PrimaryKey as the columns that detect
+	// conflicts.
+	ConflictColumns []string
+	// Set are the assignments made to conflicting rows, or nil to set every
+	// column to its inserted value.
+	Set []UpsertSet
 }
 
 // ToSQL builds a parameterized INSERT INTO x (a, b) VALUES (?, ?) ON DUPLICATE KEY UPDATE query statement
 func (q *BatchUpsertQuery) ToSQL() (string, []interface{}) {
+	return q.toDialectSQL(MySQL)
+}
+
+func (q *BatchUpsertQuery) toDialectSQL(dialect Dialect) (string, []interface{}) {
 	var buffer bytes.Buffer
-	buffer.WriteString("INSERT INTO ")
+	buffer.WriteString(upsertInsert(dialect, q.Set))
 	buffer.WriteString(q.Table)
 
 	if len(q.Columns) > 0 {
@@ -258,16 +349,7 @@ func (q *BatchUpsertQuery) ToSQL() (string, []interface{}) {
 		}
 	}
 
-	buffer.WriteString(" ON DUPLICATE KEY UPDATE ")
-	for i, column := range q.Columns {
-		if i > 0 {
-			buffer.WriteString(", ")
-		}
-		buffer.WriteString(column)
-		buffer.WriteString("=VALUES(")
-		buffer.WriteString(column)
-		buffer.WriteString(")")
-	}
+	buffer.WriteString(upsertClause(dialect, q.PrimaryKey, q.ConflictColumns, q.Columns, q.Set))
 
 	return buffer.String(), q.Values
 }
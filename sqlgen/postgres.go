@@ -0,0 +1,161 @@
+package sqlgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/samsarahq/go/oops"
+)
+
+// PostgreSQL is a Dialect for PostgreSQL. It uses $n placeholders, upserts
+// with ON CONFLICT on the primary key, and returns auto-increment ids with
+// RETURNING.
+//
+// PostgreSQL's planner prefers sequential scans on small tables even when an
+// index exists, so tests relying on WithPanicOnNoIndex should disable
+// enable_seqscan.
+var PostgreSQL Dialect = postgresDialect{}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Rebind(clause string, args []interface{}) (string, []interface{}) {
+	return rebindPlaceholders(clause, args, true, func(n int) string {
+		return "$" + strconv.Itoa(n)
+	})
+}
+
+func (postgresDialect) UpsertClause(conflictColumns []string, set []UpsertSet) string {
+	return onConflictUpdate(conflictColumns, set)
+}
+
+// onConflictUpdate builds the ON CONFLICT upsert clause shared by PostgreSQL
+// and SQLite.
+func onConflictUpdate(conflictColumns []string, set []UpsertSet) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(" ON CONFLICT (")
+	for i, column := range conflictColumns {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(column)
+	}
+	if len(set) == 0 {
+		buffer.WriteString(") DO NOTHING")
+		return buffer.String()
+	}
+	buffer.WriteString(") DO UPDATE SET ")
+	for i, assignment := range set {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(assignment.Column)
+		buffer.WriteString(" = ")
+		if assignment.Expression != "" {
+			buffer.WriteString(excludedExpression(assignment.Expression, assignment.table))
+		} else {
+			buffer.WriteString("EXCLUDED.")
+			buffer.WriteString(assignment.Column)
+		}
+	}
+	return buffer.String()
+}
+
+func (postgresDialect) ReturningClause(column string) string {
+	return " RETURNING " + column
+}
+
+// postgresPlan is a node of PostgreSQL's EXPLAIN (FORMAT JSON) output.
+type postgresPlan struct {
+	NodeType     string         `json:"Node Type"`
+	RelationName string         `json:"Relation Name,omitempty"`
+	Plans        []postgresPlan `json:"Plans,omitempty"`
+}
+
+// findSeqScan returns the first sequential scan in plan.
+func (plan *postgresPlan) findSeqScan() *postgresPlan {
+	if plan.NodeType == "Seq Scan" {
+		return plan
+	}
+	for i := range plan.Plans {
+		if scan := plan.Plans[i].findSeqScan(); scan != nil {
+			return scan
+		}
+	}
+	return nil
+}
+
+// parsePostgresExplain parses EXPLAIN (FORMAT JSON) output and returns the
+// first sequential scan, if any.
+func parsePostgresExplain(output []byte) (*postgresPlan, error) {
+	var explained []struct {
+		Plan postgresPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal(output, &explained); err != nil {
+		return nil, err
+	}
+	for i := range explained {
+		if scan := explained[i].Plan.findSeqScan(); scan != nil {
+			return scan, nil
+		}
+	}
+	return nil, nil
+}
+
+func (postgresDialect) CheckIndex(ctx context.Context, q QueryExecer, clause string, args []interface{}) (string, bool, error) {
+	var output []byte
+	if err := q.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+clause, args...).Scan(&output); err != nil {
+		return "", false, oops.Wrapf(err, "Failed to run explain on the query")
+	}
+
+	scan, err := parsePostgresExplain(output)
+	if err != nil {
+		return "", false, oops.Wrapf(err, "failed to parse explain results")
+	}
+	if scan != nil {
+		scanJSON, _ := json.Marshal(scan)
+		return string(scanJSON), false, nil
+	}
+	return "", true, nil
+}
+
+func (postgresDialect) columnType(kind columnKind, primary bool) string {
+	switch kind {
+	case kindInt:
+		return "BIGINT"
+	case kindFloat:
+		return "DOUBLE PRECISION"
+	case kindBool:
+		return "BOOLEAN"
+	case kindBytes:
+		return "BYTEA"
+	case kindTime:
+		return "TIMESTAMP"
+	default:
+		return "TEXT"
+	}
+}
+
+func (postgresDialect) autoIncrementColumn(name string) string {
+	return name + " BIGSERIAL PRIMARY KEY"
+}
+
+func (postgresDialect) describeTable(ctx context.Context, q QueryExecer, table string) ([]dbColumn, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT c.column_name, c.data_type, c.is_nullable = 'YES', EXISTS (
+			SELECT 1
+			FROM information_schema.table_constraints tc
+			JOIN information_schema.key_column_usage k
+				ON k.constraint_name = tc.constraint_name AND k.table_schema = tc.table_schema AND k.table_name = tc.table_name
+			WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema
+				AND tc.table_name = c.table_name AND k.column_name = c.column_name
+		)
+		FROM information_schema.columns c
+		WHERE c.table_schema = current_schema() AND c.table_name = $1
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	return scanDBColumns(rows)
+}
@@ -0,0 +1,150 @@
+package sqlgen
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type UserName struct {
+	Id   int64
+	Name string
+}
+
+func TestRegisterProjection(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("users", AutoIncrement, User{})
+	require.NoError(t, schema.RegisterProjection("users", UserName{}))
+
+	table := schema.ByType[reflect.TypeOf(UserName{})]
+	assert.Equal(t, "users", table.Name)
+	assert.Equal(t, []string{"id"}, table.primaryKey())
+	assert.Equal(t, schema.ByName["users"], schema.ByName[table.Name])
+
+	assert.Error(t, schema.RegisterProjection("users", UserName{}))
+	assert.Error(t, schema.RegisterProjection("missing", struct{ Id int64 }{}))
+	assert.Error(t, schema.RegisterProjection("users", struct{ Age int64 }{}))
+	assert.Error(t, schema.RegisterProjection("users", struct{ Name string }{}))
+
+	_, err := schema.MakeInsertRow(&UserName{Name: "bob"})
+	assert.Error(t, err)
+	_, err = schema.MakeUpdateRow(&UserName{Id: 1, Name: "bob"})
+	assert.Error(t, err)
+	_, err = schema.MakeDeleteRow(&UserName{Id: 1})
+	assert.Error(t, err)
+
+	query, err := schema.makeSelect(reflect.TypeOf(UserName{}), Filter{"mood": nil}, nil)
+	require.NoError(t, err)
+	selectQuery, err := query.MakeSelectQuery()
+	require.NoError(t, err)
+	clause, args := selectQuery.ToSQL()
+	assert.Equal(t, "SELECT id, name FROM users WHERE mood IS ?", clause)
+	assert.Equal(t, []interface{}{nil}, args)
+
+	query, err = schema.makeSelect(reflect.TypeOf(User{}), nil, &SelectOptions{Columns: []string{"name", "id"}})
+	require.NoError(t, err)
+	selectQuery, err = query.MakeSelectQuery()
+	require.NoError(t, err)
+	clause, _ = selectQuery.ToSQL()
+	assert.Equal(t, "SELECT id, name FROM users", clause)
+
+	query, err = schema.makeSelect(reflect.TypeOf(User{}), nil, &SelectOptions{Columns: []string{"age"}})
+	require.NoError(t, err)
+	_, err = query.MakeSelectQuery()
+	assert.Error(t, err)
+}
+
+func TestMakeSelectQueryKeepsOptions(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("users", AutoIncrement, User{})
+
+	options := &SelectOptions{Columns: []string{"name"}}
+	query, err := schema.makeSelect(reflect.TypeOf(User{}), Filter{"name": "bob"}, options)
+	require.NoError(t, err)
+
+	// Building the SQL, as livesql does for its cache key, must leave the
+	// query batchable and not repeat the filter.
+	for i := 0; i < 2; i++ {
+		selectQuery, err := query.MakeSelectQuery()
+		require.NoError(t, err)
+		clause, args := selectQuery.ToSQL()
+		assert.Equal(t, "SELECT name FROM users WHERE name = ?", clause)
+		assert.Equal(t, []interface{}{"bob"}, args)
+	}
+	assert.Equal(t, &SelectOptions{Columns: []string{"name"}}, options)
+	assert.True(t, query.batchable())
+}
+
+func TestSQLiteProjection(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+	require.NoError(t, db.Schema.RegisterProjection("users", UserName{}))
+
+	require.NoError(t, db.InsertRows(ctx, []*User{{Name: "Bob", ImplicitNull: "a"}, {Name: "Alice", ImplicitNull: "b"}}, 10))
+
+	var names []*UserName
+	require.NoError(t, db.Query(ctx, &names, Filter{"implicit_null": "b"}, nil))
+	assert.Equal(t, []*UserName{{Id: 2, Name: "Alice"}}, names)
+
+	var users []*User
+	require.NoError(t, db.Query(ctx, &users, nil, &SelectOptions{Columns: []string{"name"}, OrderBy: "id"}))
+	assert.Equal(t, []*User{{Name: "Bob"}, {Name: "Alice"}}, users)
+
+	count, err := db.Count(ctx, &UserName{}, Filter{"implicit_null": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	var iterated []string
+	require.NoError(t, db.Iterate(ctx, &UserName{}, nil, &IterateOptions{PageSize: 1}, func(row interface{}) error {
+		iterated = append(iterated, row.(*UserName).Name)
+		return nil
+	}))
+	assert.Equal(t, []string{"Bob", "Alice"}, iterated)
+
+	_, err = db.InsertRow(ctx, &UserName{Name: "Carol"})
+	assert.Error(t, err)
+}
+
+func TestSQLiteBatchProjection(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	require.NoError(t, db.Schema.RegisterProjection("users", UserName{}))
+	ctx := batch.WithBatching(context.Background())
+
+	require.NoError(t, db.InsertRows(ctx, []*User{{Name: "Bob", ImplicitNull: "a"}, {Name: "Alice", ImplicitNull: "b"}}, 10))
+
+	var wg sync.WaitGroup
+	var byId, byNull []*UserName
+	var full, columns []*User
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Query(ctx, &byId, Filter{"id": int64(1)}, nil))
+	}()
+	go func() {
+		defer wg.Done()
+		// Filters on unselected columns are not batched.
+		assert.NoError(t, db.Query(ctx, &byNull, Filter{"implicit_null": "b"}, nil))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Query(ctx, &full, Filter{"id": int64(2)}, nil))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Query(ctx, &columns, Filter{"name": "Bob"}, &SelectOptions{Columns: []string{"name"}}))
+	}()
+	wg.Wait()
+
+	assert.Equal(t, []*UserName{{Id: 1, Name: "Bob"}}, byId)
+	assert.Equal(t, []*UserName{{Id: 2, Name: "Alice"}}, byNull)
+	require.Len(t, full, 1)
+	assert.Equal(t, "b", full[0].ImplicitNull)
+	assert.Equal(t, []*User{{Name: "Bob"}}, columns)
+}
@@ -0,0 +1,160 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Invoice struct {
+	Id        int64 `sql:",primary"`
+	Amount    int64
+	Tax       int64 `sql:",readonly"`
+	CreatedAt int64 `sql:",default"`
+}
+
+type Counter struct {
+	Name      string `sql:",primary"`
+	Count     int64
+	Double    int64 `sql:",readonly"`
+	CreatedAt int64 `sql:",default"`
+}
+
+func TestReadOnlyColumnsQuery(t *testing.T) {
+	s := NewSchema()
+	s.MustRegisterType("invoices", AutoIncrement, Invoice{})
+	s.MustRegisterType("counters", UniqueId, Counter{})
+
+	insert, err := s.MakeInsertRow(&Invoice{Amount: 100, Tax: 1, CreatedAt: 2})
+	require.NoError(t, err)
+	clause, args := insert.ToSQL()
+	assert.Equal(t, "INSERT INTO invoices (amount) VALUES (?)", clause)
+	assert.Equal(t, []interface{}{int64(100)}, args)
+
+	batch, err := s.MakeBatchInsertRow([]interface{}{&Invoice{Amount: 1}, &Invoice{Amount: 2}})
+	require.NoError(t, err)
+	clause, _ = batch.ToSQL()
+	assert.Equal(t, "INSERT INTO invoices (amount) VALUES (?), (?)", clause)
+
+	// Updates write default columns, but not readonly ones.
+	update, err := s.MakeUpdateRow(&Invoice{Id: 1, Amount: 100, Tax: 1, CreatedAt: 2})
+	require.NoError(t, err)
+	clause, args = update.ToSQL()
+	assert.Equal(t, "UPDATE invoices SET amount = ?, created_at = ? WHERE id = ?", clause)
+	assert.Equal(t, []interface{}{int64(100), int64(2), int64(1)}, args)
+	_, err = s.makeUpdateRow(&Invoice{Id: 1}, nil, []string{"tax"})
+	assert.Error(t, err)
+
+	changed, err := s.changedColumns(&Invoice{Id: 1, Tax: 1}, &Invoice{Id: 1, Amount: 10, Tax: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"amount"}, changed)
+
+	upsert, err := s.MakeUpsertRow(&Counter{Name: "a", Count: 1})
+	require.NoError(t, err)
+	clause, _ = upsert.ToSQL()
+	assert.Equal(t, "INSERT INTO counters (name, count) VALUES (?, ?) ON DUPLICATE KEY UPDATE name=VALUES(name), count=VALUES(count)", clause)
+	for _, options := range []*UpsertOptions{
+		{UpdateColumns: []string{"double"}},
+		{KeepColumns: []string{"created_at"}},
+		{Expressions: map[string]string{"double": "1"}},
+	} {
+		_, err := s.MakeUpsertRowWithOptions(&Counter{Name: "a"}, options)
+		assert.Error(t, err)
+	}
+
+	// Testers match any value of readonly columns.
+	tester, err := s.MakeTester("invoices", Filter{"amount": int64(100), "tax": int64(10)})
+	require.NoError(t, err)
+	assert.True(t, tester.Test(&Invoice{Amount: 100, Tax: 0}))
+	assert.False(t, tester.Test(&Invoice{Amount: 50, Tax: 10}))
+
+	for _, typ := range []interface{}{
+		struct {
+			Id int64 `sql:",primary,readonly"`
+		}{},
+		struct {
+			Id      int64 `sql:",primary"`
+			Version int64 `sql:",version,default"`
+		}{},
+		struct {
+			Id    int64 `sql:",primary"`
+			Value int64 `sql:",readonly,default"`
+		}{},
+	} {
+		assert.Error(t, NewSchema().RegisterType("bad", AutoIncrement, typ))
+	}
+}
+
+func TestSQLiteReadOnlyColumns(t *testing.T) {
+	testDb, err := testfixtures.NewSQLiteTestDatabase(
+		`CREATE TABLE invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			amount BIGINT NOT NULL,
+			tax BIGINT GENERATED ALWAYS AS (amount / 10) VIRTUAL,
+			created_at BIGINT NOT NULL DEFAULT 42
+		)`,
+		`CREATE TABLE counters (
+			name TEXT PRIMARY KEY,
+			count BIGINT NOT NULL,
+			double BIGINT GENERATED ALWAYS AS (count * 2) VIRTUAL,
+			created_at BIGINT NOT NULL DEFAULT 7
+		)`,
+		`CREATE TRIGGER skip_counter BEFORE INSERT ON counters WHEN NEW.name = 'skipped'
+		BEGIN
+			SELECT RAISE(IGNORE);
+		END`,
+		`CREATE TRIGGER delete_counter AFTER INSERT ON counters WHEN NEW.name = 'deleted'
+		BEGIN
+			DELETE FROM counters WHERE name = NEW.name;
+		END`,
+	)
+	require.NoError(t, err)
+	defer testDb.Close()
+	schema := NewSchema()
+	schema.MustRegisterType("invoices", AutoIncrement, Invoice{})
+	schema.MustRegisterType("counters", UniqueId, Counter{})
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+	ctx := context.Background()
+
+	// Server-filled values are read back after an insert.
+	invoice := &Invoice{Amount: 100, Tax: 1, CreatedAt: 2}
+	_, err = db.InsertRow(ctx, invoice)
+	require.NoError(t, err)
+	assert.Equal(t, &Invoice{Id: 1, Amount: 100, Tax: 10, CreatedAt: 42}, invoice)
+
+	invoice.Amount = 200
+	require.NoError(t, db.UpdateRow(ctx, invoice))
+	var fetched *Invoice
+	require.NoError(t, db.QueryRow(ctx, &fetched, Filter{"id": int64(1)}, nil))
+	assert.Equal(t, &Invoice{Id: 1, Amount: 200, Tax: 20, CreatedAt: 42}, fetched)
+
+	counter := &Counter{Name: "a", Count: 3}
+	_, err = db.UpsertRowWithOptions(ctx, counter, &UpsertOptions{Expressions: map[string]string{"count": "count + VALUES(count)"}})
+	require.NoError(t, err)
+	assert.Equal(t, &Counter{Name: "a", Count: 3, Double: 6, CreatedAt: 7}, counter)
+
+	counter = &Counter{Name: "a", Count: 2}
+	_, err = db.UpsertRowWithOptions(ctx, counter, &UpsertOptions{Expressions: map[string]string{"count": "count + VALUES(count)"}})
+	require.NoError(t, err)
+	// Only readonly and default columns are read back, so Count keeps the
+	// inserted value.
+	assert.Equal(t, &Counter{Name: "a", Count: 2, Double: 10, CreatedAt: 7}, counter)
+
+	// Nothing is read back if nothing was written.
+	counter = &Counter{Name: "skipped", Count: 1}
+	res, err := db.InsertRow(ctx, counter)
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), affected)
+	assert.Equal(t, &Counter{Name: "skipped", Count: 1}, counter)
+
+	// A written row that cannot be read back is an error.
+	_, err = db.InsertRow(ctx, &Counter{Name: "deleted", Count: 1})
+	assert.True(t, errors.Is(err, sql.ErrNoRows), "%v", err)
+}
@@ -21,6 +21,10 @@ type SelectOptions struct {
 	Where  string
 	Values []interface{}
 
+	// Columns, if set, selects only these columns. Fields of other columns are
+	// left zero.
+	Columns []string
+
 	OrderBy   string
 	Limit     int
 	ForUpdate bool
@@ -47,15 +51,22 @@ func (s *SelectOptions) IncludeFilter(table *Table, filter Filter) error {
 	return nil
 }
 
+// PrimaryKeyType describes how the primary key of a table is assigned. A table
+// may have several primary key columns, which together form a composite key.
 type PrimaryKeyType int
 
 const (
+	// AutoIncrement tables have their first primary key column assigned by
+	// the database on insert. If its field is a non-pointer integer, inserts
+	// set it to the assigned id. Any other primary key columns are written
+	// like other columns.
 	AutoIncrement PrimaryKeyType = iota
+	// UniqueId tables have all their primary key columns written on insert.
 	UniqueId
 )
 
-// parseQueryRow parses a row from a sql.DB query into a struct
-func parseQueryRow(table *Table, scanner *sql.Rows) (interface{}, error) {
+// parseQueryRow parses a row of columns from a sql.DB query into a struct
+func parseQueryRow(table *Table, columns []*Column, scanner *sql.Rows) (interface{}, error) {
 	ptr := reflect.New(table.Type)
 	elem := ptr.Elem()
 
@@ -64,16 +75,12 @@ func parseQueryRow(table *Table, scanner *sql.Rows) (interface{}, error) {
 
 	// Descriptor Scanner is instantiated with a reference to our struct fields.
 	// It scans directly into our struct.
-	for i, column := range table.Columns {
-		field := elem.FieldByIndex(column.Index)
-		if field.Kind() != reflect.Ptr {
-			field = field.Addr()
-		}
-		// Scan into field.
-		scanners[i].(*fields.Scanner).Target(field)
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		targets[i] = scanTarget(scanners[column.Order], elem, column)
 	}
 
-	if err := scanner.Scan(scanners...); err != nil {
+	if err := scanner.Scan(targets...); err != nil {
 		columns, _ := scanner.Columns()
 		return nil, fmt.Errorf("sqlgen: parsing error for `%s`.(%v): %v", table.Name, columns, err)
 	}
@@ -81,6 +88,17 @@ func parseQueryRow(table *Table, scanner *sql.Rows) (interface{}, error) {
 	return ptr.Interface(), nil
 }
 
+// scanTarget targets scanner at column's field in elem, and returns it.
+func scanTarget(scanner interface{}, elem reflect.Value, column *Column) interface{} {
+	field := elem.FieldByIndex(column.Index)
+	if field.Kind() != reflect.Ptr {
+		field = field.Addr()
+	}
+	// Scan into field.
+	scanner.(*fields.Scanner).Target(field)
+	return scanner
+}
+
 func CopySlice(result interface{}, rows []interface{}) error {
 	ptr := reflect.ValueOf(result)
 	slice := ptr.Elem()
@@ -123,6 +141,15 @@ func makeSnake(s string) string {
 type Column struct {
 	Name    string
 	Primary bool
+	// Version marks an integer column that is checked and incremented by
+	// every update, to detect concurrent writes.
+	Version bool
+	// ReadOnly marks a column the database computes, such as a generated
+	// column, that is never written.
+	ReadOnly bool
+	// Default marks a column with a database default, such as DEFAULT
+	// CURRENT_TIMESTAMP, that is not written by inserts.
+	Default bool
 
 	Descriptor *fields.Descriptor
 
@@ -139,6 +166,47 @@ type Table struct {
 	ColumnsByName map[string]*Column
 
 	Scanners *sync.Pool
+
+	// base is the table a projection maps onto, or nil.
+	base *Table
+	// softDelete is the table's soft-delete column, or nil.
+	softDelete *Column
+	// relations are the table's relations by field name.
+	relations map[string]*relation
+}
+
+// versionColumn returns the table's version column, or nil.
+func (t *Table) versionColumn() *Column {
+	for _, column := range t.Columns {
+		if column.Version {
+			return column
+		}
+	}
+	return nil
+}
+
+// inserted returns whether inserts and upserts write the column.
+func (c *Column) inserted() bool {
+	return !c.ReadOnly && !c.Default
+}
+
+// serverFilledColumns returns the columns the database fills in on insert.
+func (t *Table) serverFilledColumns() []*Column {
+	var columns []*Column
+	for _, column := range t.Columns {
+		if !column.inserted() {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// baseTable returns the table a projection maps onto, or t itself.
+func (t *Table) baseTable() *Table {
+	if t.base != nil {
+		return t.base
+	}
+	return t
 }
 
 func (s *Schema) buildDescriptor(table string, primaryKeyType PrimaryKeyType, typ reflect.Type) (*Table, error) {
@@ -171,12 +239,21 @@ func (s *Schema) buildDescriptor(table string, primaryKeyType PrimaryKeyType, ty
 		}
 
 		primary := false
+		version := false
+		readOnly := false
+		hasDefault := false
 
 		if len(tags) > 1 {
 			for _, tag := range tags[1:] {
 				switch tag {
 				case "primary":
 					primary = true
+				case "version":
+					version = true
+				case "readonly":
+					readOnly = true
+				case "default":
+					hasDefault = true
 				case "binary", "json", "string":
 					// Do nothing, fields will handle these.
 				case "implicitnull":
@@ -192,6 +269,28 @@ func (s *Schema) buildDescriptor(table string, primaryKeyType PrimaryKeyType, ty
 		if _, ok := columnsByName[column]; ok {
 			return nil, fmt.Errorf("bad type %s: duplicate column %s", typ, column)
 		}
+		if version {
+			switch field.Type.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return nil, fmt.Errorf("bad type %s: version column %s must be an integer", typ, column)
+			}
+			if primary {
+				return nil, fmt.Errorf("bad type %s: version column %s cannot be part of the primary key", typ, column)
+			}
+			for _, other := range columns {
+				if other.Version {
+					return nil, fmt.Errorf("bad type %s: multiple version columns %s and %s", typ, other.Name, column)
+				}
+			}
+		}
+
+		if (readOnly || hasDefault) && (primary || version) {
+			return nil, fmt.Errorf("bad type %s: primary key and version column %s must be written", typ, column)
+		}
+		if readOnly && hasDefault {
+			return nil, fmt.Errorf("bad type %s: column %s cannot be both readonly and default", typ, column)
+		}
 
 		d := fields.New(field.Type, tags[1:])
 		if err := d.ValidateSQLType(); err != nil {
@@ -199,8 +298,11 @@ func (s *Schema) buildDescriptor(table string, primaryKeyType PrimaryKeyType, ty
 		}
 
 		descriptor := &Column{
-			Name:    column,
-			Primary: primary,
+			Name:     column,
+			Primary:  primary,
+			Version:  version,
+			ReadOnly: readOnly,
+			Default:  hasDefault,
 
 			Index: field.Index,
 			Order: len(columns),
@@ -212,17 +314,6 @@ func (s *Schema) buildDescriptor(table string, primaryKeyType PrimaryKeyType, ty
 		columnsByName[column] = descriptor
 	}
 
-	hasPrimary := false
-	for _, column := range columns {
-		if column.Primary {
-			hasPrimary = true
-			break
-		}
-	}
-	if !hasPrimary {
-		return nil, fmt.Errorf("bad type %s: no primary key specified", typ)
-	}
-
 	scanners := &sync.Pool{
 		New: func() interface{} {
 			scanners := make([]interface{}, len(columns))
@@ -265,6 +356,9 @@ func (table *Table) unbuildStruct(strct interface{}) ([]interface{}, error) {
 type Schema struct {
 	ByName map[string]*Table
 	ByType map[reflect.Type]*Table
+
+	// aggregateResults caches the descriptors of aggregate result structs.
+	aggregateResults sync.Map // reflect.Type -> *Table
 }
 
 func NewSchema() *Schema {
@@ -274,7 +368,7 @@ func NewSchema() *Schema {
 	}
 }
 
-func (s *Schema) RegisterType(table string, primaryKeyType PrimaryKeyType, value interface{}) error {
+func (s *Schema) RegisterType(table string, primaryKeyType PrimaryKeyType, value interface{}, options ...TableOption) error {
 	if _, ok := s.ByName[table]; ok {
 		return fmt.Errorf("table %s registered twice", table)
 	}
@@ -287,18 +381,85 @@ func (s *Schema) RegisterType(table string, primaryKeyType PrimaryKeyType, value
 	if err != nil {
 		return err
 	}
+	if len(descriptor.primaryKey()) == 0 {
+		return fmt.Errorf("bad type %s: no primary key specified", typ)
+	}
+	for _, option := range options {
+		if err := option.apply(descriptor); err != nil {
+			return fmt.Errorf("bad type %s: %v", typ, err)
+		}
+	}
 
 	s.ByName[table] = descriptor
 	s.ByType[typ] = descriptor
 	return nil
 }
 
-func (s *Schema) MustRegisterType(table string, primaryKeyType PrimaryKeyType, value interface{}) {
-	if err := s.RegisterType(table, primaryKeyType, value); err != nil {
+func (s *Schema) MustRegisterType(table string, primaryKeyType PrimaryKeyType, value interface{}, options ...TableOption) {
+	if err := s.RegisterType(table, primaryKeyType, value, options...); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterProjection registers a struct type holding a subset of the columns
+// of a registered table. Queries for the projection only select its columns,
+// and can filter on any of the table's columns. Projections cannot be written.
+//
+// Fields map onto columns as in RegisterType, and do not need to repeat the
+// primary tag. Projections must include all of the table's primary key
+// columns, which batched queries and testers identify rows by.
+func (s *Schema) RegisterProjection(table string, value interface{}) error {
+	base, ok := s.ByName[table]
+	if !ok {
+		return fmt.Errorf("unknown table %s", table)
+	}
+	typ := reflect.TypeOf(value)
+	if _, ok := s.ByType[typ]; ok {
+		return fmt.Errorf("type %s registered twice", typ)
+	}
+
+	descriptor, err := s.buildDescriptor(table, base.PrimaryKeyType, typ)
+	if err != nil {
+		return err
+	}
+	for _, column := range descriptor.Columns {
+		baseColumn, ok := base.ColumnsByName[column.Name]
+		if !ok {
+			return fmt.Errorf("bad type %s: column %s is not in table %s", typ, column.Name, table)
+		}
+		column.Primary = baseColumn.Primary
+	}
+	for _, name := range base.primaryKey() {
+		if _, ok := descriptor.ColumnsByName[name]; !ok {
+			return fmt.Errorf("bad type %s: missing primary key column %s", typ, name)
+		}
+	}
+	descriptor.base = base
+
+	s.ByType[typ] = descriptor
+	return nil
+}
+
+func (s *Schema) MustRegisterProjection(table string, value interface{}) {
+	if err := s.RegisterProjection(table, value); err != nil {
 		panic(err)
 	}
 }
 
+var errProjectionWrite = errors.New("projections cannot be written")
+
+// getWritable returns the table for typ, which must not be a projection.
+func (s *Schema) getWritable(typ reflect.Type) (*Table, error) {
+	table, err := s.get(typ)
+	if err != nil {
+		return nil, err
+	}
+	if table.base != nil {
+		return nil, errProjectionWrite
+	}
+	return table, nil
+}
+
 func (s *Schema) get(typ reflect.Type) (*Table, error) {
 	table, ok := s.ByType[typ]
 	if !ok {
@@ -308,14 +469,18 @@ func (s *Schema) get(typ reflect.Type) (*Table, error) {
 }
 
 func (s *Schema) ParseRows(query *SelectQuery, res *sql.Rows) ([]interface{}, error) {
-	table, ok := s.ByName[query.Table]
-	if !ok {
-		return nil, errors.New("unknown table")
+	table, columns := query.table, query.columns
+	if table == nil {
+		var ok bool
+		if table, ok = s.ByName[query.Table]; !ok {
+			return nil, errors.New("unknown table")
+		}
+		columns = table.Columns
 	}
 
 	var rows []interface{}
 	for res.Next() {
-		row, err := parseQueryRow(table, res)
+		row, err := parseQueryRow(table, columns, res)
 		if err != nil {
 			return nil, err
 		}
@@ -328,8 +493,10 @@ func (s *Schema) ParseRows(query *SelectQuery, res *sql.Rows) ([]interface{}, er
 }
 
 // whereElem is a sortable part of a WHERE clause used to build
-// deterministically-ordered WHERE clauses
+// deterministically-ordered WHERE clauses. Or conditions have no column and
+// sort after all columns by key.
 type whereElem struct {
+	key    string
 	column *Column
 	value  interface{}
 }
@@ -337,32 +504,65 @@ type whereElem struct {
 // whereElemsByIndex sorts whereElems by column order
 type whereElemsByIndex []whereElem
 
-func (l whereElemsByIndex) Len() int           { return len(l) }
-func (l whereElemsByIndex) Less(a, b int) bool { return l[a].column.Order < l[b].column.Order }
-func (l whereElemsByIndex) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
+func (l whereElemsByIndex) Len() int { return len(l) }
+func (l whereElemsByIndex) Less(a, b int) bool {
+	if l[a].column == nil || l[b].column == nil {
+		if l[a].column != nil || l[b].column != nil {
+			return l[a].column != nil
+		}
+		return l[a].key < l[b].key
+	}
+	return l[a].column.Order < l[b].column.Order
+}
+func (l whereElemsByIndex) Swap(a, b int) { l[a], l[b] = l[b], l[a] }
 
 // makeWhere builds a new SimpleWhere for table from filter
 func makeWhere(table *Table, filter Filter) (*SimpleWhere, error) {
 	var l whereElemsByIndex
 
 	for name, value := range filter {
+		if name == unscopedKey {
+			continue
+		}
+		if condition, ok := value.(*Condition); ok && condition.or() != nil {
+			bound, err := condition.bind(table, nil)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, whereElem{key: name, value: bound})
+			continue
+		}
+
 		column, ok := table.ColumnsByName[name]
 		if !ok {
 			return nil, fmt.Errorf("unknown column %s", name)
 		}
 
+		if condition, ok := value.(*Condition); ok {
+			bound, err := condition.bind(table, column)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, whereElem{key: name, column: column, value: bound})
+			continue
+		}
+
 		v, err := column.Descriptor.Valuer(reflect.ValueOf(value)).Value()
 		if err != nil {
 			return nil, fmt.Errorf("sqlgen: filter error for `%s`.`%s`: %v", table.Name, column.Name, err)
 		}
-		l = append(l, whereElem{column: column, value: v})
+		l = append(l, whereElem{key: name, column: column, value: v})
 	}
 
 	sort.Sort(l)
 	columns := []string{}
 	values := []interface{}{}
 	for _, elem := range l {
-		columns = append(columns, elem.column.Name)
+		if elem.column != nil {
+			columns = append(columns, elem.column.Name)
+		} else {
+			columns = append(columns, "")
+		}
 		values = append(values, elem.value)
 	}
 
@@ -415,8 +615,8 @@ func (s *Schema) makeCount(model interface{}, filter Filter) (*baseCountQuery, e
 	}
 
 	return &baseCountQuery{
-		Table:  table,
-		Filter: filter,
+		Table:  table.baseTable(),
+		Filter: table.baseTable().scopeFilter(filter),
 	}, nil
 }
 
@@ -424,21 +624,34 @@ type BaseSelectQuery struct {
 	Table   *Table
 	Filter  Filter
 	Options *SelectOptions
+
+	// readPrimary is set by DB.BaseQuery for batched queries that must read
+	// from the primary.
+	readPrimary bool
+
+	// scoped is set if Filter holds the soft-delete scope, which batched
+	// queries apply once to the whole batch.
+	scoped bool
 }
 
 func (b *BaseSelectQuery) MakeSelectQuery() (*SelectQuery, error) {
+	// Copy the options so that including the filter does not modify
+	// b.Options, which would make the query unbatchable if run again.
+	options := &SelectOptions{}
+	if b.Options != nil {
+		*options = *b.Options
+	}
+
+	selected, err := b.selectedColumns()
+	if err != nil {
+		return nil, err
+	}
 	var columns []string
-	for _, column := range b.Table.Columns {
+	for _, column := range selected {
 		columns = append(columns, column.Name)
 	}
 
-	options := b.Options
-	if options == nil {
-		options = &SelectOptions{}
-	}
-	// XXX: This assumes a BaseSelectQuery is only used once, as it modifies the
-	// options struct. That's true for now, but should be cleaned up.
-	if err := options.IncludeFilter(b.Table, b.Filter); err != nil {
+	if err := options.IncludeFilter(b.Table.baseTable(), b.Filter); err != nil {
 		return nil, err
 	}
 
@@ -446,9 +659,91 @@ func (b *BaseSelectQuery) MakeSelectQuery() (*SelectQuery, error) {
 		Table:   b.Table.Name,
 		Columns: columns,
 		Options: options,
+
+		table:   b.Table,
+		columns: selected,
 	}, nil
 }
 
+// selectedColumns returns the columns selected by the query, in table order.
+func (b *BaseSelectQuery) selectedColumns() ([]*Column, error) {
+	if b.Options == nil || len(b.Options.Columns) == 0 {
+		return b.Table.Columns, nil
+	}
+
+	wanted := make(map[string]bool, len(b.Options.Columns))
+	for _, name := range b.Options.Columns {
+		if _, ok := b.Table.ColumnsByName[name]; !ok {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		wanted[name] = true
+	}
+	var columns []*Column
+	for _, column := range b.Table.Columns {
+		if wanted[column.Name] {
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// batchable returns true if the query can be batched with other queries for
+// the same table and columns: batched queries are matched against returned
+// rows by equality, so the filter must only compare selected columns.
+func (b *BaseSelectQuery) batchable() bool {
+	if options := b.Options; options != nil {
+		if options.Where != "" || len(options.Values) > 0 || options.OrderBy != "" ||
+			options.Limit != 0 || options.ForUpdate || options.AllowNoIndex {
+			return false
+		}
+	}
+	filter := b.batchFilter()
+	if !isSimpleFilter(filter) {
+		return false
+	}
+
+	columns, err := b.selectedColumns()
+	if err != nil {
+		return false
+	}
+	selected := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		selected[column.Name] = true
+	}
+	for name := range filter {
+		if !selected[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// batchFilter returns the filter that batched rows are matched against, which
+// leaves out the soft-delete scope.
+func (b *BaseSelectQuery) batchFilter() Filter {
+	if !b.scoped {
+		return b.Filter
+	}
+	return b.Table.baseTable().unscope(b.Filter)
+}
+
+// batchKey groups batched queries by table, selected columns, soft-delete
+// scope and connection.
+type batchKey struct {
+	table       *Table
+	columns     string
+	scoped      bool
+	readPrimary bool
+}
+
+func (b *BaseSelectQuery) batchKey() batchKey {
+	key := batchKey{table: b.Table, scoped: b.scoped, readPrimary: b.readPrimary}
+	if b.Options != nil {
+		key.columns = strings.Join(b.Options.Columns, ",")
+	}
+	return key
+}
+
 // makeSelect builds a new BaseQuery for table with filter
 func (s *Schema) makeSelect(typ reflect.Type, filter Filter, options *SelectOptions) (*BaseSelectQuery, error) {
 	table, err := s.get(typ)
@@ -463,6 +758,18 @@ func (s *Schema) makeSelect(typ reflect.Type, filter Filter, options *SelectOpti
 	}, nil
 }
 
+// makeScopedSelect is makeSelect for a filter that does not match soft-deleted
+// rows unless it is Unscoped.
+func (s *Schema) makeScopedSelect(typ reflect.Type, filter Filter, options *SelectOptions) (*BaseSelectQuery, error) {
+	query, err := s.makeSelect(typ, filter, options)
+	if err != nil {
+		return nil, err
+	}
+	query.Filter = query.Table.baseTable().scopeFilter(filter)
+	query.scoped = query.Table.baseTable().scoped(filter)
+	return query, nil
+}
+
 var errBadQueryType = errors.New("query result should be a pointer to a slice of pointers to struct")
 
 func checkQueryTypeShape(typ reflect.Type) (reflect.Type, error) {
@@ -491,7 +798,7 @@ func (s *Schema) MakeSelect(result interface{}, filter Filter, options *SelectOp
 		return nil, err
 	}
 
-	return s.makeSelect(typ, filter, options)
+	return s.makeScopedSelect(typ, filter, options)
 }
 
 var errBadQueryRowType = errors.New("query row result should be a pointer to a pointer to a struct")
@@ -518,7 +825,7 @@ func (s *Schema) MakeSelectRow(result interface{}, filter Filter, options *Selec
 		return nil, err
 	}
 
-	return s.makeSelect(typ, filter, options)
+	return s.makeScopedSelect(typ, filter, options)
 }
 
 var errBadMutateRowType = errors.New("mutate row value should be a pointer to a struct")
@@ -541,7 +848,7 @@ func (s *Schema) MakeInsertRow(row interface{}) (*InsertQuery, error) {
 	if err != nil {
 		return nil, err
 	}
-	table, err := s.get(typ)
+	table, err := s.getWritable(typ)
 	if err != nil {
 		return nil, err
 	}
@@ -553,8 +860,9 @@ func (s *Schema) MakeInsertRow(row interface{}) (*InsertQuery, error) {
 	var columns []string
 	var values []interface{}
 
+	autoIncrement := table.autoIncrementColumn()
 	for i, column := range table.Columns {
-		if column.Primary && table.PrimaryKeyType == AutoIncrement {
+		if column == autoIncrement || !column.inserted() {
 			continue
 		}
 		columns = append(columns, column.Name)
@@ -578,7 +886,7 @@ func (s *Schema) MakeBatchInsertRow(rows []interface{}) (*BatchInsertQuery, erro
 	if err != nil {
 		return nil, err
 	}
-	table, err := s.get(typ)
+	table, err := s.getWritable(typ)
 	if err != nil {
 		return nil, err
 	}
@@ -595,9 +903,10 @@ func (s *Schema) MakeBatchInsertRow(rows []interface{}) (*BatchInsertQuery, erro
 	var columns []string
 	var values []interface{}
 
+	autoIncrement := table.autoIncrementColumn()
 	for valIndex, vals := range allValues {
 		for i, column := range table.Columns {
-			if column.Primary && table.PrimaryKeyType == AutoIncrement {
+			if column == autoIncrement || !column.inserted() {
 				continue
 			}
 			if valIndex == 0 {
@@ -616,13 +925,19 @@ func (s *Schema) MakeBatchInsertRow(rows []interface{}) (*BatchInsertQuery, erro
 
 // MakeUpsertRow builds a new UpsertQuery to upsqrt row
 func (s *Schema) MakeUpsertRow(row interface{}) (*UpsertQuery, error) {
+	return s.MakeUpsertRowWithOptions(row, nil)
+}
+
+// MakeUpsertRowWithOptions builds a new UpsertQuery to upsert row, resolving
+// conflicts with options.
+func (s *Schema) MakeUpsertRowWithOptions(row interface{}, options *UpsertOptions) (*UpsertQuery, error) {
 	ptr := reflect.ValueOf(row)
 	typ, err := checkMutateRowTypeShape(ptr.Type())
 	if err != nil {
 		return nil, err
 	}
 
-	table, err := s.get(typ)
+	table, err := s.getWritable(typ)
 	if err != nil {
 		return nil, err
 	}
@@ -636,19 +951,40 @@ func (s *Schema) MakeUpsertRow(row interface{}) (*UpsertQuery, error) {
 		return nil, err
 	}
 	var columns []string
-	for _, column := range table.Columns {
+	var insertValues []interface{}
+	for i, column := range table.Columns {
+		if !column.inserted() {
+			continue
+		}
 		columns = append(columns, column.Name)
+		insertValues = append(insertValues, values[i])
 	}
 
-	return &UpsertQuery{
-		Table:   table.Name,
-		Columns: columns,
-		Values:  values,
-	}, nil
+	set, err := table.makeUpsertSet(options)
+	if err != nil {
+		return nil, err
+	}
+	query := &UpsertQuery{
+		Table:      table.Name,
+		Columns:    columns,
+		Values:     insertValues,
+		PrimaryKey: table.primaryKey(),
+		Set:        set,
+	}
+	if options != nil {
+		query.ConflictColumns = options.ConflictColumns
+	}
+	return query, nil
 }
 
 // MakeBatchUpsertRow builds a new BatchUpsertQuery to upsert multiple rows
 func (s *Schema) MakeBatchUpsertRow(rows []interface{}) (*BatchUpsertQuery, error) {
+	return s.MakeBatchUpsertRowWithOptions(rows, nil)
+}
+
+// MakeBatchUpsertRowWithOptions builds a new BatchUpsertQuery to upsert
+// multiple rows, resolving conflicts with options.
+func (s *Schema) MakeBatchUpsertRowWithOptions(rows []interface{}, options *UpsertOptions) (*BatchUpsertQuery, error) {
 	if len(rows) == 0 {
 		return nil, errors.New("an empty list of rows given")
 	}
@@ -657,7 +993,7 @@ func (s *Schema) MakeBatchUpsertRow(rows []interface{}) (*BatchUpsertQuery, erro
 	if err != nil {
 		return nil, err
 	}
-	table, err := s.get(typ)
+	table, err := s.getWritable(typ)
 	if err != nil {
 		return nil, err
 	}
@@ -680,6 +1016,9 @@ func (s *Schema) MakeBatchUpsertRow(rows []interface{}) (*BatchUpsertQuery, erro
 
 	for valIndex, vals := range allValues {
 		for i, column := range table.Columns {
+			if !column.inserted() {
+				continue
+			}
 			if valIndex == 0 {
 				columns = append(columns, column.Name)
 			}
@@ -687,26 +1026,66 @@ func (s *Schema) MakeBatchUpsertRow(rows []interface{}) (*BatchUpsertQuery, erro
 		}
 	}
 
-	return &BatchUpsertQuery{
-		Table:   table.Name,
-		Columns: columns,
-		Values:  values,
-	}, nil
+	set, err := table.makeUpsertSet(options)
+	if err != nil {
+		return nil, err
+	}
+	query := &BatchUpsertQuery{
+		Table:      table.Name,
+		Columns:    columns,
+		Values:     values,
+		PrimaryKey: table.primaryKey(),
+		Set:        set,
+	}
+	if options != nil {
+		query.ConflictColumns = options.ConflictColumns
+	}
+	return query, nil
 }
 
-// MakeUpdateRow builds a new UpdateQuery to update row
+// MakeUpdateRow builds a new UpdateQuery to update row. If row has a version
+// column, the query only updates the row if its version is unchanged, and
+// increments the version.
 func (s *Schema) MakeUpdateRow(row interface{}) (*UpdateQuery, error) {
+	return s.makeUpdateRow(row, nil, nil)
+}
+
+// makeUpdateRow builds an UpdateQuery for row that only updates the row if it
+// also matches filter. If onlyColumns is non-nil, it only writes those
+// columns, and the version column.
+func (s *Schema) makeUpdateRow(row interface{}, filter Filter, onlyColumns []string) (*UpdateQuery, error) {
 	ptr := reflect.ValueOf(row)
 	typ, err := checkMutateRowTypeShape(ptr.Type())
 	if err != nil {
 		return nil, err
 	}
 
-	table, err := s.get(typ)
+	table, err := s.getWritable(typ)
 	if err != nil {
 		return nil, err
 	}
 
+	var only map[string]bool
+	if onlyColumns != nil {
+		if len(onlyColumns) == 0 {
+			return nil, errors.New("no columns to update")
+		}
+		only = make(map[string]bool, len(onlyColumns))
+		for _, name := range onlyColumns {
+			column, ok := table.ColumnsByName[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown column %s", name)
+			}
+			if column.Primary {
+				return nil, fmt.Errorf("cannot update primary key column %s", name)
+			}
+			if column.ReadOnly {
+				return nil, fmt.Errorf("cannot update readonly column %s", name)
+			}
+			only[name] = true
+		}
+	}
+
 	allValues, err := table.unbuildStruct(row)
 	if err != nil {
 		return nil, err
@@ -718,12 +1097,30 @@ func (s *Schema) MakeUpdateRow(row interface{}) (*UpdateQuery, error) {
 		if column.Primary {
 			whereColumns = append(whereColumns, column.Name)
 			whereValues = append(whereValues, allValues[i])
-		} else {
+		} else if column.Version {
+			// Only update the row if it has not changed since it was read, and
+			// bump its version.
+			whereColumns = append(whereColumns, column.Name)
+			whereValues = append(whereValues, allValues[i])
+			columns = append(columns, column.Name)
+			values = append(values, ptr.Elem().FieldByIndex(column.Index).Int()+1)
+		} else if column.ReadOnly {
+			continue
+		} else if only == nil || only[column.Name] {
 			columns = append(columns, column.Name)
 			values = append(values, allValues[i])
 		}
 	}
 
+	if filter != nil {
+		where, err := makeWhere(table, filter)
+		if err != nil {
+			return nil, err
+		}
+		whereColumns = append(whereColumns, where.Columns...)
+		whereValues = append(whereValues, where.Values...)
+	}
+
 	return &UpdateQuery{
 		Table:   table.Name,
 		Columns: columns,
@@ -735,14 +1132,56 @@ func (s *Schema) MakeUpdateRow(row interface{}) (*UpdateQuery, error) {
 	}, nil
 }
 
-// MakeDeleteRow builds a new DeleteQuery to delete row
+// changedColumns returns the columns that differ between before and after,
+// two copies of the same row, in table order. Primary key, version and
+// readonly columns are never reported as changed.
+func (s *Schema) changedColumns(before, after interface{}) ([]string, error) {
+	typ, err := checkMutateRowTypeShape(reflect.TypeOf(after))
+	if err != nil {
+		return nil, err
+	}
+	if reflect.TypeOf(before) != reflect.TypeOf(after) {
+		return nil, fmt.Errorf("before has type %T, but after has type %T", before, after)
+	}
+	table, err := s.getWritable(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	beforeValues, err := table.unbuildStruct(before)
+	if err != nil {
+		return nil, err
+	}
+	afterValues, err := table.unbuildStruct(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	for i, column := range table.Columns {
+		equal := driverValuesEqual(beforeValues[i], afterValues[i])
+		if column.Primary {
+			if !equal {
+				return nil, fmt.Errorf("primary key column %s differs between before and after", column.Name)
+			}
+			continue
+		}
+		if !equal && !column.Version && !column.ReadOnly {
+			columns = append(columns, column.Name)
+		}
+	}
+	return columns, nil
+}
+
+// MakeDeleteRow builds a new DeleteQuery to delete row. If row has a version
+// column, the query only deletes the row if its version is unchanged.
 func (s *Schema) MakeDeleteRow(row interface{}) (*DeleteQuery, error) {
 	ptr := reflect.ValueOf(row)
 	typ, err := checkMutateRowTypeShape(ptr.Type())
 	if err != nil {
 		return nil, err
 	}
-	table, err := s.get(typ)
+	table, err := s.getWritable(typ)
 	if err != nil {
 		return nil, err
 	}
@@ -754,7 +1193,7 @@ func (s *Schema) MakeDeleteRow(row interface{}) (*DeleteQuery, error) {
 	var columns []string
 	var values []interface{}
 	for i, column := range table.Columns {
-		if !column.Primary {
+		if !column.Primary && !column.Version {
 			continue
 		}
 
@@ -775,10 +1214,14 @@ type Tester interface {
 	Test(row interface{}) bool
 }
 
-// tester tests rows against a filter
+// tester tests rows against a filter. conditions holds the bound Condition
+// for every filter value that is one, and or holds the testers of its Or
+// filters; Or conditions have no column.
 type tester struct {
-	columns []*Column
-	values  []interface{}
+	columns    []*Column
+	values     []interface{}
+	conditions []*Condition
+	or         [][]*tester
 }
 
 // coerce coerces some types for more idiomatic comparisons
@@ -806,23 +1249,43 @@ func coerceMap(m map[string]interface{}) map[string]interface{} {
 }
 
 func (t *tester) Test(row interface{}) bool {
+	return t.test(row, false)
+}
+
+// test tests row leniently, or strictly if strict is set; see
+// Condition.testStrict.
+func (t *tester) test(row interface{}, strict bool) bool {
 	if row == nil {
 		return false
 	}
 
 	struc := reflect.ValueOf(row).Elem()
 	for i, column := range t.columns {
-		expected, err := column.Descriptor.Valuer(reflect.ValueOf(t.values[i])).Value()
+		if condition := t.conditions[i]; condition != nil && column == nil {
+			if !condition.testStrict(nil, row, t.or[i], strict) {
+				return false
+			}
+			continue
+		}
+
+		value, err := column.Descriptor.Valuer(struc.FieldByIndex(column.Index)).Value()
 		if err != nil {
 			// Ignore error.
 			return false
 		}
-		value, err := column.Descriptor.Valuer(struc.FieldByIndex(column.Index)).Value()
+
+		if condition := t.conditions[i]; condition != nil {
+			if !condition.testStrict(value, row, nil, strict) {
+				return false
+			}
+			continue
+		}
+
+		expected, err := column.Descriptor.Valuer(reflect.ValueOf(t.values[i])).Value()
 		if err != nil {
 			// Ignore error.
 			return false
 		}
-
 		if !driverValuesEqual(expected, value) {
 			return false
 		}
@@ -837,22 +1300,122 @@ func (s *Schema) MakeTester(table string, filter Filter) (Tester, error) {
 		return nil, errors.New("unknown table")
 	}
 
-	columns := []*Column{}
-	values := []interface{}{}
+	return makeTester(t, t.scopeFilter(filter))
+}
+
+// makeTester builds a tester for table from filter, binding any conditions so
+// they are converted only once.
+func makeTester(table *Table, filter Filter) (*tester, error) {
+	t := &tester{}
 
 	for name, value := range filter {
-		column, ok := t.ColumnsByName[name]
+		if name == unscopedKey {
+			continue
+		}
+		condition, isCondition := value.(*Condition)
+
+		if isCondition && condition.or() != nil {
+			var or []*tester
+			for _, f := range condition.or().filters {
+				sub, err := makeTester(table, f)
+				if err != nil {
+					return nil, err
+				}
+				or = append(or, sub)
+			}
+			t.columns = append(t.columns, nil)
+			t.values = append(t.values, value)
+			t.conditions = append(t.conditions, condition)
+			t.or = append(t.or, or)
+			continue
+		}
+
+		column, ok := table.ColumnsByName[name]
 		if !ok {
 			return nil, fmt.Errorf("unknown column %s", name)
 		}
-		columns = append(columns, column)
-		values = append(values, value)
+		if column.ReadOnly {
+			// Binlog rows may not hold the values the database computed for
+			// readonly columns, so match any value and over-invalidate.
+			continue
+		}
+
+		var bound *Condition
+		if isCondition {
+			var err error
+			if bound, err = condition.bind(table, column); err != nil {
+				return nil, err
+			}
+		}
+		t.columns = append(t.columns, column)
+		t.values = append(t.values, value)
+		t.conditions = append(t.conditions, bound)
+		t.or = append(t.or, nil)
 	}
 
-	return &tester{
-		columns: columns,
-		values:  values,
-	}, nil
+	return t, nil
+}
+
+// autoIncrementColumn returns the primary key column the database assigns on
+// insert, or nil if the table is not AutoIncrement.
+func (t *Table) autoIncrementColumn() *Column {
+	if t.PrimaryKeyType != AutoIncrement {
+		return nil
+	}
+	for _, column := range t.Columns {
+		if column.Primary {
+			return column
+		}
+	}
+	return nil
+}
+
+// insertIdColumn returns the auto-increment column if inserts can set it to
+// the assigned id, which needs a non-pointer integer field, and nil otherwise.
+func (t *Table) insertIdColumn() *Column {
+	column := t.autoIncrementColumn()
+	if column == nil || column.Descriptor.Ptr {
+		return nil
+	}
+	switch column.Descriptor.Kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return column
+	}
+	return nil
+}
+
+// setAutoIncrementIds sets the auto-increment column of rows, which were
+// inserted in order, to ids.
+func (t *Table) setAutoIncrementIds(rows []interface{}, ids []int64) error {
+	column := t.insertIdColumn()
+	if column == nil {
+		return nil
+	}
+	if len(ids) != len(rows) {
+		return fmt.Errorf("inserted %d rows but got %d ids", len(rows), len(ids))
+	}
+	for i, row := range rows {
+		field := reflect.ValueOf(row).Elem().FieldByIndex(column.Index)
+		switch field.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			field.SetUint(uint64(ids[i]))
+		default:
+			field.SetInt(ids[i])
+		}
+	}
+	return nil
+}
+
+// primaryKey returns the names of the table's primary key columns.
+func (t *Table) primaryKey() []string {
+	var columns []string
+	for _, column := range t.Columns {
+		if column.Primary {
+			columns = append(columns, column.Name)
+		}
+	}
+	return columns
 }
 
 func (t *Table) extractRow(row interface{}) Filter {
@@ -0,0 +1,258 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+// relation describes a struct field holding rows of another table.
+type relation struct {
+	index []int
+	// many is true if the field is a slice of rows, and false if it is a
+	// pointer to a single row.
+	many bool
+
+	column      *Column
+	table       string
+	tableColumn string
+}
+
+// Relation declares a relation from the registered table to rows of table,
+// attached to field by Preload. Rows are related if their tableColumn equals
+// the registered row's column. field should be tagged `sql:"-"`, and be a
+// pointer to a row for a belongs-to relation, or a slice of pointers to rows
+// for a has-many relation. For example:
+//
+//   type Order struct {
+//     Id         int64 `sql:",primary"`
+//     CustomerId int64
+//     Customer   *Customer `sql:"-"`
+//   }
+//
+//   type Customer struct {
+//     Id     int64 `sql:",primary"`
+//     Orders []*Order `sql:"-"`
+//   }
+//
+//   schema.MustRegisterType("orders", AutoIncrement, Order{},
+//     Relation("Customer", "customer_id", "customers", "id"))
+//   schema.MustRegisterType("customers", AutoIncrement, Customer{},
+//     Relation("Orders", "id", "orders", "customer_id"))
+func Relation(field string, column string, table string, tableColumn string) TableOption {
+	return tableOptionFunc(func(t *Table) error {
+		f, ok := t.Type.FieldByName(field)
+		if !ok {
+			return fmt.Errorf("unknown relation field %s", field)
+		}
+		c, ok := t.ColumnsByName[column]
+		if !ok {
+			return fmt.Errorf("unknown relation column %s", column)
+		}
+		if _, ok := t.relations[field]; ok {
+			return fmt.Errorf("relation %s declared twice", field)
+		}
+
+		r := &relation{
+			index:       f.Index,
+			column:      c,
+			table:       table,
+			tableColumn: tableColumn,
+		}
+		switch {
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Ptr:
+			r.many = true
+		case f.Type.Kind() == reflect.Ptr:
+		default:
+			return fmt.Errorf("relation field %s must be a pointer or a slice of pointers", field)
+		}
+
+		if t.relations == nil {
+			t.relations = make(map[string]*relation)
+		}
+		t.relations[field] = r
+		return nil
+	})
+}
+
+// preloadBatchSize limits the number of keys loaded by a single query, to stay
+// under the placeholder limits of MySQL and SQLite.
+const preloadBatchSize = 1000
+
+// A PreloadQuery fetches the related rows of a relation for a set of rows.
+type PreloadQuery struct {
+	// Queries select the related rows with IN queries of at most
+	// preloadBatchSize distinct keys.
+	Queries []*BaseSelectQuery
+
+	relation *relation
+	target   *Table
+	rows     []reflect.Value
+}
+
+// MakePreload builds a PreloadQuery that loads the named relation for rows, which should
+// be a slice of pointers to structs or a pointer to a struct.
+func (s *Schema) MakePreload(rows interface{}, name string) (*PreloadQuery, error) {
+	val := reflect.ValueOf(rows)
+	var elems []reflect.Value
+	switch {
+	case val.Kind() == reflect.Slice:
+		for i := 0; i < val.Len(); i++ {
+			if !val.Index(i).IsNil() {
+				elems = append(elems, val.Index(i))
+			}
+		}
+	case val.Kind() == reflect.Ptr && !val.IsNil():
+		elems = append(elems, val)
+	default:
+		return nil, fmt.Errorf("preload rows should be a slice of pointers to structs or a pointer to a struct, not %T", rows)
+	}
+
+	rowsType := val.Type()
+	if val.Kind() == reflect.Slice {
+		rowsType = rowsType.Elem()
+	}
+	typ, err := checkMutateRowTypeShape(rowsType)
+	if err != nil {
+		return nil, err
+	}
+	table, err := s.get(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	r, ok := table.relations[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown relation %s on %s", name, typ)
+	}
+	target, ok := s.ByName[r.table]
+	if !ok {
+		return nil, fmt.Errorf("relation %s references unknown table %s", name, r.table)
+	}
+	if _, ok := target.ColumnsByName[r.tableColumn]; !ok {
+		return nil, fmt.Errorf("relation %s references unknown column %s.%s", name, r.table, r.tableColumn)
+	}
+	rowType := reflect.PtrTo(target.Type)
+	if field, _ := typ.FieldByName(name); (r.many && field.Type.Elem() != rowType) || (!r.many && field.Type != rowType) {
+		return nil, fmt.Errorf("relation field %s cannot hold rows of type %s", name, rowType)
+	}
+
+	query := &PreloadQuery{
+		relation: r,
+		target:   target,
+		rows:     elems,
+	}
+
+	// Collect the distinct keys of all rows. Keys are compared as driver
+	// values, but filtered on as field values so the table column can convert
+	// them.
+	seen := make(map[interface{}]bool)
+	var keys []interface{}
+	for _, elem := range elems {
+		field := elem.Elem().FieldByIndex(r.column.Index)
+		value, err := r.column.Descriptor.Valuer(field).Value()
+		if err != nil {
+			return nil, err
+		}
+		if value == nil || seen[relationKey(value)] {
+			continue
+		}
+		seen[relationKey(value)] = true
+		keys = append(keys, reflect.Indirect(field).Interface())
+	}
+
+	for start := 0; start < len(keys); start += preloadBatchSize {
+		end := start + preloadBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		selectQuery, err := s.makeScopedSelect(target.Type, Filter{r.tableColumn: In(keys[start:end]...)}, nil)
+		if err != nil {
+			return nil, err
+		}
+		query.Queries = append(query.Queries, selectQuery)
+	}
+	return query, nil
+}
+
+// Load runs Queries with query and attaches the related rows.
+func (q *PreloadQuery) Load(ctx context.Context, query func(context.Context, *BaseSelectQuery) ([]interface{}, error)) error {
+	var related []interface{}
+	for _, selectQuery := range q.Queries {
+		rows, err := query(ctx, selectQuery)
+		if err != nil {
+			return err
+		}
+		related = append(related, rows...)
+	}
+	return q.Attach(related)
+}
+
+// Attach sets the relation's field on every row to the matching rows in
+// related, which should be the result of Query.
+func (q *PreloadQuery) Attach(related []interface{}) error {
+	column := q.target.ColumnsByName[q.relation.tableColumn]
+
+	byKey := make(map[interface{}][]reflect.Value)
+	for _, row := range related {
+		val := reflect.ValueOf(row)
+		value, err := column.Descriptor.Valuer(val.Elem().FieldByIndex(column.Index)).Value()
+		if err != nil {
+			return err
+		}
+		key := relationKey(value)
+		byKey[key] = append(byKey[key], val)
+	}
+
+	for _, elem := range q.rows {
+		value, err := q.relation.column.Descriptor.Valuer(elem.Elem().FieldByIndex(q.relation.column.Index)).Value()
+		if err != nil {
+			return err
+		}
+		field := elem.Elem().FieldByIndex(q.relation.index)
+		field.Set(reflect.Zero(field.Type()))
+		if value == nil {
+			continue
+		}
+
+		matches := byKey[relationKey(value)]
+		if q.relation.many {
+			if len(matches) > 0 {
+				field.Set(reflect.Append(field, matches...))
+			}
+		} else if len(matches) > 0 {
+			field.Set(matches[0])
+		}
+	}
+	return nil
+}
+
+// relationKey converts a driver.Value into a map key.
+func relationKey(value driver.Value) interface{} {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value
+}
+
+// Preload loads the named relations of rows, which should be a slice of
+// pointers to structs or a pointer to a struct, and attaches them to the rows'
+// relation fields. The related rows of each relation are loaded with IN
+// queries of at most 1000 keys. For example:
+//
+//   var orders []*Order
+//   if err := db.Query(ctx, &orders, Filter{"state": "open"}, nil); err != nil { ... }
+//   if err := db.Preload(ctx, orders, "Customer"); err != nil { ... }
+func (db *DB) Preload(ctx context.Context, rows interface{}, relations ...string) error {
+	for _, relation := range relations {
+		query, err := db.Schema.MakePreload(rows, relation)
+		if err != nil {
+			return err
+		}
+		if err := query.Load(ctx, db.BaseQuery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
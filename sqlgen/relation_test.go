@@ -0,0 +1,159 @@
+package sqlgen
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Customer struct {
+	Id     int64 `sql:",primary"`
+	Name   string
+	Orders []*Order `sql:"-"`
+}
+
+type Order struct {
+	Id         int64 `sql:",primary"`
+	CustomerId *int64
+	Item       string
+	Customer   *Customer `sql:"-"`
+}
+
+func setupRelations(t *testing.T) (*testfixtures.TestDatabase, *DB) {
+	schema := NewSchema()
+	schema.MustRegisterType("customers", AutoIncrement, Customer{},
+		Relation("Orders", "id", "orders", "customer_id"))
+	schema.MustRegisterType("orders", AutoIncrement, Order{},
+		Relation("Customer", "customer_id", "customers", "id"))
+
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	return testDb, NewDBWithDialect(testDb.DB, schema, SQLite)
+}
+
+func TestRelationRegistration(t *testing.T) {
+	s := NewSchema()
+	assert.Error(t, s.RegisterType("orders", AutoIncrement, Order{}, Relation("Missing", "customer_id", "customers", "id")))
+	assert.Error(t, s.RegisterType("orders", AutoIncrement, Order{}, Relation("Customer", "missing", "customers", "id")))
+	assert.Error(t, s.RegisterType("orders", AutoIncrement, Order{}, Relation("Item", "customer_id", "customers", "id")))
+	require.NoError(t, s.RegisterType("orders", AutoIncrement, Order{}, Relation("Customer", "customer_id", "customers", "id")))
+
+	// The related table is resolved when preloading.
+	_, err := s.MakePreload([]*Order{{Id: 1}}, "Customer")
+	assert.Error(t, err)
+	s.MustRegisterType("customers", AutoIncrement, Customer{})
+	_, err = s.MakePreload([]*Order{{Id: 1}}, "Unknown")
+	assert.Error(t, err)
+
+	one, two := int64(1), int64(2)
+	query, err := s.MakePreload([]*Order{{Id: 1, CustomerId: &one}, {Id: 2, CustomerId: &two}, {Id: 3, CustomerId: &one}, {Id: 4}}, "Customer")
+	require.NoError(t, err)
+	require.Len(t, query.Queries, 1)
+	selectQuery, err := query.Queries[0].MakeSelectQuery()
+	require.NoError(t, err)
+	clause, args := selectQuery.ToSQL()
+	assert.Equal(t, "SELECT id, name FROM customers WHERE id IN (?, ?)", clause)
+	assert.Equal(t, []interface{}{int64(1), int64(2)}, args)
+
+	query, err = s.MakePreload([]*Order{{Id: 4}}, "Customer")
+	require.NoError(t, err)
+	assert.Empty(t, query.Queries)
+}
+
+func TestSQLitePreloadBatches(t *testing.T) {
+	testDb, db := setupRelations(t)
+	defer testDb.Close()
+	hook := &recordingHook{}
+	db, err := db.WithQueryHook(hook)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Load more keys than fit in a single query.
+	n := preloadBatchSize + 10
+	customers := make([]*Customer, n)
+	orders := make([]*Order, n)
+	for i := range customers {
+		customers[i] = &Customer{Name: fmt.Sprint(i)}
+		id := int64(i + 1)
+		orders[i] = &Order{CustomerId: &id}
+	}
+	require.NoError(t, db.InsertRows(ctx, customers, 100))
+
+	// Keys are loaded with IN queries of at most preloadBatchSize keys, in and
+	// out of transactions.
+	for _, inTx := range []bool{false, true} {
+		for _, order := range orders {
+			order.Customer = nil
+		}
+		hook.before = nil
+		preload := func(ctx context.Context) error {
+			return db.Preload(ctx, orders, "Customer")
+		}
+		if inTx {
+			require.NoError(t, db.RunInTx(ctx, preload))
+		} else {
+			require.NoError(t, preload(ctx))
+		}
+		for i, order := range orders {
+			require.NotNil(t, order.Customer)
+			assert.Equal(t, fmt.Sprint(i), order.Customer.Name)
+		}
+		var queries int
+		for _, event := range hook.before {
+			if event.Operation == "Query" {
+				assert.False(t, event.Batched)
+				queries++
+			}
+		}
+		assert.Equal(t, 2, queries)
+	}
+}
+
+func TestSQLitePreload(t *testing.T) {
+	testDb, db := setupRelations(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*Customer{{Name: "Alice"}, {Name: "Bob"}, {Name: "Carol"}}, 10))
+	one, two := int64(1), int64(2)
+	require.NoError(t, db.InsertRows(ctx, []*Order{
+		{CustomerId: &one, Item: "owl"},
+		{CustomerId: &two, Item: "hawk"},
+		{CustomerId: &one, Item: "eagle"},
+		{Item: "crow"},
+	}, 10))
+
+	var orders []*Order
+	require.NoError(t, db.Query(ctx, &orders, nil, &SelectOptions{OrderBy: "id"}))
+	require.NoError(t, db.Preload(ctx, orders, "Customer"))
+	require.Len(t, orders, 4)
+	assert.Equal(t, "Alice", orders[0].Customer.Name)
+	assert.Equal(t, "Bob", orders[1].Customer.Name)
+	assert.Same(t, orders[0].Customer, orders[2].Customer)
+	assert.Nil(t, orders[3].Customer)
+
+	var customers []*Customer
+	require.NoError(t, db.Query(ctx, &customers, nil, &SelectOptions{OrderBy: "id"}))
+	require.NoError(t, db.Preload(ctx, customers, "Orders"))
+	require.Len(t, customers, 3)
+	var items []string
+	for _, order := range customers[0].Orders {
+		items = append(items, order.Item)
+	}
+	assert.ElementsMatch(t, []string{"owl", "eagle"}, items)
+	assert.Len(t, customers[1].Orders, 1)
+	assert.Nil(t, customers[2].Orders)
+
+	order := &Order{Id: 2, CustomerId: &two}
+	require.NoError(t, db.Preload(ctx, order, "Customer"))
+	assert.Equal(t, "Bob", order.Customer.Name)
+
+	assert.Error(t, db.Preload(ctx, orders, "Orders"))
+	assert.Error(t, db.Preload(ctx, []Order{}, "Customer"))
+}
@@ -0,0 +1,129 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A ReplicaHealthCheck returns whether a replica can serve reads, for example
+// whether its replication lag is acceptable. It is called before every read
+// routed to the replica, so it should be cheap.
+type ReplicaHealthCheck func(ctx context.Context, replica *sql.DB) bool
+
+// ReplicaOptions configures WithReplicas.
+type ReplicaOptions struct {
+	// HealthCheck, if set, skips replicas that are not healthy. If no replica
+	// is healthy, reads go to the primary.
+	HealthCheck ReplicaHealthCheck
+	// ReadYourWritesWindow is how long reads in a context marked with
+	// WithReadYourWrites go to the primary after a write. Zero means until the
+	// context is done.
+	ReadYourWritesWindow time.Duration
+}
+
+// replicas are the read replicas of a DB.
+type replicas struct {
+	conns   []*sql.DB
+	options ReplicaOptions
+	next    uint32
+}
+
+// WithReplicas returns a copy of the DB that sends reads to replicas, in
+// turn, and writes to its primary connection. Reads go to the primary if the
+// context holds a transaction, was marked with WithPrimary, or was marked with
+// WithReadYourWrites and has written recently. Selects with ForUpdate always go
+// to the primary.
+func (db *DB) WithReplicas(conns []*sql.DB, options ReplicaOptions) (*DB, error) {
+	if db.replicas != nil {
+		return nil, errors.New("already has replicas")
+	}
+	if len(conns) == 0 {
+		return nil, errors.New("no replicas")
+	}
+
+	dbCopy := db.clone()
+	dbCopy.replicas = &replicas{conns: conns, options: options}
+	return dbCopy, nil
+}
+
+// primaryReadKey is a key for a context.Context that reads from the primary.
+type primaryReadKey struct{}
+
+// WithPrimary returns a derived Context whose reads go to the primary, for
+// reads that must be consistent with the latest writes.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadKey{}, true)
+}
+
+// writeMarkerKey is a key for a context.Context holding a *writeMarker.
+type writeMarkerKey struct{}
+
+// writeMarker records the last write made with a context.
+type writeMarker struct {
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+// WithReadYourWrites returns a derived Context that tracks writes, so reads
+// with the context after a write go to the primary and observe it. Mark a
+// context once per request or job, before its first write.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeMarkerKey{}, &writeMarker{})
+}
+
+// markWrite records a write made with ctx.
+func markWrite(ctx context.Context) {
+	if marker, ok := ctx.Value(writeMarkerKey{}).(*writeMarker); ok {
+		marker.mu.Lock()
+		marker.lastWrite = time.Now()
+		marker.mu.Unlock()
+	}
+}
+
+// wroteWithin returns whether ctx was used to write within window, or at all
+// if window is zero.
+func wroteWithin(ctx context.Context, window time.Duration) bool {
+	marker, ok := ctx.Value(writeMarkerKey{}).(*writeMarker)
+	if !ok {
+		return false
+	}
+	marker.mu.Lock()
+	defer marker.mu.Unlock()
+	if marker.lastWrite.IsZero() {
+		return false
+	}
+	return window == 0 || time.Since(marker.lastWrite) < window
+}
+
+// readsFromPrimary returns whether a read with ctx must go to the primary.
+func (db *DB) readsFromPrimary(ctx context.Context, forUpdate bool) bool {
+	if db.replicas == nil || forUpdate || db.HasTx(ctx) {
+		return true
+	}
+	if primary, _ := ctx.Value(primaryReadKey{}).(bool); primary {
+		return true
+	}
+	return wroteWithin(ctx, db.replicas.options.ReadYourWritesWindow)
+}
+
+// reader returns the QueryExecer to read with: the context's transaction, the
+// primary, or a healthy replica.
+func (db *DB) reader(ctx context.Context, primary bool) QueryExecer {
+	if primary {
+		return db.QueryExecer(ctx)
+	}
+
+	r := db.replicas
+	start := atomic.AddUint32(&r.next, 1)
+	for i := range r.conns {
+		conn := r.conns[int((start+uint32(i))%uint32(len(r.conns)))]
+		if r.options.HealthCheck == nil || r.options.HealthCheck(ctx, conn) {
+			return conn
+		}
+	}
+	return db.Conn
+}
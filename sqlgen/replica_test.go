@@ -0,0 +1,90 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteReplicas(t *testing.T) {
+	testDb, primaryDb := setupSQLite(t)
+	defer testDb.Close()
+	statements, err := primaryDb.Schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	replicaDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer replicaDb.Close()
+	ctx := context.Background()
+
+	// The primary and replica hold different owls, to tell them apart.
+	require.NoError(t, primaryDb.InsertRows(ctx, []*Owl{{Species: "Tyto alba", Genus: "Tyto"}}, 10))
+	require.NoError(t, NewDBWithDialect(replicaDb.DB, primaryDb.Schema, SQLite).InsertRows(ctx, []*Owl{{Species: "Strix varia", Genus: "Strix"}}, 10))
+
+	healthy := true
+	db, err := primaryDb.WithReplicas([]*sql.DB{replicaDb.DB}, ReplicaOptions{
+		HealthCheck: func(ctx context.Context, replica *sql.DB) bool { return healthy },
+	})
+	require.NoError(t, err)
+	_, err = db.WithReplicas([]*sql.DB{replicaDb.DB}, ReplicaOptions{})
+	assert.Error(t, err)
+	_, err = primaryDb.WithReplicas(nil, ReplicaOptions{})
+	assert.Error(t, err)
+
+	genus := func(ctx context.Context, db *DB, options *SelectOptions) string {
+		var owls []*Owl
+		require.NoError(t, db.Query(ctx, &owls, nil, options))
+		require.Len(t, owls, 1)
+		return owls[0].Genus
+	}
+
+	assert.Equal(t, "Strix", genus(ctx, db, nil))
+	assert.Equal(t, "Tyto", genus(WithPrimary(ctx), db, nil))
+	assert.Equal(t, "Tyto", genus(ctx, db, &SelectOptions{ForUpdate: true}))
+	assert.Equal(t, "Tyto", genus(ctx, primaryDb, nil))
+
+	txCtx, tx, err := db.WithTx(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Tyto", genus(txCtx, db, nil))
+	require.NoError(t, tx.Rollback())
+
+	healthy = false
+	assert.Equal(t, "Tyto", genus(ctx, db, nil))
+	healthy = true
+
+	var genera []string
+	require.NoError(t, db.Iterate(ctx, &Owl{}, nil, nil, func(row interface{}) error {
+		genera = append(genera, row.(*Owl).Genus)
+		return nil
+	}))
+	assert.Equal(t, []string{"Strix"}, genera)
+
+	count, err := db.Count(ctx, &Owl{}, Filter{"genus": "Strix"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	batchCtx := batch.WithBatching(ctx)
+	var owls []*Owl
+	require.NoError(t, db.Query(batchCtx, &owls, Filter{"genus": "Strix"}, nil))
+	assert.Len(t, owls, 1)
+	require.NoError(t, db.Query(WithPrimary(batchCtx), &owls, Filter{"genus": "Strix"}, nil))
+	assert.Len(t, owls, 0)
+
+	// Reads after a write go to the primary.
+	writeCtx := WithReadYourWrites(ctx)
+	assert.Equal(t, "Strix", genus(writeCtx, db, nil))
+	_, err = db.InsertRow(writeCtx, &User{Name: "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Tyto", genus(writeCtx, db, nil))
+	assert.Equal(t, "Strix", genus(WithReadYourWrites(ctx), db, nil))
+
+	windowDb, err := primaryDb.WithReplicas([]*sql.DB{replicaDb.DB}, ReplicaOptions{ReadYourWritesWindow: time.Nanosecond})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, "Strix", genus(writeCtx, windowDb, nil))
+}
@@ -0,0 +1,511 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// A ShardFunc returns the index of the shard that holds the rows whose shard
+// key is value. value is the driver value of the shard key column, such as an
+// int64 or a string.
+type ShardFunc func(value interface{}) (int, error)
+
+// HashShard returns a ShardFunc that spreads shard key values over count
+// shards by their hash. It panics if count is not positive.
+func HashShard(count int) ShardFunc {
+	if count <= 0 {
+		panic(fmt.Sprintf("sqlgen: HashShard count %d must be positive", count))
+	}
+	return func(value interface{}) (int, error) {
+		h := fnv.New32a()
+		switch value := value.(type) {
+		case []byte:
+			h.Write(value)
+		case string:
+			h.Write([]byte(value))
+		default:
+			fmt.Fprint(h, value)
+		}
+		return int(h.Sum32() % uint32(count)), nil
+	}
+}
+
+// ShardOptions configures NewShardedDB.
+type ShardOptions struct {
+	// Key is the shard key column. Every table used with the ShardedDB must
+	// have it.
+	Key string
+	// Shard chooses the shard of a shard key value.
+	Shard ShardFunc
+	// AllowScatter runs queries that do not filter on Key on every shard, and
+	// gathers their results. Otherwise, such queries fail with ErrCrossShard.
+	AllowScatter bool
+}
+
+// ErrCrossShard is returned by a ShardedDB for a query that does not filter
+// on the shard key, if scattering queries is not allowed.
+var ErrCrossShard = errors.New("sqlgen: query does not filter on the shard key")
+
+// A ShardedDB routes queries and writes to one of several DBs, the shards,
+// by the value of a shard key column. Queries that filter on the shard key
+// with In run on the shards of the values, and gather their results.
+//
+// Writes of several rows, such as InsertRows, are split by shard, and are not
+// atomic across shards. For transactions, use ShardFor to get the DB of a
+// shard.
+//
+// Writes are routed by the shard key of the row, so they cannot change the
+// shard key of an existing row: UpdateRow and UpsertRow would write the row to
+// the shard of its new key. To move a row, delete it and insert it again.
+type ShardedDB struct {
+	Schema *Schema
+
+	// shards run the routed queries, and limited are the same shards with a
+	// shard key limit, as returned by Shards and ShardFor.
+	shards  []*DB
+	limited []*DB
+	options ShardOptions
+}
+
+// NewShardedDB creates a ShardedDB over shards, which must have the same
+// Schema and different connections.
+func NewShardedDB(shards []*DB, options ShardOptions) (*ShardedDB, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	if options.Key == "" || options.Shard == nil {
+		return nil, errors.New("shard options need a key and a shard func")
+	}
+
+	conns := make(map[*sql.DB]int, len(shards))
+	copies := make([]*DB, len(shards))
+	limited := make([]*DB, len(shards))
+	for i, shard := range shards {
+		if shard.Schema != shards[0].Schema {
+			return nil, fmt.Errorf("shard %d has a different schema", i)
+		}
+		if j, ok := conns[shard.Conn]; ok {
+			return nil, fmt.Errorf("shards %d and %d share a connection", j, i)
+		}
+		conns[shard.Conn] = i
+
+		// Give every shard its own batchFetch, so queries are batched by
+		// physical shard.
+		copies[i] = shard.clone()
+
+		// The limited copy shares the batchFetch of the shard.
+		limitedCopy := *copies[i]
+		limitedCopy.shardKey = &shardKeyLimit{options: options, shard: i}
+		limited[i] = &limitedCopy
+	}
+
+	return &ShardedDB{
+		Schema:  shards[0].Schema,
+		shards:  copies,
+		limited: limited,
+		options: options,
+	}, nil
+}
+
+// Shards returns the DBs of the shards, in order. Their queries must filter
+// on shard key values of the shard, with equality or In, and their writes must
+// not write rows with the shard key of another shard.
+func (s *ShardedDB) Shards() []*DB {
+	return s.limited
+}
+
+// ShardFor returns the DB of the shard that holds rows with shard key value.
+// Like the DBs of Shards, it checks the shard key of queries and writes.
+func (s *ShardedDB) ShardFor(value interface{}) (*DB, error) {
+	i, err := s.shardIndex(value)
+	if err != nil {
+		return nil, err
+	}
+	return s.limited[i], nil
+}
+
+// shardIndex returns the index of the shard that holds rows with shard key
+// value.
+func (s *ShardedDB) shardIndex(value interface{}) (int, error) {
+	i, err := s.options.Shard(value)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i >= len(s.shards) {
+		return 0, fmt.Errorf("shard func returned shard %d, but there are %d shards", i, len(s.shards))
+	}
+	return i, nil
+}
+
+// shardKeyLimit limits the DB of a shard to rows with a shard key of that
+// shard.
+type shardKeyLimit struct {
+	options ShardOptions
+	shard   int
+}
+
+// checkValue checks that the shard key driver value v belongs to the shard.
+func (l *shardKeyLimit) checkValue(v interface{}) error {
+	i, err := l.options.Shard(v)
+	if err != nil {
+		return err
+	}
+	if i != l.shard {
+		return fmt.Errorf("db is shard %d, but %s = %v is on shard %d", l.shard, l.options.Key, v, i)
+	}
+	return nil
+}
+
+// checkFilter checks that filter limits the shard key of table to values of
+// the shard.
+func (l *shardKeyLimit) checkFilter(table *Table, filter Filter) error {
+	column, ok := table.ColumnsByName[l.options.Key]
+	if !ok {
+		return fmt.Errorf("table %s has no shard key column %s", table.Name, l.options.Key)
+	}
+	value, ok := filter[l.options.Key]
+	if !ok {
+		return fmt.Errorf("db requires a filter on %s, but query does not filter on %s", l.options.Key, l.options.Key)
+	}
+
+	values := []interface{}{value}
+	if condition, ok := value.(*Condition); ok {
+		if condition.op != opIn {
+			return fmt.Errorf("db requires %s to be equal or In, but query has another condition on %s", l.options.Key, l.options.Key)
+		}
+		values = condition.values
+	}
+	for _, value := range values {
+		v, err := column.Descriptor.Valuer(reflect.ValueOf(value)).Value()
+		if err != nil {
+			return fmt.Errorf("sqlgen: shard key error for `%s`.`%s`: %v", table.Name, column.Name, err)
+		}
+		if err := l.checkValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColumnValues checks that a write of columns with driver values does
+// not write a shard key of another shard. Writes by primary key that leave
+// out the shard key can only affect rows of the shard, and are allowed.
+func (l *shardKeyLimit) checkColumnValues(columns []string, values []interface{}) error {
+	for i, column := range columns {
+		if column == l.options.Key {
+			return l.checkValue(values[i])
+		}
+	}
+	return nil
+}
+
+// keyColumn returns table's shard key column.
+func (s *ShardedDB) keyColumn(table *Table) (*Column, error) {
+	column, ok := table.ColumnsByName[s.options.Key]
+	if !ok {
+		return nil, fmt.Errorf("table %s has no shard key column %s", table.Name, s.options.Key)
+	}
+	return column, nil
+}
+
+// shardValue returns the shard of a shard key value, converted to its driver
+// value.
+func (s *ShardedDB) shardValue(table *Table, column *Column, value interface{}) (*DB, error) {
+	v, err := column.Descriptor.Valuer(reflect.ValueOf(value)).Value()
+	if err != nil {
+		return nil, fmt.Errorf("sqlgen: shard key error for `%s`.`%s`: %v", table.Name, column.Name, err)
+	}
+	i, err := s.shardIndex(v)
+	if err != nil {
+		return nil, err
+	}
+	return s.shards[i], nil
+}
+
+// shardsForFilter returns the shards that hold the rows of table that match
+// filter.
+func (s *ShardedDB) shardsForFilter(table *Table, filter Filter) ([]*DB, error) {
+	column, err := s.keyColumn(table)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := filter[s.options.Key]
+	condition, isCondition := value.(*Condition)
+	if !ok || (isCondition && condition.op != opIn) {
+		if !s.options.AllowScatter {
+			return nil, ErrCrossShard
+		}
+		return s.shards, nil
+	}
+	if !isCondition {
+		shard, err := s.shardValue(table, column, value)
+		if err != nil {
+			return nil, err
+		}
+		return []*DB{shard}, nil
+	}
+
+	var shards []*DB
+	seen := make(map[*DB]bool)
+	for _, value := range condition.values {
+		shard, err := s.shardValue(table, column, value)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[shard] {
+			seen[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+	return shards, nil
+}
+
+// shardForRow returns the shard that holds row.
+func (s *ShardedDB) shardForRow(row interface{}) (*DB, error) {
+	typ, err := checkMutateRowTypeShape(reflect.TypeOf(row))
+	if err != nil {
+		return nil, err
+	}
+	table, err := s.Schema.getWritable(typ)
+	if err != nil {
+		return nil, err
+	}
+	column, err := s.keyColumn(table)
+	if err != nil {
+		return nil, err
+	}
+	return s.shardValue(table, column, reflect.ValueOf(row).Elem().FieldByIndex(column.Index).Interface())
+}
+
+// gather runs query on every shard in shards, and returns all their rows.
+func gather(ctx context.Context, shards []*DB, query func(ctx context.Context, shard *DB) ([]interface{}, error)) ([]interface{}, error) {
+	if len(shards) == 1 {
+		return query(ctx, shards[0])
+	}
+
+	results := make([][]interface{}, len(shards))
+	g, groupCtx := errgroup.WithContext(ctx)
+	for i, shard := range shards {
+		i, shard := i, shard
+		g.Go(func() error {
+			rows, err := query(groupCtx, shard)
+			results[i] = rows
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var rows []interface{}
+	for _, result := range results {
+		rows = append(rows, result...)
+	}
+	return rows, nil
+}
+
+// baseQuery runs the select built by makeSelect on the shards that hold the
+// rows it matches.
+func (s *ShardedDB) baseQuery(ctx context.Context, filter Filter, options *SelectOptions, makeSelect func(options *SelectOptions) (*BaseSelectQuery, error)) ([]interface{}, error) {
+	query, err := makeSelect(copySelectOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	shards, err := s.shardsForFilter(query.Table, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) > 1 && options != nil && (options.OrderBy != "" || options.Limit != 0) {
+		return nil, errors.New("sqlgen: cross-shard queries cannot use OrderBy or Limit")
+	}
+
+	return gather(ctx, shards, func(ctx context.Context, shard *DB) ([]interface{}, error) {
+		// Building a select adds the filter to its options, so build one
+		// for every shard.
+		query, err := makeSelect(copySelectOptions(options))
+		if err != nil {
+			return nil, err
+		}
+		return shard.BaseQuery(ctx, query)
+	})
+}
+
+// copySelectOptions returns a copy of options, or nil.
+func copySelectOptions(options *SelectOptions) *SelectOptions {
+	if options == nil {
+		return nil
+	}
+	optionsCopy := *options
+	return &optionsCopy
+}
+
+// Query fetches a collection of rows from the shards that hold rows matching
+// filter. See DB.Query. Queries over several shards cannot use OrderBy or
+// Limit, and return the rows of each shard in turn.
+func (s *ShardedDB) Query(ctx context.Context, result interface{}, filter Filter, options *SelectOptions) error {
+	rows, err := s.baseQuery(ctx, filter, options, func(options *SelectOptions) (*BaseSelectQuery, error) {
+		return s.Schema.MakeSelect(result, filter, options)
+	})
+	if err != nil {
+		return err
+	}
+	return CopySlice(result, rows)
+}
+
+// QueryRow fetches a single row from the shards that hold rows matching
+// filter. See DB.QueryRow.
+func (s *ShardedDB) QueryRow(ctx context.Context, result interface{}, filter Filter, options *SelectOptions) error {
+	rows, err := s.baseQuery(ctx, filter, options, func(options *SelectOptions) (*BaseSelectQuery, error) {
+		return s.Schema.MakeSelectRow(result, filter, options)
+	})
+	if err != nil {
+		return err
+	}
+	return CopySingletonSlice(result, rows)
+}
+
+// Count counts the rows matching filter on the shards that hold them. See
+// DB.Count.
+func (s *ShardedDB) Count(ctx context.Context, model interface{}, filter Filter) (int64, error) {
+	query, err := s.Schema.makeCount(model, filter)
+	if err != nil {
+		return 0, err
+	}
+	shards, err := s.shardsForFilter(query.Table, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	counts, err := gather(ctx, shards, func(ctx context.Context, shard *DB) ([]interface{}, error) {
+		count, err := shard.Count(ctx, model, filter)
+		return []interface{}{count}, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, count := range counts {
+		total += count.(int64)
+	}
+	return total, nil
+}
+
+// InsertRow inserts row into its shard. See DB.InsertRow.
+func (s *ShardedDB) InsertRow(ctx context.Context, row interface{}) (sql.Result, error) {
+	shard, err := s.shardForRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return shard.InsertRow(ctx, row)
+}
+
+// UpsertRow upserts row into the shard of its shard key. See DB.UpsertRow. It
+// cannot change the shard key of an existing row.
+func (s *ShardedDB) UpsertRow(ctx context.Context, row interface{}) (sql.Result, error) {
+	return s.UpsertRowWithOptions(ctx, row, nil)
+}
+
+// UpsertRowWithOptions upserts row into its shard. See
+// DB.UpsertRowWithOptions.
+func (s *ShardedDB) UpsertRowWithOptions(ctx context.Context, row interface{}, options *UpsertOptions) (sql.Result, error) {
+	shard, err := s.shardForRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return shard.UpsertRowWithOptions(ctx, row, options)
+}
+
+// UpdateRow updates row in the shard of its shard key. See DB.UpdateRow. It
+// cannot change the shard key of the row.
+func (s *ShardedDB) UpdateRow(ctx context.Context, row interface{}) error {
+	shard, err := s.shardForRow(row)
+	if err != nil {
+		return err
+	}
+	return shard.UpdateRow(ctx, row)
+}
+
+// DeleteRow deletes row from its shard. See DB.DeleteRow.
+func (s *ShardedDB) DeleteRow(ctx context.Context, row interface{}) error {
+	shard, err := s.shardForRow(row)
+	if err != nil {
+		return err
+	}
+	return shard.DeleteRow(ctx, row)
+}
+
+// splitRows splits rows, a slice of pointers to structs, by shard. It
+// returns slices of the same type as rows, in shard order.
+func (s *ShardedDB) splitRows(rows interface{}) ([]*DB, []interface{}, error) {
+	val := reflect.ValueOf(rows)
+	if kind := val.Kind(); kind != reflect.Slice && kind != reflect.Array {
+		return nil, nil, fmt.Errorf("expect array/slice got %s", kind.String())
+	}
+
+	byShard := make(map[*DB]reflect.Value)
+	for i := 0; i < val.Len(); i++ {
+		row := val.Index(i)
+		shard, err := s.shardForRow(row.Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+		split, ok := byShard[shard]
+		if !ok {
+			split = reflect.MakeSlice(reflect.SliceOf(val.Type().Elem()), 0, 0)
+		}
+		byShard[shard] = reflect.Append(split, row)
+	}
+
+	var shards []*DB
+	var split []interface{}
+	for _, shard := range s.shards {
+		if rows, ok := byShard[shard]; ok {
+			shards = append(shards, shard)
+			split = append(split, rows.Interface())
+		}
+	}
+	return shards, split, nil
+}
+
+// InsertRows inserts rows into their shards, chunkSize rows at a time. See
+// DB.InsertRows.
+func (s *ShardedDB) InsertRows(ctx context.Context, rows interface{}, chunkSize int) error {
+	shards, split, err := s.splitRows(rows)
+	if err != nil {
+		return err
+	}
+	for i, shard := range shards {
+		if err := shard.InsertRows(ctx, split[i], chunkSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertRows upserts rows into their shards, chunkSize rows at a time. See
+// DB.UpsertRows.
+func (s *ShardedDB) UpsertRows(ctx context.Context, rows interface{}, chunkSize int) error {
+	return s.UpsertRowsWithOptions(ctx, rows, chunkSize, nil)
+}
+
+// UpsertRowsWithOptions upserts rows into their shards, chunkSize rows at a
+// time. See DB.UpsertRowsWithOptions.
+func (s *ShardedDB) UpsertRowsWithOptions(ctx context.Context, rows interface{}, chunkSize int, options *UpsertOptions) error {
+	shards, split, err := s.splitRows(rows)
+	if err != nil {
+		return err
+	}
+	for i, shard := range shards {
+		if err := shard.UpsertRowsWithOptions(ctx, split[i], chunkSize, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
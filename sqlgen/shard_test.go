@@ -0,0 +1,215 @@
+package sqlgen
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Ticket struct {
+	Id    int64 `sql:",primary"`
+	OrgId int64
+	Body  string
+}
+
+func TestHashShard(t *testing.T) {
+	shard := HashShard(4)
+	for _, value := range []interface{}{int64(10), "org", []byte("org")} {
+		first, err := shard(value)
+		require.NoError(t, err)
+		second, err := shard(value)
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+		assert.True(t, first >= 0 && first < 4)
+	}
+	byString, _ := shard("org")
+	byBytes, _ := shard([]byte("org"))
+	assert.Equal(t, byString, byBytes)
+
+	assert.Panics(t, func() { HashShard(0) })
+	assert.Panics(t, func() { HashShard(-1) })
+}
+
+func setupShards(t *testing.T, allowScatter bool) ([]*testfixtures.TestDatabase, []*recordingHook, *ShardedDB) {
+	schema := NewSchema()
+	schema.MustRegisterType("tickets", AutoIncrement, Ticket{})
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+
+	var testDbs []*testfixtures.TestDatabase
+	var hooks []*recordingHook
+	var shards []*DB
+	for i := 0; i < 2; i++ {
+		testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+		require.NoError(t, err)
+		hook := &recordingHook{}
+		shard, err := NewDBWithDialect(testDb.DB, schema, SQLite).WithQueryHook(hook)
+		require.NoError(t, err)
+		testDbs = append(testDbs, testDb)
+		hooks = append(hooks, hook)
+		shards = append(shards, shard)
+	}
+
+	db, err := NewShardedDB(shards, ShardOptions{
+		Key: "org_id",
+		Shard: func(value interface{}) (int, error) {
+			return int(value.(int64) % 2), nil
+		},
+		AllowScatter: allowScatter,
+	})
+	require.NoError(t, err)
+	return testDbs, hooks, db
+}
+
+func TestSQLiteShardedDB(t *testing.T) {
+	testDbs, hooks, db := setupShards(t, false)
+	for _, testDb := range testDbs {
+		defer testDb.Close()
+	}
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*Ticket{
+		{OrgId: 1, Body: "a"},
+		{OrgId: 2, Body: "b"},
+		{OrgId: 3, Body: "c"},
+		{OrgId: 4, Body: "d"},
+	}, 10))
+	_, err := db.InsertRow(ctx, &Ticket{OrgId: 5, Body: "e"})
+	require.NoError(t, err)
+
+	// Rows live on the shard of their org.
+	var tickets []*Ticket
+	require.NoError(t, db.Shards()[0].Query(ctx, &tickets, Filter{"org_id": In(2, 4)}, &SelectOptions{OrderBy: "body"}))
+	assert.Equal(t, []*Ticket{{Id: 1, OrgId: 2, Body: "b"}, {Id: 2, OrgId: 4, Body: "d"}}, tickets)
+	require.NoError(t, db.Shards()[1].Query(ctx, &tickets, Filter{"org_id": In(1, 3, 5)}, &SelectOptions{OrderBy: "body"}))
+	assert.Equal(t, []*Ticket{{Id: 1, OrgId: 1, Body: "a"}, {Id: 2, OrgId: 3, Body: "c"}, {Id: 3, OrgId: 5, Body: "e"}}, tickets)
+
+	require.NoError(t, db.Query(ctx, &tickets, Filter{"org_id": 3}, nil))
+	assert.Equal(t, []*Ticket{{Id: 2, OrgId: 3, Body: "c"}}, tickets)
+
+	// In queries are scattered to the shards of their values.
+	require.NoError(t, db.Query(ctx, &tickets, Filter{"org_id": In(1, 2)}, nil))
+	assert.ElementsMatch(t, []*Ticket{{Id: 1, OrgId: 1, Body: "a"}, {Id: 1, OrgId: 2, Body: "b"}}, tickets)
+	err = db.Query(ctx, &tickets, Filter{"org_id": In(1, 2)}, &SelectOptions{OrderBy: "body"})
+	assert.Error(t, err)
+	require.NoError(t, db.Query(ctx, &tickets, Filter{"org_id": In(1, 3)}, &SelectOptions{OrderBy: "body"}))
+	assert.Equal(t, []*Ticket{{Id: 1, OrgId: 1, Body: "a"}, {Id: 2, OrgId: 3, Body: "c"}}, tickets)
+
+	count, err := db.Count(ctx, &Ticket{}, Filter{"org_id": In(1, 2, 5)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	var ticket *Ticket
+	assert.True(t, errors.Is(db.QueryRow(ctx, &ticket, Filter{"body": "a"}, nil), ErrCrossShard))
+	_, err = db.Count(ctx, &Ticket{}, nil)
+	assert.Equal(t, ErrCrossShard, err)
+
+	require.NoError(t, db.QueryRow(ctx, &ticket, Filter{"org_id": 4}, nil))
+	ticket.Body = "updated"
+	require.NoError(t, db.UpdateRow(ctx, ticket))
+	require.NoError(t, db.DeleteRow(ctx, &Ticket{Id: 3, OrgId: 5}))
+	count, err = db.Count(ctx, &Ticket{}, Filter{"org_id": In(4, 5)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Batched queries are grouped by shard.
+	for _, hook := range hooks {
+		hook.after = nil
+	}
+	batchCtx := batch.WithBatching(ctx)
+	var wg sync.WaitGroup
+	for _, orgId := range []int64{1, 2, 3, 4} {
+		wg.Add(1)
+		go func(orgId int64) {
+			defer wg.Done()
+			var tickets []*Ticket
+			assert.NoError(t, db.Query(batchCtx, &tickets, Filter{"org_id": orgId}, nil))
+			assert.Len(t, tickets, 1)
+		}(orgId)
+	}
+	wg.Wait()
+	for _, hook := range hooks {
+		require.Len(t, hook.after, 1)
+		assert.True(t, hook.after[0].Batched)
+	}
+}
+
+func TestSQLiteShardedDBScatter(t *testing.T) {
+	testDbs, _, db := setupShards(t, true)
+	for _, testDb := range testDbs {
+		defer testDb.Close()
+	}
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*Ticket{{OrgId: 1, Body: "a"}, {OrgId: 2, Body: "b"}}, 10))
+
+	var tickets []*Ticket
+	require.NoError(t, db.Query(ctx, &tickets, nil, nil))
+	assert.ElementsMatch(t, []*Ticket{{Id: 1, OrgId: 1, Body: "a"}, {Id: 1, OrgId: 2, Body: "b"}}, tickets)
+	count, err := db.Count(ctx, &Ticket{}, Filter{"body": "b"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSQLiteShardedDBShardKeyLimit(t *testing.T) {
+	testDbs, _, db := setupShards(t, true)
+	for _, testDb := range testDbs {
+		defer testDb.Close()
+	}
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*Ticket{{OrgId: 1, Body: "a"}, {OrgId: 2, Body: "b"}}, 10))
+
+	shard, err := db.ShardFor(int64(2))
+	require.NoError(t, err)
+	assert.Equal(t, db.Shards()[0], shard)
+
+	// Queries must filter on shard keys of the shard, even if the ShardedDB
+	// allows scattering.
+	var tickets []*Ticket
+	require.NoError(t, shard.Query(ctx, &tickets, Filter{"org_id": 2}, nil))
+	assert.Equal(t, []*Ticket{{Id: 1, OrgId: 2, Body: "b"}}, tickets)
+	assert.Error(t, shard.Query(ctx, &tickets, nil, nil))
+	assert.Error(t, shard.Query(ctx, &tickets, Filter{"org_id": 1}, nil))
+	assert.Error(t, shard.Query(ctx, &tickets, Filter{"org_id": In(1, 2)}, nil))
+	assert.Error(t, shard.Query(ctx, &tickets, Filter{"org_id": Gt(0)}, nil))
+	_, err = shard.Count(ctx, &Ticket{}, Filter{"body": "b"})
+	assert.Error(t, err)
+
+	// Writes must not write shard keys of another shard.
+	_, err = shard.InsertRow(ctx, &Ticket{OrgId: 3, Body: "c"})
+	assert.Error(t, err)
+	assert.Error(t, shard.UpdateRow(ctx, &Ticket{Id: 1, OrgId: 1, Body: "moved"}))
+	_, err = shard.InsertRow(ctx, &Ticket{OrgId: 4, Body: "d"})
+	require.NoError(t, err)
+	require.NoError(t, shard.UpdateColumns(ctx, &Ticket{Id: 1, Body: "updated"}, "body"))
+
+	// The ShardedDB itself still scatters.
+	require.NoError(t, db.Query(ctx, &tickets, nil, nil))
+	assert.ElementsMatch(t, []*Ticket{
+		{Id: 1, OrgId: 1, Body: "a"},
+		{Id: 1, OrgId: 2, Body: "updated"},
+		{Id: 2, OrgId: 4, Body: "d"},
+	}, tickets)
+}
+
+func TestNewShardedDB(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+
+	options := ShardOptions{Key: "id", Shard: HashShard(2)}
+	_, err := NewShardedDB(nil, options)
+	assert.Error(t, err)
+	_, err = NewShardedDB([]*DB{db, db}, options)
+	assert.Error(t, err)
+	_, err = NewShardedDB([]*DB{db}, ShardOptions{Key: "id"})
+	assert.Error(t, err)
+	_, err = NewShardedDB([]*DB{db, NewDBWithDialect(nil, NewSchema(), SQLite)}, options)
+	assert.Error(t, err)
+}
@@ -0,0 +1,147 @@
+package sqlgen
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A TableOption configures a table registered with Schema.RegisterType.
+type TableOption interface {
+	apply(*Table) error
+}
+
+// tableOptionFunc is a helper to define TableOptions.
+type tableOptionFunc func(*Table) error
+
+func (f tableOptionFunc) apply(t *Table) error { return f(t) }
+
+// SoftDelete marks column as the table's soft-delete column, which holds the
+// time a row was deleted, or NULL. Its field must be a *time.Time, or a
+// time.Time tagged implicitnull.
+//
+// Query, QueryRow, Count, Iterate and MakeTester do not match soft-deleted
+// rows, unless their filter is Unscoped or mentions column. DeleteRow sets
+// column instead of deleting the row, and HardDelete deletes the row.
+func SoftDelete(column string) TableOption {
+	return tableOptionFunc(func(t *Table) error {
+		c, ok := t.ColumnsByName[column]
+		if !ok {
+			return fmt.Errorf("unknown soft delete column %s", column)
+		}
+		if c.Primary || c.Version || c.ReadOnly {
+			return fmt.Errorf("soft delete column %s cannot be a primary key, version or readonly column", column)
+		}
+		if c.Descriptor.Type != reflect.TypeOf(time.Time{}) {
+			return fmt.Errorf("soft delete column %s must be a time.Time", column)
+		}
+		if !c.Descriptor.Ptr && !c.Descriptor.Tags.Contains("implicitnull") {
+			return fmt.Errorf("soft delete column %s must be a pointer or implicitnull", column)
+		}
+		t.softDelete = c
+		return nil
+	})
+}
+
+// unscopedKey is the filter key Unscoped uses to mark a filter.
+const unscopedKey = "$sqlgen_unscoped"
+
+// Unscoped returns a copy of filter that also matches soft-deleted rows, for
+// example:
+//
+//   err := db.Query(ctx, &users, sqlgen.Unscoped(Filter{"org_id": 10}), nil)
+func Unscoped(filter Filter) Filter {
+	unscoped := make(Filter, len(filter)+1)
+	for k, v := range filter {
+		unscoped[k] = v
+	}
+	unscoped[unscopedKey] = true
+	return unscoped
+}
+
+// StripUnscoped returns filter without the mark Unscoped adds, so that it only
+// holds columns.
+func StripUnscoped(filter Filter) Filter {
+	if _, ok := filter[unscopedKey]; !ok {
+		return filter
+	}
+	stripped := make(Filter, len(filter))
+	for k, v := range filter {
+		if k != unscopedKey {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
+// scoped returns true if scopeFilter restricts filter to rows that are not
+// soft-deleted.
+func (t *Table) scoped(filter Filter) bool {
+	if t.softDelete == nil {
+		return false
+	}
+	if _, ok := filter[unscopedKey]; ok {
+		return false
+	}
+	_, ok := filter[t.softDelete.Name]
+	return !ok
+}
+
+// scopeFilter returns filter restricted to rows that are not soft-deleted,
+// unless the filter is Unscoped or already filters on the soft-delete column.
+func (t *Table) scopeFilter(filter Filter) Filter {
+	if !t.scoped(filter) {
+		return StripUnscoped(filter)
+	}
+	scoped := make(Filter, len(filter)+1)
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	scoped[t.softDelete.Name] = nil
+	return scoped
+}
+
+// unscope returns a filter scoped by scopeFilter without its scope.
+func (t *Table) unscope(filter Filter) Filter {
+	unscoped := make(Filter, len(filter))
+	for k, v := range filter {
+		if k != t.softDelete.Name {
+			unscoped[k] = v
+		}
+	}
+	return unscoped
+}
+
+var errNotSoftDeleted = errors.New("table has no soft delete column")
+
+// softDeleteRow returns a copy of row with its soft-delete column set to the
+// current time, and the column's name. row itself is left unchanged, so that
+// it is only marked deleted once the update succeeds.
+func (s *Schema) softDeleteRow(row interface{}) (interface{}, string, error) {
+	typ, err := checkMutateRowTypeShape(reflect.TypeOf(row))
+	if err != nil {
+		return nil, "", err
+	}
+	table, err := s.getWritable(typ)
+	if err != nil {
+		return nil, "", err
+	}
+	if table.softDelete == nil {
+		return nil, "", errNotSoftDeleted
+	}
+
+	deleted := reflect.New(typ)
+	deleted.Elem().Set(reflect.ValueOf(row).Elem())
+
+	// Databases store microseconds at most, so truncate to match what is read
+	// back.
+	now := time.Now().Truncate(time.Microsecond)
+	field := deleted.Elem().FieldByIndex(table.softDelete.Index)
+	if table.softDelete.Descriptor.Ptr {
+		field.Set(reflect.ValueOf(&now))
+	} else {
+		field.Set(reflect.ValueOf(now))
+	}
+	return deleted.Interface(), table.softDelete.Name, nil
+}
@@ -0,0 +1,210 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Note struct {
+	Id        int64 `sql:",primary"`
+	Body      string
+	DeletedAt *time.Time
+}
+
+func TestSoftDeleteRegistration(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("notes", AutoIncrement, Note{}, SoftDelete("deleted_at")))
+	assert.Error(t, s.RegisterType("missing", AutoIncrement, JustId{}, SoftDelete("deleted_at")))
+	assert.Error(t, s.RegisterType("bodies", AutoIncrement, struct {
+		Id   int64 `sql:",primary"`
+		Body *string
+	}{}, SoftDelete("body")))
+	assert.Error(t, s.RegisterType("not_null", AutoIncrement, struct {
+		Id        int64 `sql:",primary"`
+		DeletedAt time.Time
+	}{}, SoftDelete("deleted_at")))
+
+	tester, err := s.MakeTester("notes", Filter{"body": "a"})
+	require.NoError(t, err)
+	now := time.Now()
+	assert.True(t, tester.Test(&Note{Body: "a"}))
+	assert.False(t, tester.Test(&Note{Body: "a", DeletedAt: &now}))
+
+	tester, err = s.MakeTester("notes", Unscoped(Filter{"body": "a"}))
+	require.NoError(t, err)
+	assert.True(t, tester.Test(&Note{Body: "a", DeletedAt: &now}))
+
+	var notes []*Note
+	query, err := s.MakeSelect(&notes, Filter{"deleted_at": Not(nil)}, nil)
+	require.NoError(t, err)
+	selectQuery, err := query.MakeSelectQuery()
+	require.NoError(t, err)
+	clause, _ := selectQuery.ToSQL()
+	assert.Equal(t, "SELECT id, body, deleted_at FROM notes WHERE deleted_at IS NOT NULL", clause)
+}
+
+func TestSQLiteSoftDelete(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("notes", AutoIncrement, Note{}, SoftDelete("deleted_at"))
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer testDb.Close()
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*Note{{Body: "a"}, {Body: "b"}, {Body: "c"}}, 10))
+
+	// A failed delete leaves the row unmarked.
+	limited, err := db.WithShardLimit(Filter{"body": "a"})
+	require.NoError(t, err)
+	deleted := &Note{Id: 2, Body: "b"}
+	assert.Error(t, limited.DeleteRow(ctx, deleted))
+	assert.Nil(t, deleted.DeletedAt)
+
+	hook := &recordingHook{}
+	hooked, err := db.WithQueryHook(hook)
+	require.NoError(t, err)
+	require.NoError(t, hooked.DeleteRow(ctx, deleted))
+	require.NotNil(t, deleted.DeletedAt)
+	require.Len(t, hook.after, 1)
+	assert.Equal(t, "DeleteRow", hook.after[0].Operation)
+
+	var notes []*Note
+	require.NoError(t, db.Query(ctx, &notes, nil, nil))
+	assert.Equal(t, []*Note{{Id: 1, Body: "a"}, {Id: 3, Body: "c"}}, notes)
+
+	var note *Note
+	assert.Equal(t, sql.ErrNoRows, db.QueryRow(ctx, &note, Filter{"id": int64(2)}, nil))
+	require.NoError(t, db.QueryRow(ctx, &note, Unscoped(Filter{"id": int64(2)}), nil))
+	require.NotNil(t, note)
+	assert.True(t, deleted.DeletedAt.Equal(*note.DeletedAt))
+
+	count, err := db.Count(ctx, &Note{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	count, err = db.Count(ctx, &Note{}, Unscoped(nil))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	notes = nil
+	require.NoError(t, db.Query(ctx, &notes, Filter{"deleted_at": Not(nil)}, nil))
+	assert.Len(t, notes, 1)
+
+	var bodies []string
+	require.NoError(t, db.Iterate(ctx, &Note{}, nil, nil, func(row interface{}) error {
+		bodies = append(bodies, row.(*Note).Body)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"a", "c"}, bodies)
+
+	require.NoError(t, db.HardDelete(ctx, &Note{Id: 1}))
+	count, err = db.Count(ctx, &Note{}, Unscoped(nil))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func setupNotes(t *testing.T) (*testfixtures.TestDatabase, *DB) {
+	schema := NewSchema()
+	schema.MustRegisterType("notes", AutoIncrement, Note{}, SoftDelete("deleted_at"))
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+
+	ctx := context.Background()
+	require.NoError(t, db.InsertRows(ctx, []*Note{{Body: "a"}, {Body: "b"}, {Body: "c"}}, 10))
+	require.NoError(t, db.DeleteRow(ctx, &Note{Id: 2, Body: "b"}))
+	return testDb, db
+}
+
+type NoteCount struct {
+	Count int64
+}
+
+func TestSQLiteSoftDeleteBatch(t *testing.T) {
+	testDb, db := setupNotes(t)
+	defer testDb.Close()
+	hook := &recordingHook{}
+	db, err := db.WithQueryHook(hook)
+	require.NoError(t, err)
+	ctx := batch.WithBatching(context.Background())
+
+	var wg sync.WaitGroup
+	notes := make([]*Note, 3)
+	for i := range notes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var note *Note
+			if err := db.QueryRow(ctx, &note, Filter{"id": int64(i + 1)}, nil); err == nil {
+				notes[i] = note
+			}
+		}(i)
+	}
+	var unscoped []*Note
+	var a, b []*NoteCount
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Query(ctx, &unscoped, Unscoped(Filter{"body": "b"}), nil))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Note{}, &a, Filter{"body": "a"}, &AggregateOptions{
+			Aggregates: map[string]Aggregate{"count": CountAll()},
+		}))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, db.Aggregate(ctx, &Note{}, &b, Filter{"body": "b"}, &AggregateOptions{
+			Aggregates: map[string]Aggregate{"count": CountAll()},
+		}))
+	}()
+	wg.Wait()
+
+	assert.Equal(t, []*Note{{Id: 1, Body: "a"}, nil, {Id: 3, Body: "c"}}, notes)
+	require.Len(t, unscoped, 1)
+	assert.Equal(t, "b", unscoped[0].Body)
+	assert.Equal(t, []*NoteCount{{Count: 1}}, a)
+	assert.Equal(t, []*NoteCount{{Count: 0}}, b)
+
+	// Scoped queries, the unscoped query and the aggregates each ran as a
+	// single statement.
+	require.Len(t, hook.after, 3)
+	for _, event := range hook.after {
+		assert.True(t, event.Batched)
+	}
+}
+
+func TestSQLiteSoftDeleteUnscopedWrites(t *testing.T) {
+	testDb, db := setupNotes(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	var note *Note
+	require.NoError(t, db.QueryRow(ctx, &note, Unscoped(Filter{"id": int64(2)}), nil))
+	note.Body = "bb"
+	require.NoError(t, db.UpdateRowWhere(ctx, note, Unscoped(Filter{"body": "b"})))
+	require.NoError(t, db.QueryRow(ctx, &note, Unscoped(Filter{"id": int64(2)}), nil))
+	assert.Equal(t, "bb", note.Body)
+
+	limited, err := db.WithShardLimit(Filter{"body": "bb"})
+	require.NoError(t, err)
+	count, err := limited.Count(ctx, &Note{}, Unscoped(Filter{"body": "bb"}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	var notes []*Note
+	require.NoError(t, limited.Query(ctx, &notes, Unscoped(Filter{"body": "bb"}), nil))
+	assert.Len(t, notes, 1)
+}
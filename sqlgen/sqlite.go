@@ -0,0 +1,130 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/samsarahq/go/oops"
+)
+
+// SQLite is a Dialect for SQLite, useful for running tests against an
+// in-process database. SQLite locks the whole database for writes, so it
+// ignores FOR UPDATE.
+var SQLite Dialect = sqliteDialect{}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Rebind(clause string, args []interface{}) (string, []interface{}) {
+	return strings.TrimSuffix(clause, " FOR UPDATE"), args
+}
+
+func (sqliteDialect) UpsertClause(conflictColumns []string, set []UpsertSet) string {
+	return onConflictUpdate(conflictColumns, set)
+}
+
+func (sqliteDialect) lastInsertIdIsLastRow() {}
+
+// expandTuples is set as SQLite only supports tuple IN with a subquery, which
+// does not use indexes.
+func (sqliteDialect) expandTuples() {}
+
+func (sqliteDialect) ReturningClause(column string) string {
+	return ""
+}
+
+func (sqliteDialect) CheckIndex(ctx context.Context, q QueryExecer, clause string, args []interface{}) (string, bool, error) {
+	res, err := q.QueryContext(ctx, "EXPLAIN QUERY PLAN "+clause, args...)
+	if err != nil {
+		return "", false, oops.Wrapf(err, "Failed to run explain on the query")
+	}
+	defer res.Close()
+
+	details, err := parseSQLiteQueryPlan(res)
+	if err != nil {
+		return "", false, oops.Wrapf(err, "failed to parse explain results")
+	}
+
+	for _, detail := range details {
+		if sqliteScanMissesIndex(detail) {
+			return detail, false, nil
+		}
+	}
+	return "", true, nil
+}
+
+// parseSQLiteQueryPlan returns the detail column of EXPLAIN QUERY PLAN output,
+// which is its last column in all SQLite versions.
+func parseSQLiteQueryPlan(rows *sql.Rows) ([]string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var details []string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		var detail string
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		values[len(values)-1] = &detail
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		details = append(details, detail)
+	}
+	return details, rows.Err()
+}
+
+// sqliteScanMissesIndex returns true if a query plan step, such as
+// "SCAN users" or "SEARCH users USING INDEX users_name (name=?)", reads a table
+// without an index.
+func sqliteScanMissesIndex(detail string) bool {
+	return strings.HasPrefix(detail, "SCAN ") && !strings.Contains(detail, " USING ")
+}
+
+func (sqliteDialect) columnType(kind columnKind, primary bool) string {
+	switch kind {
+	case kindInt:
+		return "INTEGER"
+	case kindFloat:
+		return "REAL"
+	case kindBool:
+		// The driver scans BOOLEAN columns as bools.
+		return "BOOLEAN"
+	case kindBytes:
+		return "BLOB"
+	case kindTime:
+		// The driver scans DATETIME columns as time.Times.
+		return "DATETIME"
+	default:
+		return "TEXT"
+	}
+}
+
+func (sqliteDialect) autoIncrementColumn(name string) string {
+	return name + " INTEGER PRIMARY KEY AUTOINCREMENT"
+}
+
+func (sqliteDialect) describeTable(ctx context.Context, q QueryExecer, table string) ([]dbColumn, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name, type, "notnull", pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []dbColumn
+	for rows.Next() {
+		var column dbColumn
+		var notNull bool
+		var pk int
+		if err := rows.Scan(&column.Name, &column.DataType, &notNull, &pk); err != nil {
+			return nil, err
+		}
+		column.Nullable = !notNull && pk == 0
+		column.Primary = pk > 0
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
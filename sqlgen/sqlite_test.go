@@ -0,0 +1,173 @@
+package sqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samson-crypto/thunder/batch"
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSQLite(t *testing.T) (*testfixtures.TestDatabase, *DB) {
+	schema := NewSchema()
+	schema.MustRegisterType("users", AutoIncrement, User{})
+	schema.MustRegisterType("owls", UniqueId, Owl{})
+	schema.MustRegisterType("complex", AutoIncrement, Complex{})
+
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+
+	return testDb, NewDBWithDialect(testDb.DB, schema, SQLite)
+}
+
+func TestSQLiteCreateTableStatements(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("users", AutoIncrement, User{})
+	schema.MustRegisterType("owls", UniqueId, Owl{})
+	schema.MustRegisterType("complex", AutoIncrement, Complex{})
+
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE complex (\n" +
+			"  id INTEGER PRIMARY KEY AUTOINCREMENT,\n" +
+			"  name TEXT NOT NULL,\n" +
+			"  text BLOB,\n" +
+			"  blob BLOB,\n" +
+			"  mappings TEXT,\n" +
+			"  implicit_null TEXT\n" +
+			")",
+		"CREATE TABLE owls (\n" +
+			"  species TEXT NOT NULL,\n" +
+			"  common_name TEXT NOT NULL,\n" +
+			"  genus TEXT NOT NULL,\n" +
+			"  family TEXT NOT NULL,\n" +
+			"  PRIMARY KEY (species)\n" +
+			")",
+		"CREATE TABLE users (\n" +
+			"  id INTEGER PRIMARY KEY AUTOINCREMENT,\n" +
+			"  name TEXT NOT NULL,\n" +
+			"  uuid BLOB NOT NULL,\n" +
+			"  mood BLOB,\n" +
+			"  proto BLOB NOT NULL,\n" +
+			"  simple_proto BLOB NOT NULL,\n" +
+			"  implicit_null TEXT\n" +
+			")",
+	}, statements)
+}
+
+func TestSQLiteIntegration(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	mood := testfixtures.CustomType{'h', 'a', 'p', 'p', 'y'}
+	res, err := db.InsertRow(ctx, &User{Name: "Bob", Mood: &mood})
+	require.NoError(t, err)
+	id, err := res.LastInsertId()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+
+	require.NoError(t, db.InsertRows(ctx, []*User{{Name: "Alice", ImplicitNull: "set"}, {Name: "Carol"}}, 1))
+
+	var users []*User
+	require.NoError(t, db.Query(ctx, &users, nil, &SelectOptions{OrderBy: "id"}))
+	assert.Equal(t, []*User{
+		{Id: 1, Name: "Bob", Mood: &mood},
+		{Id: 2, Name: "Alice", ImplicitNull: "set"},
+		{Id: 3, Name: "Carol"},
+	}, users)
+
+	count, err := db.Count(ctx, &User{}, Filter{"id": Gt(1), "name": Like("%o%")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	var user *User
+	require.NoError(t, db.QueryRow(ctx, &user, Filter{"implicit_null": nil, "mood": Not(nil)}, &SelectOptions{ForUpdate: true}))
+	assert.Equal(t, "Bob", user.Name)
+
+	user.Name = "Robert"
+	require.NoError(t, db.UpdateRow(ctx, user))
+	require.NoError(t, db.DeleteRow(ctx, &User{Id: 3}))
+
+	users = nil
+	require.NoError(t, db.Query(ctx, &users, Filter{"id": In(1, 2, 3)}, &SelectOptions{OrderBy: "id"}))
+	require.Len(t, users, 2)
+	assert.Equal(t, "Robert", users[0].Name)
+	assert.Equal(t, "Alice", users[1].Name)
+
+	owl := &Owl{Species: "Bubo virginianus", CommonName: "Great horned owl", Genus: "Bubo", Family: "Strigidae"}
+	_, err = db.UpsertRow(ctx, owl)
+	require.NoError(t, err)
+	owl.CommonName = "Tiger owl"
+	require.NoError(t, db.UpsertRows(ctx, []*Owl{owl}, 10))
+
+	var owls []*Owl
+	require.NoError(t, db.Query(ctx, &owls, nil, nil))
+	assert.Equal(t, []*Owl{owl}, owls)
+
+	complex := &Complex{Name: "c", Text: []byte("text"), Blob: []byte{0, 1}, Mappings: map[string]string{"a": "b"}}
+	_, err = db.InsertRow(ctx, complex)
+	require.NoError(t, err)
+	var complexes []*Complex
+	require.NoError(t, db.Query(ctx, &complexes, nil, nil))
+	complex.Id = 1
+	assert.Equal(t, []*Complex{complex}, complexes)
+}
+
+func TestSQLiteBatchQuery(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := batch.WithBatching(context.Background())
+
+	owls := []*Owl{
+		{Species: "Tyto alba", CommonName: "Barn owl", Genus: "Tyto", Family: "Tytonidae"},
+		{Species: "Strix varia", CommonName: "Barred owl", Genus: "Strix", Family: "Strigidae"},
+		{Species: "Strix aluco", CommonName: "Tawny owl", Genus: "Strix", Family: "Strigidae"},
+	}
+	require.NoError(t, db.InsertRows(ctx, owls, 10))
+
+	filters := []Filter{
+		{"species": "Tyto alba"},
+		{"genus": "Strix", "family": "Strigidae"},
+		{"species": "Bubo bubo"},
+	}
+	results := make([][]*Owl, len(filters))
+	errs := make([]error, len(filters))
+	done := make(chan int)
+	for i := range filters {
+		go func(i int) {
+			errs[i] = db.Query(ctx, &results[i], filters[i], nil)
+			done <- i
+		}(i)
+	}
+	for range filters {
+		<-done
+	}
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []*Owl{owls[0]}, results[0])
+	assert.ElementsMatch(t, []*Owl{owls[1], owls[2]}, results[1])
+	assert.Empty(t, results[2])
+}
+
+func TestSQLiteCheckIndex(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	_, ok, err := SQLite.CheckIndex(ctx, db.Conn, "SELECT species FROM owls WHERE species = ?", []interface{}{"Tyto alba"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	plan, ok, err := SQLite.CheckIndex(ctx, db.Conn, "SELECT species FROM owls WHERE genus = ?", []interface{}{"Tyto"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, plan, "SCAN")
+}
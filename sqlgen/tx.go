@@ -0,0 +1,330 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// A Savepoint is a nested transaction inside a transaction, created with
+// WithSavepoint.
+type Savepoint struct {
+	tx   *sql.Tx
+	name string
+}
+
+// WithSavepoint starts a nested transaction with SAVEPOINT inside the context's
+// transaction. Release it to keep its changes in the transaction, or roll it
+// back to undo them without aborting the transaction.
+func (db *DB) WithSavepoint(ctx context.Context) (*Savepoint, error) {
+	tx, ok := ctx.Value(txKey{db: db.Conn}).(*sql.Tx)
+	if !ok {
+		return nil, errors.New("not in a tx")
+	}
+
+	name := fmt.Sprintf("sqlgen_savepoint_%d", atomic.AddUint64(&savepointCounter, 1))
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &Savepoint{tx: tx, name: name}, nil
+}
+
+// A Tx is a transaction returned by WithTx. Outside of a transaction it is a
+// *sql.Tx; nested in one it is a savepoint, released by Commit and rolled back
+// to by Rollback.
+type Tx interface {
+	QueryExecer
+	Commit() error
+	Rollback() error
+}
+
+// savepointTx is a Tx for a savepoint nested by WithTx. Queries run in the
+// enclosing *sql.Tx.
+type savepointTx struct {
+	*sql.Tx
+	ctx       context.Context
+	savepoint *Savepoint
+
+	mu   sync.Mutex
+	done bool
+}
+
+// finish runs f unless the savepoint has already been released or rolled
+// back, so that a deferred Rollback after Commit does not touch the
+// enclosing transaction.
+func (t *savepointTx) finish(f func(ctx context.Context) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	return f(t.ctx)
+}
+
+func (t *savepointTx) Commit() error {
+	return t.finish(t.savepoint.Release)
+}
+
+func (t *savepointTx) Rollback() error {
+	return t.finish(t.savepoint.Rollback)
+}
+
+// savepointCounter numbers savepoints, so that nested savepoints have distinct
+// names.
+var savepointCounter uint64
+
+// Release keeps the savepoint's changes in the transaction.
+func (s *Savepoint) Release(ctx context.Context) error {
+	_, err := s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+s.name)
+	return err
+}
+
+// Rollback undoes the savepoint's changes.
+func (s *Savepoint) Rollback(ctx context.Context) error {
+	_, err := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+s.name)
+	return err
+}
+
+// TxRetryPolicy configures how RunInTx retries transactions that fail with a
+// deadlock or lock wait timeout.
+type TxRetryPolicy struct {
+	// MaxAttempts is the number of times to run a transaction, including the
+	// first.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled for every
+	// following retry up to MaxBackoff. Delays are jittered.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultTxRetryPolicy is the TxRetryPolicy of a new DB.
+var DefaultTxRetryPolicy = TxRetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+// WithTxRetryPolicy returns a copy of the DB whose RunInTx retries with policy.
+func (db *DB) WithTxRetryPolicy(policy TxRetryPolicy) (*DB, error) {
+	if policy.MaxAttempts < 1 {
+		return nil, errors.New("retry policy needs at least one attempt")
+	}
+
+	dbCopy := db.clone()
+	dbCopy.txRetryPolicy = &policy
+	return dbCopy, nil
+}
+
+// txStateKey is used as a key for a context.Context to hold the *txState of a
+// RunInTx transaction.
+type txStateKey struct {
+	db *sql.DB
+}
+
+// txState tracks a RunInTx transaction. Its context can be shared by several
+// goroutines, so mu protects afterCommit.
+type txState struct {
+	mu          sync.Mutex
+	afterCommit []func()
+}
+
+func (s *txState) addAfterCommit(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.afterCommit = append(s.afterCommit, f)
+}
+
+func (s *txState) afterCommitCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.afterCommit)
+}
+
+// truncateAfterCommit drops the hooks added after the first count.
+func (s *txState) truncateAfterCommit(count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.afterCommit = s.afterCommit[:count]
+}
+
+func (s *txState) runAfterCommit() {
+	s.mu.Lock()
+	hooks := s.afterCommit
+	s.afterCommit = nil
+	s.mu.Unlock()
+
+	for _, f := range hooks {
+		f()
+	}
+}
+
+func txStateFor(ctx context.Context, db *DB) *txState {
+	state, _ := ctx.Value(txStateKey{db: db.Conn}).(*txState)
+	return state
+}
+
+// RunInTx runs fn in a transaction, and commits the transaction if fn returns
+// nil or rolls it back otherwise. If the transaction fails with a deadlock or
+// lock wait timeout, RunInTx runs fn again in a new transaction, following the
+// DB's TxRetryPolicy, so fn must be safe to retry. For example:
+//
+//   err := db.RunInTx(ctx, func(ctx context.Context) error {
+//     if err := db.InsertRow(ctx, order); err != nil {
+//       return err
+//     }
+//     return db.UpdateRow(ctx, customer)
+//   })
+//
+// If ctx already holds a transaction, RunInTx runs fn in a savepoint, and rolls
+// back only fn's changes if fn fails. Nested calls are not retried on their
+// own; the outermost RunInTx retries the whole transaction.
+func (db *DB) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if db.HasTx(ctx) {
+		return db.runInSavepoint(ctx, fn)
+	}
+
+	policy := DefaultTxRetryPolicy
+	if db.txRetryPolicy != nil {
+		policy = *db.txRetryPolicy
+	}
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		state := &txState{}
+		err := db.runTx(ctx, state, fn)
+		if err == nil {
+			state.runAfterCommit()
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || !IsRetryableTxError(err) {
+			return err
+		}
+
+		// Sleep between half and all of the backoff, so that transactions
+		// that deadlocked each other do not retry in lockstep.
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// runTx runs fn in a new transaction, and commits or rolls it back.
+func (db *DB) runTx(ctx context.Context, state *txState, fn func(ctx context.Context) error) (err error) {
+	txCtx, tx, err := db.WithTx(ctx)
+	if err != nil {
+		return err
+	}
+	txCtx = context.WithValue(txCtx, txStateKey{db: db.Conn}, state)
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(txCtx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// runInSavepoint runs fn in a savepoint of the context's transaction.
+func (db *DB) runInSavepoint(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	savepoint, err := db.WithSavepoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Drop post-commit hooks added by fn if it is rolled back.
+	state := txStateFor(ctx, db)
+	var hooks int
+	if state != nil {
+		hooks = state.afterCommitCount()
+	}
+	rollback := func() error {
+		if state != nil {
+			state.truncateAfterCommit(hooks)
+		}
+		return savepoint.Rollback(ctx)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		// The savepoint is gone if the database aborted the whole
+		// transaction, for example after a deadlock in MySQL.
+		if rollbackErr := rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rolling back savepoint: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return savepoint.Release(ctx)
+}
+
+// AfterCommit calls f after the transaction started by RunInTx in ctx commits
+// successfully. It is not called if the transaction, or the savepoint f was
+// added in, is rolled back. Outside of a transaction, f is called immediately.
+func (db *DB) AfterCommit(ctx context.Context, f func()) error {
+	if !db.HasTx(ctx) {
+		f()
+		return nil
+	}
+	state := txStateFor(ctx, db)
+	if state == nil {
+		return errors.New("AfterCommit requires a transaction started by RunInTx")
+	}
+	state.addAfterCommit(f)
+	return nil
+}
+
+// sqlStateError is implemented by PostgreSQL driver errors.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// IsRetryableTxError returns whether err is a deadlock or lock wait timeout,
+// after which a transaction can be retried.
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT.
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		switch stateErr.SQLState() {
+		case "40P01", "40001", "55P03":
+			// Deadlock, serialization failure and lock not available.
+			return true
+		}
+		return false
+	}
+
+	// SQLite reports busy databases by message.
+	return strings.Contains(err.Error(), "database is locked")
+}
@@ -0,0 +1,261 @@
+package sqlgen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countUsers(t *testing.T, ctx context.Context, db *DB) int64 {
+	count, err := db.Count(ctx, &User{}, nil)
+	require.NoError(t, err)
+	return count
+}
+
+func insertUser(ctx context.Context, db *DB, name string) error {
+	_, err := db.InsertRow(ctx, &User{Name: name})
+	return err
+}
+
+func TestSQLiteRunInTx(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	var committed bool
+	require.NoError(t, db.RunInTx(ctx, func(ctx context.Context) error {
+		require.True(t, db.HasTx(ctx))
+		require.NoError(t, db.AfterCommit(ctx, func() { committed = true }))
+		assert.False(t, committed)
+		return insertUser(ctx, db, "Bob")
+	}))
+	assert.True(t, committed)
+	assert.Equal(t, int64(1), countUsers(t, ctx, db))
+
+	committed = false
+	errFailed := errors.New("failed")
+	err := db.RunInTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, db.AfterCommit(ctx, func() { committed = true }))
+		require.NoError(t, insertUser(ctx, db, "Alice"))
+		return errFailed
+	})
+	assert.Equal(t, errFailed, err)
+	assert.False(t, committed)
+	assert.Equal(t, int64(1), countUsers(t, ctx, db))
+
+	assert.Panics(t, func() {
+		db.RunInTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, insertUser(ctx, db, "Alice"))
+			panic("boom")
+		})
+	})
+	assert.Equal(t, int64(1), countUsers(t, ctx, db))
+
+	// AfterCommit runs immediately outside of a transaction.
+	committed = false
+	require.NoError(t, db.AfterCommit(ctx, func() { committed = true }))
+	assert.True(t, committed)
+}
+
+func TestSQLiteRunInTxNested(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	var hooks []string
+	require.NoError(t, db.RunInTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, insertUser(ctx, db, "Bob"))
+
+		err := db.RunInTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, insertUser(ctx, db, "Alice"))
+			require.NoError(t, db.AfterCommit(ctx, func() { hooks = append(hooks, "rolled back") }))
+			return errors.New("failed")
+		})
+		assert.Error(t, err)
+
+		require.NoError(t, db.RunInTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, db.AfterCommit(ctx, func() { hooks = append(hooks, "released") }))
+			return insertUser(ctx, db, "Carol")
+		}))
+		return nil
+	}))
+
+	var users []*User
+	require.NoError(t, db.Query(ctx, &users, nil, &SelectOptions{OrderBy: "id"}))
+	require.Len(t, users, 2)
+	assert.Equal(t, "Bob", users[0].Name)
+	assert.Equal(t, "Carol", users[1].Name)
+	assert.Equal(t, []string{"released"}, hooks)
+}
+
+func TestSQLiteWithSavepoint(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+
+	_, err := db.WithSavepoint(context.Background())
+	assert.Error(t, err)
+
+	ctx, tx, err := db.WithTx(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	require.NoError(t, insertUser(ctx, db, "Bob"))
+	savepoint, err := db.WithSavepoint(ctx)
+	require.NoError(t, err)
+	require.NoError(t, insertUser(ctx, db, "Alice"))
+	require.NoError(t, savepoint.Rollback(ctx))
+	assert.Equal(t, int64(1), countUsers(t, ctx, db))
+
+	savepoint, err = db.WithSavepoint(ctx)
+	require.NoError(t, err)
+	require.NoError(t, insertUser(ctx, db, "Carol"))
+	require.NoError(t, savepoint.Release(ctx))
+	assert.Equal(t, int64(2), countUsers(t, ctx, db))
+
+	// Hooks need a transaction started by RunInTx.
+	assert.Error(t, db.AfterCommit(ctx, func() {}))
+}
+
+func TestSQLiteNestedWithTx(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+
+	ctx, tx, err := db.WithTx(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	// The outermost transaction is a *sql.Tx.
+	assert.IsType(t, &sql.Tx{}, tx)
+	require.NoError(t, insertUser(ctx, db, "Bob"))
+
+	// Nested calls start savepoints of the outer transaction.
+	nestedCtx, nested, err := db.WithTx(ctx)
+	require.NoError(t, err)
+	require.NoError(t, insertUser(nestedCtx, db, "Alice"))
+	require.NoError(t, nested.Rollback())
+	assert.Equal(t, sql.ErrTxDone, nested.Commit())
+	assert.Equal(t, int64(1), countUsers(t, ctx, db))
+
+	nestedCtx, nested, err = db.WithTx(ctx)
+	require.NoError(t, err)
+	require.NoError(t, insertUser(nestedCtx, db, "Carol"))
+	require.NoError(t, nested.Commit())
+	// A deferred Rollback after Commit leaves the outer transaction alone.
+	assert.Equal(t, sql.ErrTxDone, nested.Rollback())
+	assert.Equal(t, int64(2), countUsers(t, ctx, db))
+
+	// Chunked inserts nest in the outer transaction.
+	require.NoError(t, db.InsertRows(ctx, []*User{{Name: "Dave"}, {Name: "Erin"}}, 1))
+	assert.Equal(t, int64(4), countUsers(t, ctx, db))
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, int64(4), countUsers(t, context.Background(), db))
+}
+
+func TestSQLiteRunInTxLostSavepoint(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+
+	errFailed := errors.New("failed")
+	err := db.RunInTx(context.Background(), func(ctx context.Context) error {
+		return db.RunInTx(ctx, func(ctx context.Context) error {
+			// Abort the whole transaction, as MySQL does on a deadlock.
+			tx := ctx.Value(txKey{db: db.Conn}).(*sql.Tx)
+			_, err := tx.ExecContext(ctx, "ROLLBACK")
+			require.NoError(t, err)
+			return errFailed
+		})
+	})
+	assert.True(t, errors.Is(err, errFailed))
+	assert.Contains(t, err.Error(), "rolling back savepoint")
+}
+
+func TestSQLiteAfterCommitConcurrent(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+
+	var mu sync.Mutex
+	var committed int
+	require.NoError(t, db.RunInTx(context.Background(), func(ctx context.Context) error {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, db.AfterCommit(ctx, func() {
+					mu.Lock()
+					defer mu.Unlock()
+					committed++
+				}))
+			}()
+		}
+		wg.Wait()
+		return nil
+	}))
+	assert.Equal(t, 10, committed)
+}
+
+func TestSQLiteRunInTxRetry(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	db, err := db.WithTxRetryPolicy(TxRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	require.NoError(t, err)
+
+	var attempts, hooks int
+	require.NoError(t, db.RunInTx(ctx, func(ctx context.Context) error {
+		attempts++
+		require.NoError(t, db.AfterCommit(ctx, func() { hooks++ }))
+		require.NoError(t, insertUser(ctx, db, "Bob"))
+		if attempts < 3 {
+			return fmt.Errorf("insert: %w", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 1, hooks)
+	assert.Equal(t, int64(1), countUsers(t, ctx, db))
+
+	attempts = 0
+	err = db.RunInTx(ctx, func(ctx context.Context) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = db.RunInTx(ctx, func(ctx context.Context) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	_, err = db.WithTxRetryPolicy(TxRetryPolicy{})
+	assert.Error(t, err)
+}
+
+type sqlStateTestError string
+
+func (e sqlStateTestError) Error() string    { return "sql state " + string(e) }
+func (e sqlStateTestError) SQLState() string { return string(e) }
+
+func TestIsRetryableTxError(t *testing.T) {
+	assert.True(t, IsRetryableTxError(&mysql.MySQLError{Number: 1213}))
+	assert.True(t, IsRetryableTxError(&mysql.MySQLError{Number: 1205}))
+	assert.False(t, IsRetryableTxError(&mysql.MySQLError{Number: 1062}))
+	assert.True(t, IsRetryableTxError(sqlStateTestError("40P01")))
+	assert.True(t, IsRetryableTxError(sqlStateTestError("40001")))
+	assert.False(t, IsRetryableTxError(sqlStateTestError("23505")))
+	assert.True(t, IsRetryableTxError(errors.New("database is locked")))
+	assert.False(t, IsRetryableTxError(errors.New("no such table")))
+}
@@ -0,0 +1,100 @@
+package sqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateColumnsQuery(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("documents", AutoIncrement, Document{}))
+
+	update, err := s.makeUpdateRow(&Document{Id: 1, Title: "a", Version: 3}, nil, []string{"title"})
+	require.NoError(t, err)
+	clause, args := update.ToSQL()
+	assert.Equal(t, "UPDATE documents SET title = ?, version = ? WHERE id = ? AND version = ?", clause)
+	assert.Equal(t, []interface{}{"a", int64(4), int64(1), int64(3)}, args)
+
+	_, err = s.makeUpdateRow(&Document{Id: 1}, nil, []string{"id"})
+	assert.Error(t, err)
+	_, err = s.makeUpdateRow(&Document{Id: 1}, nil, []string{"missing"})
+	assert.Error(t, err)
+	_, err = s.makeUpdateRow(&Document{Id: 1}, nil, []string{})
+	assert.Error(t, err)
+
+	columns, err := s.changedColumns(
+		&Document{Id: 1, Title: "a", State: "draft", Version: 3},
+		&Document{Id: 1, Title: "b", State: "draft", Version: 4})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"title"}, columns)
+
+	_, err = s.changedColumns(&Document{Id: 1}, &Document{Id: 2})
+	assert.Error(t, err)
+	_, err = s.changedColumns(&User{Id: 1}, &Document{Id: 1})
+	assert.Error(t, err)
+}
+
+func TestSQLiteUpdateColumns(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	mood := testfixtures.CustomType{'o', 'k'}
+	_, err := db.InsertRow(ctx, &User{Name: "Bob", Mood: &mood, ImplicitNull: "x"})
+	require.NoError(t, err)
+
+	// Only the name is written, so the stale mood and implicit_null are kept.
+	require.NoError(t, db.UpdateColumns(ctx, &User{Id: 1, Name: "Alice"}, "name"))
+
+	var users []*User
+	require.NoError(t, db.Query(ctx, &users, nil, nil))
+	require.Len(t, users, 1)
+	assert.Equal(t, "Alice", users[0].Name)
+	assert.Equal(t, &mood, users[0].Mood)
+	assert.Equal(t, "x", users[0].ImplicitNull)
+
+	before := *users[0]
+	after := *users[0]
+	after.ImplicitNull = "y"
+	// A concurrent write to another column is not clobbered.
+	require.NoError(t, db.UpdateColumns(ctx, &User{Id: 1, Name: "Carol"}, "name"))
+	require.NoError(t, db.UpdateRowFrom(ctx, &before, &after))
+	require.NoError(t, db.UpdateRowFrom(ctx, &after, &after))
+
+	users = nil
+	require.NoError(t, db.Query(ctx, &users, nil, nil))
+	require.Len(t, users, 1)
+	assert.Equal(t, "Carol", users[0].Name)
+	assert.Equal(t, "y", users[0].ImplicitNull)
+
+	assert.Error(t, db.UpdateColumns(ctx, &User{Id: 1}))
+}
+
+func TestSQLiteUpdateRowFromVersion(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("documents", AutoIncrement, Document{})
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer testDb.Close()
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+	ctx := context.Background()
+
+	_, err = db.InsertRow(ctx, &Document{Title: "a", State: "draft"})
+	require.NoError(t, err)
+
+	before := Document{Id: 1, Title: "a", State: "draft"}
+	after := before
+	after.State = "published"
+	require.NoError(t, db.UpdateRowFrom(ctx, &before, &after))
+	assert.Equal(t, int64(1), after.Version)
+
+	stale := before
+	stale.Title = "b"
+	assert.Equal(t, ErrStaleRow, db.UpdateRowFrom(ctx, &before, &stale))
+}
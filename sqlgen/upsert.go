@@ -0,0 +1,212 @@
+package sqlgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+)
+
+// UpsertOptions configures how an upsert resolves conflicts with existing rows.
+type UpsertOptions struct {
+	// ConflictColumns are the columns of the unique key that detects
+	// conflicts, or the primary key if empty. MySQL ignores them, as ON
+	// DUPLICATE KEY UPDATE handles conflicts on any unique key.
+	ConflictColumns []string
+
+	// UpdateColumns are the columns set to their inserted values on conflict,
+	// or all columns if empty.
+	UpdateColumns []string
+	// KeepColumns keep their existing values on conflict, for example
+	// created_at.
+	KeepColumns []string
+	// Expressions set columns to SQL expressions on conflict, written in MySQL
+	// syntax, where VALUES(column) is the inserted value. For example:
+	//
+	//   Expressions: map[string]string{"counter": "counter + VALUES(counter)"}
+	Expressions map[string]string
+
+	// IgnoreConflicts leaves conflicting rows unchanged, which count as 0
+	// affected rows. MySQL upserts with INSERT IGNORE, which ignores conflicts
+	// on any unique key and also turns other errors, such as invalid values,
+	// into warnings. PostgreSQL and SQLite upsert with ON CONFLICT DO NOTHING.
+	IgnoreConflicts bool
+}
+
+// An insertIgnoreDialect is a Dialect that ignores conflicts with INSERT
+// IGNORE rather than an upsert clause.
+type insertIgnoreDialect interface {
+	insertIgnore()
+}
+
+// insertIgnores returns whether an upsert with set ignores conflicts with
+// INSERT IGNORE in dialect.
+func insertIgnores(dialect Dialect, set []UpsertSet) bool {
+	_, ok := dialect.(insertIgnoreDialect)
+	return ok && set != nil && len(set) == 0
+}
+
+// upsertInsert returns the statement that an upsert with set starts with.
+func upsertInsert(dialect Dialect, set []UpsertSet) string {
+	if insertIgnores(dialect, set) {
+		return "INSERT IGNORE INTO "
+	}
+	return "INSERT INTO "
+}
+
+// An UpsertSet assigns a column of a conflicting row in an upsert: to its
+// inserted value, or to Expression if set.
+type UpsertSet struct {
+	Column     string
+	Expression string
+
+	// table is the table Expression refers to, used to qualify its column
+	// references where they would be ambiguous.
+	table *Table
+}
+
+// makeUpsertSet returns the assignments of an upsert into table with options,
+// or nil to assign every column.
+func (t *Table) makeUpsertSet(options *UpsertOptions) ([]UpsertSet, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	for _, list := range [][]string{options.ConflictColumns, options.UpdateColumns, options.KeepColumns} {
+		for _, name := range list {
+			if _, ok := t.ColumnsByName[name]; !ok {
+				return nil, fmt.Errorf("unknown column %s", name)
+			}
+		}
+	}
+	for name := range options.Expressions {
+		if _, ok := t.ColumnsByName[name]; !ok {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		if !t.ColumnsByName[name].inserted() {
+			return nil, fmt.Errorf("column %s is not written by upserts", name)
+		}
+	}
+	for _, list := range [][]string{options.UpdateColumns, options.KeepColumns} {
+		for _, name := range list {
+			if !t.ColumnsByName[name].inserted() {
+				return nil, fmt.Errorf("column %s is not written by upserts", name)
+			}
+		}
+	}
+
+	if options.IgnoreConflicts {
+		if len(options.UpdateColumns) > 0 || len(options.Expressions) > 0 {
+			return nil, errors.New("upsert cannot both ignore conflicts and update columns")
+		}
+		return []UpsertSet{}, nil
+	}
+
+	update := make(map[string]bool)
+	for _, name := range options.UpdateColumns {
+		update[name] = true
+	}
+	keep := make(map[string]bool)
+	for _, name := range options.KeepColumns {
+		keep[name] = true
+		if update[name] {
+			return nil, fmt.Errorf("column %s is both updated and kept", name)
+		}
+		if _, ok := options.Expressions[name]; ok {
+			return nil, fmt.Errorf("column %s is both kept and set to an expression", name)
+		}
+	}
+
+	set := []UpsertSet{}
+	for _, column := range t.Columns {
+		if !column.inserted() {
+			continue
+		}
+		if expression, ok := options.Expressions[column.Name]; ok {
+			set = append(set, UpsertSet{Column: column.Name, Expression: expression, table: t})
+			continue
+		}
+		if (len(options.UpdateColumns) > 0 && !update[column.Name]) || keep[column.Name] {
+			continue
+		}
+		set = append(set, UpsertSet{Column: column.Name})
+	}
+	return set, nil
+}
+
+// upsertClause builds the upsert clause of an upsert query in dialect, which
+// is empty if the upsert ignores conflicts with INSERT IGNORE.
+func upsertClause(dialect Dialect, primaryKey []string, conflictColumns []string, columns []string, set []UpsertSet) string {
+	if insertIgnores(dialect, set) {
+		return ""
+	}
+	if len(conflictColumns) == 0 {
+		conflictColumns = primaryKey
+	}
+	if set == nil {
+		set = make([]UpsertSet, 0, len(columns))
+		for _, column := range columns {
+			set = append(set, UpsertSet{Column: column})
+		}
+	}
+	return dialect.UpsertClause(conflictColumns, set)
+}
+
+var upsertValues = regexp.MustCompile(`\bVALUES\((\w+)\)`)
+
+// excludedExpression rewrites an upsert expression for ON CONFLICT DO UPDATE:
+// inserted values referenced as MySQL's VALUES(column) become EXCLUDED.column,
+// and existing values referenced as column become table.column, as a bare
+// column is ambiguous between the table and EXCLUDED.
+func excludedExpression(expression string, table *Table) string {
+	expression = upsertValues.ReplaceAllString(expression, "EXCLUDED.$1")
+	if table == nil {
+		return expression
+	}
+
+	var buffer bytes.Buffer
+	runes := []rune(expression)
+	var quote rune
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '_' || unicode.IsLetter(r):
+			j := i
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			word := string(runes[i:j])
+			if _, ok := table.ColumnsByName[word]; ok && !qualified(runes, i, j) {
+				buffer.WriteString(table.Name)
+				buffer.WriteString(".")
+			}
+			buffer.WriteString(word)
+			i = j - 1
+			continue
+		}
+		buffer.WriteRune(r)
+	}
+	return buffer.String()
+}
+
+// qualified returns whether the identifier runes[start:end] is qualified, as
+// in EXCLUDED.column, qualifies another, or names a function.
+func qualified(runes []rune, start, end int) bool {
+	before := start - 1
+	for before >= 0 && unicode.IsSpace(runes[before]) {
+		before--
+	}
+	after := end
+	for after < len(runes) && unicode.IsSpace(runes[after]) {
+		after++
+	}
+	return (before >= 0 && runes[before] == '.') ||
+		(after < len(runes) && (runes[after] == '.' || runes[after] == '('))
+}
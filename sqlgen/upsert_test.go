@@ -0,0 +1,174 @@
+package sqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type PageView struct {
+	Path      string `sql:",primary"`
+	Slug      string
+	Title     string
+	Views     int64
+	CreatedAt int64
+}
+
+func TestUpsertOptionsQuery(t *testing.T) {
+	s := NewSchema()
+	s.MustRegisterType("page_views", UniqueId, PageView{})
+	options := &UpsertOptions{
+		ConflictColumns: []string{"slug"},
+		KeepColumns:     []string{"path", "slug", "created_at"},
+		Expressions:     map[string]string{"views": "views + VALUES(views)"},
+	}
+
+	query, err := s.MakeUpsertRowWithOptions(&PageView{Path: "/", Views: 1}, options)
+	require.NoError(t, err)
+	clause, _ := query.ToSQL()
+	assert.Equal(t, "INSERT INTO page_views (path, slug, title, views, created_at) VALUES (?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE title=VALUES(title), views=views + VALUES(views)", clause)
+
+	batch, err := s.MakeBatchUpsertRowWithOptions([]interface{}{&PageView{Path: "/"}, &PageView{Path: "/a"}}, options)
+	require.NoError(t, err)
+	clause, _ = NewDBWithDialect(nil, s, PostgreSQL).toSQL(batch)
+	assert.Equal(t, "INSERT INTO page_views (path, slug, title, views, created_at) VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10) "+
+		"ON CONFLICT (slug) DO UPDATE SET title = EXCLUDED.title, views = page_views.views + EXCLUDED.views", clause)
+
+	query, err = s.MakeUpsertRowWithOptions(&PageView{Path: "/"}, &UpsertOptions{UpdateColumns: []string{"title"}})
+	require.NoError(t, err)
+	clause, _ = NewDBWithDialect(nil, s, SQLite).toSQL(query)
+	assert.Equal(t, "INSERT INTO page_views (path, slug, title, views, created_at) VALUES (?, ?, ?, ?, ?) "+
+		"ON CONFLICT (path) DO UPDATE SET title = EXCLUDED.title", clause)
+
+	query, err = s.MakeUpsertRowWithOptions(&PageView{Path: "/"}, &UpsertOptions{IgnoreConflicts: true})
+	require.NoError(t, err)
+	clause, _ = query.ToSQL()
+	assert.Equal(t, "INSERT IGNORE INTO page_views (path, slug, title, views, created_at) VALUES (?, ?, ?, ?, ?)", clause)
+	clause, _ = NewDBWithDialect(nil, s, PostgreSQL).toSQL(query)
+	assert.Equal(t, "INSERT INTO page_views (path, slug, title, views, created_at) VALUES ($1, $2, $3, $4, $5) "+
+		"ON CONFLICT (path) DO NOTHING", clause)
+	batch, err = s.MakeBatchUpsertRowWithOptions([]interface{}{&PageView{Path: "/"}}, &UpsertOptions{IgnoreConflicts: true})
+	require.NoError(t, err)
+	clause, _ = batch.ToSQL()
+	assert.Equal(t, "INSERT IGNORE INTO page_views (path, slug, title, views, created_at) VALUES (?, ?, ?, ?, ?)", clause)
+	clause, _ = NewDBWithDialect(nil, s, SQLite).toSQL(batch)
+	assert.Equal(t, "INSERT INTO page_views (path, slug, title, views, created_at) VALUES (?, ?, ?, ?, ?) "+
+		"ON CONFLICT (path) DO NOTHING", clause)
+
+	for _, options := range []*UpsertOptions{
+		{ConflictColumns: []string{"missing"}},
+		{UpdateColumns: []string{"missing"}},
+		{KeepColumns: []string{"missing"}},
+		{Expressions: map[string]string{"missing": "1"}},
+		{UpdateColumns: []string{"title"}, KeepColumns: []string{"title"}},
+		{KeepColumns: []string{"views"}, Expressions: map[string]string{"views": "views + 1"}},
+		{IgnoreConflicts: true, UpdateColumns: []string{"title"}},
+	} {
+		_, err := s.MakeUpsertRowWithOptions(&PageView{Path: "/"}, options)
+		assert.Error(t, err)
+	}
+}
+
+func TestSQLiteUpsertOptions(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("page_views", UniqueId, PageView{})
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer testDb.Close()
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+	ctx := context.Background()
+
+	require.NoError(t, db.InsertRows(ctx, []*PageView{
+		{Path: "/", Title: "Home", Views: 1, CreatedAt: 100},
+		{Path: "/about", Title: "About", Views: 2, CreatedAt: 100},
+	}, 10))
+
+	counting := &UpsertOptions{
+		KeepColumns: []string{"created_at"},
+		Expressions: map[string]string{"views": "views + VALUES(views)"},
+	}
+	require.NoError(t, db.UpsertRowsWithOptions(ctx, []*PageView{
+		{Path: "/", Title: "Welcome", Views: 3, CreatedAt: 200},
+		{Path: "/about", Title: "About us", Views: 4, CreatedAt: 200},
+		{Path: "/new", Title: "New", Views: 5, CreatedAt: 200},
+	}, 2, counting))
+
+	var views []*PageView
+	require.NoError(t, db.Query(ctx, &views, nil, &SelectOptions{OrderBy: "path"}))
+	assert.Equal(t, []*PageView{
+		{Path: "/", Title: "Welcome", Views: 4, CreatedAt: 100},
+		{Path: "/about", Title: "About us", Views: 6, CreatedAt: 100},
+		{Path: "/new", Title: "New", Views: 5, CreatedAt: 200},
+	}, views)
+
+	// Ignored conflicts affect no rows.
+	res, err := db.UpsertRowWithOptions(ctx, &PageView{Path: "/", Title: "Ignored", Views: 10}, &UpsertOptions{IgnoreConflicts: true})
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), affected)
+	res, err = db.UpsertRowWithOptions(ctx, &PageView{Path: "/other", Title: "Other"}, &UpsertOptions{IgnoreConflicts: true})
+	require.NoError(t, err)
+	affected, err = res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	require.NoError(t, db.UpsertRowsWithOptions(ctx, []*PageView{
+		{Path: "/", Title: "Ignored"},
+		{Path: "/batch", Title: "Batch"},
+	}, 10, &UpsertOptions{IgnoreConflicts: true}))
+
+	var home *PageView
+	require.NoError(t, db.QueryRow(ctx, &home, Filter{"path": "/"}, nil))
+	assert.Equal(t, &PageView{Path: "/", Title: "Welcome", Views: 4, CreatedAt: 100}, home)
+	count, err := db.Count(ctx, &PageView{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+}
+
+func TestExcludedExpression(t *testing.T) {
+	s := NewSchema()
+	s.MustRegisterType("page_views", UniqueId, PageView{})
+	table := s.ByName["page_views"]
+
+	for expression, expected := range map[string]string{
+		"views + VALUES(views)":            "page_views.views + EXCLUDED.views",
+		"GREATEST(views, VALUES(views))":   "GREATEST(page_views.views, EXCLUDED.views)",
+		"page_views.views + 1":             "page_views.views + 1",
+		"IF(title = 'views', views, 0)":    "IF(page_views.title = 'views', page_views.views, 0)",
+		"COALESCE(title, VALUES(title)) ":  "COALESCE(page_views.title, EXCLUDED.title) ",
+		"views_total + VALUES(created_at)": "views_total + EXCLUDED.created_at",
+	} {
+		assert.Equal(t, expected, excludedExpression(expression, table), expression)
+	}
+}
+
+func TestUpsertIgnoreConflictsRowsAffected(t *testing.T) {
+	tdb, db, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdb.Close()
+	ctx := context.Background()
+
+	// INSERT IGNORE leaves the row unchanged, which counts as no affected
+	// rows, like DO NOTHING.
+	options := &UpsertOptions{IgnoreConflicts: true}
+	res, err := db.UpsertRowWithOptions(ctx, &JustId{Id: 1}, options)
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	res, err = db.UpsertRowWithOptions(ctx, &JustId{Id: 1}, options)
+	require.NoError(t, err)
+	affected, err = res.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), affected)
+}
@@ -0,0 +1,100 @@
+package sqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samson-crypto/thunder/internal/testfixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Document struct {
+	Id      int64 `sql:",primary"`
+	Title   string
+	State   string
+	Version int64 `sql:",version"`
+}
+
+func TestVersionColumn(t *testing.T) {
+	s := NewSchema()
+	require.NoError(t, s.RegisterType("documents", AutoIncrement, Document{}))
+
+	update, err := s.MakeUpdateRow(&Document{Id: 1, Title: "a", Version: 3})
+	require.NoError(t, err)
+	clause, args := update.ToSQL()
+	assert.Equal(t, "UPDATE documents SET title = ?, state = ?, version = ? WHERE id = ? AND version = ?", clause)
+	assert.Equal(t, []interface{}{"a", "", int64(4), int64(1), int64(3)}, args)
+
+	remove, err := s.MakeDeleteRow(&Document{Id: 1, Version: 3})
+	require.NoError(t, err)
+	clause, args = remove.ToSQL()
+	assert.Equal(t, "DELETE FROM documents WHERE id = ? AND version = ?", clause)
+	assert.Equal(t, []interface{}{int64(1), int64(3)}, args)
+
+	update, err = s.makeUpdateRow(&Document{Id: 1, Version: 3}, Filter{"state": In("draft", "review")}, nil)
+	require.NoError(t, err)
+	clause, _ = update.ToSQL()
+	assert.Equal(t, "UPDATE documents SET title = ?, state = ?, version = ? WHERE id = ? AND version = ? AND state IN (?, ?)", clause)
+
+	assert.Error(t, s.RegisterType("bad_versions", AutoIncrement, struct {
+		Id      int64  `sql:",primary"`
+		Version string `sql:",version"`
+	}{}))
+	assert.Error(t, s.RegisterType("two_versions", AutoIncrement, struct {
+		Id int64 `sql:",primary"`
+		A  int64 `sql:",version"`
+		B  int64 `sql:",version"`
+	}{}))
+}
+
+func TestSQLiteVersionColumn(t *testing.T) {
+	schema := NewSchema()
+	schema.MustRegisterType("documents", AutoIncrement, Document{})
+	statements, err := schema.CreateTableStatements(SQLite)
+	require.NoError(t, err)
+	testDb, err := testfixtures.NewSQLiteTestDatabase(statements...)
+	require.NoError(t, err)
+	defer testDb.Close()
+	db := NewDBWithDialect(testDb.DB, schema, SQLite)
+	ctx := context.Background()
+
+	_, err = db.InsertRow(ctx, &Document{Title: "draft", State: "draft"})
+	require.NoError(t, err)
+
+	first := &Document{Id: 1, Title: "first", State: "draft"}
+	second := &Document{Id: 1, Title: "second", State: "draft"}
+	require.NoError(t, db.UpdateRow(ctx, first))
+	assert.Equal(t, int64(1), first.Version)
+	assert.Equal(t, ErrStaleRow, db.UpdateRow(ctx, second))
+	assert.Equal(t, int64(0), second.Version)
+	assert.Equal(t, ErrStaleRow, db.DeleteRow(ctx, second))
+
+	first.State = "published"
+	assert.Equal(t, ErrStaleRow, db.UpdateRowWhere(ctx, first, Filter{"state": "review"}))
+	require.NoError(t, db.UpdateRowWhere(ctx, first, Filter{"state": "draft"}))
+	assert.Equal(t, int64(2), first.Version)
+
+	var documents []*Document
+	require.NoError(t, db.Query(ctx, &documents, nil, nil))
+	assert.Equal(t, []*Document{{Id: 1, Title: "first", State: "published", Version: 2}}, documents)
+
+	require.NoError(t, db.DeleteRow(ctx, first))
+	assert.Equal(t, ErrStaleRow, db.UpdateRow(ctx, first))
+}
+
+func TestSQLiteUpdateRowWhere(t *testing.T) {
+	testDb, db := setupSQLite(t)
+	defer testDb.Close()
+	ctx := context.Background()
+
+	_, err := db.InsertRow(ctx, &User{Name: "Bob"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrStaleRow, db.UpdateRowWhere(ctx, &User{Id: 1, Name: "Alice"}, Filter{"name": "Carol"}))
+	require.NoError(t, db.UpdateRowWhere(ctx, &User{Id: 1, Name: "Alice"}, Filter{"name": "Bob"}))
+	assert.Equal(t, ErrStaleRow, db.UpdateRowWhere(ctx, &User{Id: 2, Name: "Alice"}, nil))
+
+	// Rows without a version column are updated blindly.
+	require.NoError(t, db.UpdateRow(ctx, &User{Id: 2, Name: "Alice"}))
+}